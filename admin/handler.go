@@ -0,0 +1,346 @@
+//Package admin 提供查看与编辑gomap中数据的HTTP/JSON接口。
+//
+//	GET    /maps                       列出已注册的map
+//	GET    /maps/{name}/keys           列出key，支持prefix、cursor、limit参数
+//	GET    /maps/{name}/entries/{key}  读取值及过期信息
+//	PUT    /maps/{name}/entries/{key}  写入值，body为{"value": ..., "ttl_ms": ...}
+//	DELETE /maps/{name}/entries/{key}  删除
+//	POST   /maps/{name}/expire         立即清理过期数据
+//
+// 只读模式下所有修改请求返回403。
+package admin
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+	maxBodySize  = 1 << 20
+)
+
+type (
+	Handler struct {
+		mu       sync.RWMutex         // 锁
+		maps     map[string]gomap.Map // 已注册的map
+		readOnly bool                 // 只读模式
+	}
+
+	// 以下为各map可选支持的能力
+	ttlGetter interface {
+		TTL(key string) (time.Duration, bool)
+	}
+	ttlStorer interface {
+		StoreWithTTL(key string, value interface{}, ttl time.Duration)
+	}
+	expirer interface {
+		DeleteExpired() map[string]interface{}
+	}
+	linkedExpirer interface {
+		DeleteExpired() []gomap.Entry
+	}
+
+	mapInfo struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Size int    `json:"size"`
+	}
+
+	keysResult struct {
+		Keys   []string `json:"keys"`
+		Cursor string   `json:"cursor,omitempty"` // 下一页游标，为空表示没有更多数据
+	}
+
+	entryResult struct {
+		Key       string      `json:"key"`
+		Value     interface{} `json:"value"`
+		TTL       *int64      `json:"ttl_ms,omitempty"`     // 剩余毫秒数，-1表示永不过期
+		ExpiresAt *time.Time  `json:"expires_at,omitempty"` // 过期时间
+	}
+
+	entryRequest struct {
+		Value interface{} `json:"value"`
+		TTL   *int64      `json:"ttl_ms"` // 为空时使用map默认过期时间，<=0表示永不过期
+	}
+
+	// keyHeap key的最大堆，分页时只保留遍历到的最小若干个key
+	keyHeap []string
+)
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return key
+}
+
+func NewHandler(readOnly bool) *Handler {
+	return &Handler{
+		maps:     map[string]gomap.Map{},
+		readOnly: readOnly,
+	}
+}
+
+//Register 以name注册map，重复注册时覆盖
+func (h *Handler) Register(name string, m gomap.Map) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.maps[name] = m
+}
+
+func (h *Handler) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.maps, name)
+}
+
+func (h *Handler) lookup(name string) (gomap.Map, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	m, ok := h.maps[name]
+	return m, ok
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 4)
+	for i := range parts {
+		part, err := url.PathUnescape(parts[i])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid path")
+			return
+		}
+		parts[i] = part
+	}
+	if parts[0] != "maps" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if len(parts) == 1 || (len(parts) == 2 && parts[1] == "") {
+		h.allow(w, r, http.MethodGet, h.listMaps)
+		return
+	}
+	m, ok := h.lookup(parts[1])
+	if !ok {
+		writeError(w, http.StatusNotFound, "map not found")
+		return
+	}
+	switch {
+	case len(parts) == 3 && parts[2] == "keys":
+		h.allow(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			listKeys(w, r, m)
+		})
+	case len(parts) == 3 && parts[2] == "expire":
+		h.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			deleteExpired(w, m)
+		})
+	case len(parts) == 4 && parts[2] == "entries" && parts[3] != "":
+		key := parts[3]
+		switch r.Method {
+		case http.MethodGet:
+			getEntry(w, m, key)
+		case http.MethodPut:
+			h.allow(w, r, http.MethodPut, func(w http.ResponseWriter, r *http.Request) {
+				putEntry(w, r, m, key)
+			})
+		case http.MethodDelete:
+			h.allow(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) {
+				deleteEntry(w, m, key)
+			})
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+//allow 校验请求方法，只读模式下拒绝修改请求
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, method string, f http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if h.readOnly && method != http.MethodGet {
+		writeError(w, http.StatusForbidden, "read-only mode")
+		return
+	}
+	f(w, r)
+}
+
+func (h *Handler) listMaps(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	infos := make([]mapInfo, 0, len(h.maps))
+	for name, m := range h.maps {
+		infos = append(infos, mapInfo{
+			Name: name,
+			Type: strings.TrimPrefix(fmt.Sprintf("%T", m), "*gomap."),
+			Size: m.Size(),
+		})
+	}
+	h.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	writeJSON(w, http.StatusOK, infos)
+}
+
+//listKeys 按字典序分页列出key，cursor为上一页最后一个key。遍历时只在堆中保留最小的limit+1个key，内存与排序开销不随map大小增长
+func listKeys(w http.ResponseWriter, r *http.Request, m gomap.Map) {
+	query := r.URL.Query()
+	prefix, cursor := query.Get("prefix"), query.Get("cursor")
+	limit := defaultLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		if n > maxLimit {
+			n = maxLimit
+		}
+		limit = n
+	}
+	h := make(keyHeap, 0, limit+1)
+	rangeKeys(m, func(key string) {
+		if !strings.HasPrefix(key, prefix) || key <= cursor {
+			return
+		}
+		if len(h) <= limit {
+			heap.Push(&h, key)
+		} else if key < h[0] {
+			h[0] = key
+			heap.Fix(&h, 0)
+		}
+	})
+	keys := []string(h)
+	sort.Strings(keys)
+	result := keysResult{Keys: keys}
+	if len(keys) > limit {
+		result.Keys = keys[:limit]
+		result.Cursor = keys[limit-1]
+	}
+	if result.Keys == nil {
+		result.Keys = []string{}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+//rangeKeys 遍历key，map支持过期时使用PeekRange，避免列出key时为renewOnLoad的map续租
+func rangeKeys(m gomap.Map, f func(key string)) {
+	if em, ok := m.(gomap.ExpirableMap); ok {
+		em.PeekRange(func(key string, value interface{}, expiresAt time.Time) bool {
+			f(key)
+			return true
+		})
+		return
+	}
+	m.Range(func(key, value interface{}) bool {
+		f(key.(string))
+		return true
+	})
+}
+
+//peek 读取key，map支持过期时使用Peek，避免查看数据时为renewOnLoad的map续租
+func peek(m gomap.Map, key string) (interface{}, bool) {
+	if em, ok := m.(gomap.ExpirableMap); ok {
+		return em.Peek(key)
+	}
+	return m.Load(key)
+}
+
+func getEntry(w http.ResponseWriter, m gomap.Map, key string) {
+	value, ok := peek(m, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	result := entryResult{Key: key, Value: value}
+	if tm, ok := m.(ttlGetter); ok {
+		if ttl, ok := tm.TTL(key); ok {
+			if ttl == gomap.NoExpiration {
+				ms := int64(-1)
+				result.TTL = &ms
+			} else {
+				ms := int64(ttl / time.Millisecond)
+				expiresAt := time.Now().Add(ttl).UTC()
+				result.TTL, result.ExpiresAt = &ms, &expiresAt
+			}
+		}
+	}
+	if _, err := json.Marshal(result.Value); err != nil {
+		result.Value = fmt.Sprint(value)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func putEntry(w http.ResponseWriter, r *http.Request, m gomap.Map, key string) {
+	var req entryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	if req.TTL == nil {
+		m.Store(key, req.Value)
+	} else if tm, ok := m.(ttlStorer); ok {
+		ttl := gomap.NoExpiration
+		if *req.TTL > 0 {
+			ttl = time.Duration(*req.TTL) * time.Millisecond
+		}
+		tm.StoreWithTTL(key, req.Value, ttl)
+	} else {
+		writeError(w, http.StatusBadRequest, "map does not support ttl")
+		return
+	}
+	getEntry(w, m, key)
+}
+
+func deleteEntry(w http.ResponseWriter, m gomap.Map, key string) {
+	if _, ok := peek(m, key); !ok {
+		writeError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	m.Delete(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteExpired(w http.ResponseWriter, m gomap.Map) {
+	var deleted int
+	switch em := m.(type) {
+	case expirer:
+		deleted = len(em.DeleteExpired())
+	case linkedExpirer:
+		deleted = len(em.DeleteExpired())
+	default:
+		writeError(w, http.StatusBadRequest, "map does not support expiration")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
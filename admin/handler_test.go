@@ -0,0 +1,137 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+func do(t *testing.T, h http.Handler, method, target, body string) (int, string) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	b, _ := ioutil.ReadAll(rec.Body)
+	return rec.Code, strings.TrimSpace(string(b))
+}
+
+func expect(t *testing.T, h http.Handler, method, target, body string, code int, want string) {
+	gotCode, got := do(t, h, method, target, body)
+	if gotCode != code || (want != "" && got != want) {
+		t.Fatalf("%s %s: want %d %s, got %d %s", method, target, code, want, gotCode, got)
+	}
+}
+
+func newTestHandler(readOnly bool) *Handler {
+	h := NewHandler(readOnly)
+	m := gomap.NewTTLMap(-1, -1, false)
+	for i := 0; i < 5; i++ {
+		m.Store("user:"+strconv.Itoa(i), i)
+	}
+	m.Store("other", "x")
+	h.Register("ttl", m)
+	h.Register("linked", gomap.NewLinkedMap())
+	return h
+}
+
+func TestHandler_ListMaps(t *testing.T) {
+	h := newTestHandler(false)
+	expect(t, h, "GET", "/maps", "", 200, `[{"name":"linked","type":"LinkedMap","size":0},{"name":"ttl","type":"TTLMap","size":6}]`)
+	expect(t, h, "POST", "/maps", "", 405, "")
+	expect(t, h, "GET", "/other", "", 404, "")
+}
+
+func TestHandler_Keys(t *testing.T) {
+	h := newTestHandler(false)
+	expect(t, h, "GET", "/maps/ttl/keys?prefix=user:&limit=3", "", 200, `{"keys":["user:0","user:1","user:2"],"cursor":"user:2"}`)
+	expect(t, h, "GET", "/maps/ttl/keys?prefix=user:&limit=3&cursor=user:2", "", 200, `{"keys":["user:3","user:4"]}`)
+	expect(t, h, "GET", "/maps/linked/keys", "", 200, `{"keys":[]}`)
+	expect(t, h, "GET", "/maps/none/keys", "", 404, "")
+}
+
+func TestHandler_KeysPaging(t *testing.T) {
+	h := NewHandler(false)
+	m := gomap.NewTTLMap(-1, -1, false)
+	for i := 0; i < 1000; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	h.Register("m", m)
+	// 逐页读取应按字典序不重不漏地列出所有key
+	var keys []string
+	cursor := ""
+	for {
+		var page struct {
+			Keys   []string `json:"keys"`
+			Cursor string   `json:"cursor"`
+		}
+		_, body := do(t, h, "GET", "/maps/m/keys?limit=7&cursor="+cursor, "")
+		if err := json.Unmarshal([]byte(body), &page); err != nil || len(page.Keys) > 7 {
+			t.Fatal("page", body, err)
+		}
+		keys = append(keys, page.Keys...)
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+	if len(keys) != 1000 || !sort.StringsAreSorted(keys) {
+		t.Fatal("keys not paged in order", len(keys))
+	}
+}
+
+func TestHandler_Entries(t *testing.T) {
+	h := newTestHandler(false)
+	expect(t, h, "GET", "/maps/ttl/entries/user:1", "", 200, `{"key":"user:1","value":1,"ttl_ms":-1}`)
+	expect(t, h, "GET", "/maps/ttl/entries/none", "", 404, "")
+	expect(t, h, "PUT", "/maps/ttl/entries/a%2Fb", `{"value":{"name":"x"}}`, 200, `{"key":"a/b","value":{"name":"x"},"ttl_ms":-1}`)
+	_, body := do(t, h, "PUT", "/maps/ttl/entries/c", `{"value":[1,2],"ttl_ms":60000}`)
+	t.Log(body)
+	expect(t, h, "PUT", "/maps/linked/entries/c", `{"value":1,"ttl_ms":1000}`, 400, "")
+	expect(t, h, "PUT", "/maps/linked/entries/c", `{"value":1}`, 200, `{"key":"c","value":1}`)
+	expect(t, h, "PUT", "/maps/ttl/entries/c", `{bad`, 400, "")
+	expect(t, h, "DELETE", "/maps/ttl/entries/a%2Fb", "", 204, "")
+	expect(t, h, "DELETE", "/maps/ttl/entries/a%2Fb", "", 404, "")
+}
+
+func TestHandler_Expire(t *testing.T) {
+	h := newTestHandler(false)
+	m := gomap.NewLinkedTTLMap(-1, -1, false)
+	m.StoreWithTTL("a", 1, time.Millisecond)
+	h.Register("linkedttl", m)
+	time.Sleep(10 * time.Millisecond)
+	expect(t, h, "POST", "/maps/linkedttl/expire", "", 200, `{"deleted":1}`)
+	expect(t, h, "POST", "/maps/ttl/expire", "", 200, `{"deleted":0}`)
+	expect(t, h, "POST", "/maps/linked/expire", "", 400, "")
+}
+
+func TestHandler_NoRenew(t *testing.T) {
+	h := NewHandler(false)
+	m := gomap.NewTTLMap(time.Minute, -1, true)
+	m.StoreWithTTL("a", 1, 50*time.Millisecond)
+	h.Register("renew", m)
+	// 查看数据不应为renewOnLoad的map续租
+	expect(t, h, "GET", "/maps/renew/entries/a", "", 200, "")
+	if ttl, _ := m.TTL("a"); ttl > 50*time.Millisecond {
+		t.Fatal("entry renewed by GET", ttl)
+	}
+	expect(t, h, "GET", "/maps/renew/keys", "", 200, `{"keys":["a"]}`)
+	if ttl, _ := m.TTL("a"); ttl > 50*time.Millisecond {
+		t.Fatal("entry renewed by listing keys", ttl)
+	}
+	expect(t, h, "DELETE", "/maps/renew/entries/a", "", 204, "")
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	h := newTestHandler(true)
+	expect(t, h, "GET", "/maps/ttl/entries/user:1", "", 200, "")
+	expect(t, h, "PUT", "/maps/ttl/entries/user:1", `{"value":2}`, 403, "")
+	expect(t, h, "DELETE", "/maps/ttl/entries/user:1", "", 403, "")
+	expect(t, h, "POST", "/maps/ttl/expire", "", 403, "")
+}
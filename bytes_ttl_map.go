@@ -0,0 +1,605 @@
+package gomap
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+type (
+	// BytesTTLMap key与[]byte值存放在预分配的大块字节数组中的TTLMap，索引为不含指针的hash→偏移量，
+	// 数据量很大时GC无需扫描每个元素。语义与TTLMap一致，值在存入与读取时复制。
+	BytesTTLMap struct {
+		shards      []*bytesShard
+		mask        uint64
+		exit        chan bool     // 退出标志
+		gcInterval  time.Duration // 清理周期
+		expiration  time.Duration // 过期时间
+		renewOnLoad bool          // 读取时续租时间
+	}
+
+	BytesOption func(m *bytesConfig)
+
+	bytesConfig struct {
+		shards   int
+		slabSize int
+	}
+
+	// bytesShard 分片，slab中依次存放记录：过期时间(8)|hash(8)|key长度(4)|值长度(4)|key|值
+	bytesShard struct {
+		mu       sync.RWMutex
+		slab     []byte              // 记录数据，len为已使用部分
+		index    map[uint64]uint32   // hash→记录偏移量
+		overflow map[uint64][]uint32 // hash冲突时其余记录的偏移量
+		count    int                 // 记录数量
+		dead     int                 // 已删除或被覆盖的记录占用的字节数
+	}
+)
+
+const (
+	bytesHeaderSize = 24
+	ErrSlabFull     = "ErrSlabFull"
+)
+
+//BytesShards 设置分片数量，向上取整为2的幂，默认256
+func BytesShards(n int) BytesOption {
+	return func(c *bytesConfig) {
+		if n > 0 {
+			c.shards = n
+		}
+	}
+}
+
+//BytesSlabSize 设置每个分片预分配的字节数，默认64KB，不足时按倍数扩容
+func BytesSlabSize(size int) BytesOption {
+	return func(c *bytesConfig) {
+		if size > 0 {
+			c.slabSize = size
+		}
+	}
+}
+
+func NewBytesTTLMap(expiration, gcInterval time.Duration, renewOnLoad bool, opts ...BytesOption) *BytesTTLMap {
+	cfg := bytesConfig{shards: 256, slabSize: 64 << 10}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	n := 1
+	for n < cfg.shards {
+		n <<= 1
+	}
+	m := &BytesTTLMap{
+		shards:      make([]*bytesShard, n),
+		mask:        uint64(n - 1),
+		exit:        make(chan bool),
+		gcInterval:  gcInterval,
+		expiration:  expiration,
+		renewOnLoad: renewOnLoad,
+	}
+	for i := range m.shards {
+		m.shards[i] = &bytesShard{
+			slab:     make([]byte, 0, cfg.slabSize),
+			index:    map[uint64]uint32{},
+			overflow: map[uint64][]uint32{},
+		}
+	}
+	if expiration > 0 || gcInterval > 0 {
+		go m.gcLoop()
+	}
+	return m
+}
+
+//hashKey FNV-1a
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func (m *BytesTTLMap) shard(key string) (*bytesShard, uint64) {
+	h := hashKey(key)
+	return m.shards[h&m.mask], h
+}
+
+//gcLoop 过期清理轮询
+func (m *BytesTTLMap) gcLoop() {
+	if m.gcInterval <= 0 {
+		m.gcInterval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(m.gcInterval)
+	for {
+		select {
+		case <-ticker.C:
+			if _, ok := m.deleteExpired(); !ok {
+				ticker.Stop()
+				return
+			}
+		case <-m.exit:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+//DeleteExpired 删除过期数据项，返回删除的数量。与TTLMap不同，不返回被删除的值以免大量分配
+func (m *BytesTTLMap) DeleteExpired() int {
+	n, ok := m.deleteExpired()
+	if !ok {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return n
+}
+
+//deleteExpired 逐个分片删除过期数据，map已销毁时返回false
+func (m *BytesTTLMap) deleteExpired() (int, bool) {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		if s.index == nil {
+			s.mu.Unlock()
+			return n, false
+		}
+		n += s.deleteExpired(time.Now().UnixNano())
+		s.mu.Unlock()
+	}
+	return n, true
+}
+
+//lockLoad 读取时加锁，需要续租时加写锁
+func (m *BytesTTLMap) lockLoad(s *bytesShard) func() {
+	if m.renewOnLoad {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
+	s.mu.RLock()
+	return s.mu.RUnlock
+}
+
+//renew 续租，需持有写锁
+func (m *BytesTTLMap) renew(s *bytesShard, off uint32) {
+	if m.renewOnLoad && m.expiration > 0 && s.expiration(off) > 0 {
+		s.setExpiration(off, time.Now().Add(m.expiration).UnixNano())
+	}
+}
+
+func (m *BytesTTLMap) Store(key string, value []byte) {
+	m.StoreWithTTL(key, value, DefaultExpiration)
+}
+
+//StoreWithTTL 存储key-val并指定过期时间
+func (m *BytesTTLMap) StoreWithTTL(key string, value []byte, ttl time.Duration) {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	s.store(h, key, value, expireAt(ttl, m.expiration))
+}
+
+//Load 返回值的副本
+func (m *BytesTTLMap) Load(key string) (value []byte, ok bool) {
+	s, h := m.shard(key)
+	defer m.lockLoad(s)()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	off, ok := s.find(h, key)
+	if !ok || s.expired(off, time.Now().UnixNano()) {
+		// 过期数据由DeleteExpired清理
+		return nil, false
+	}
+	m.renew(s, off)
+	return append([]byte(nil), s.value(off)...), true
+}
+
+func (m *BytesTTLMap) LoadOrStore(key string, value []byte) (actual []byte, loaded bool) {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if off, ok := s.find(h, key); ok && !s.expired(off, time.Now().UnixNano()) {
+		m.renew(s, off)
+		return append([]byte(nil), s.value(off)...), true
+	}
+	s.store(h, key, value, expireAt(DefaultExpiration, m.expiration))
+	return value, false
+}
+
+//StoreOrCompare 比较并存储compare返回值，stored指向map内部数据，只在compare执行期间有效
+func (m *BytesTTLMap) StoreOrCompare(key string, value []byte, compare func(stored []byte, input []byte) []byte) {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if off, ok := s.find(h, key); ok && !s.expired(off, time.Now().UnixNano()) && compare != nil {
+		value = compare(s.value(off), value)
+	}
+	s.store(h, key, value, expireAt(DefaultExpiration, m.expiration))
+}
+
+func (m *BytesTTLMap) Delete(key string) []byte {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	off, ok := s.find(h, key)
+	if !ok {
+		return nil
+	}
+	var value []byte
+	if !s.expired(off, time.Now().UnixNano()) {
+		value = append([]byte(nil), s.value(off)...)
+	}
+	s.remove(h, off)
+	return value
+}
+
+//Clear 清空并返回未过期的数据，Entry.Value为[]byte
+func (m *BytesTTLMap) Clear() []Entry {
+	var entries []Entry
+	now := time.Now().UnixNano()
+	for _, s := range m.shards {
+		s.mu.Lock()
+		if s.index == nil {
+			s.mu.Unlock()
+			panic(errors.New(ErrMapDestroyed))
+		}
+		s.each(func(off uint32) bool {
+			if !s.expired(off, now) {
+				entries = append(entries, Entry{Key: string(s.key(off)), Value: append([]byte(nil), s.value(off)...)})
+			}
+			return true
+		})
+		s.slab = s.slab[:0]
+		s.index = map[uint64]uint32{}
+		s.overflow = map[uint64][]uint32{}
+		s.count = 0
+		s.dead = 0
+		s.mu.Unlock()
+	}
+	return entries
+}
+
+//Range 遍历未过期的数据，value指向map内部数据，只在f执行期间有效；f中不能修改该map
+func (m *BytesTTLMap) Range(f func(key string, value []byte) bool) {
+	now := time.Now().UnixNano()
+	for _, s := range m.shards {
+		unlock := m.lockLoad(s)
+		if s.index == nil {
+			unlock()
+			panic(errors.New(ErrMapDestroyed))
+		}
+		next := s.each(func(off uint32) bool {
+			if s.expired(off, now) {
+				return true
+			}
+			m.renew(s, off)
+			return f(string(s.key(off)), s.value(off))
+		})
+		unlock()
+		if !next {
+			return
+		}
+	}
+}
+
+func (m *BytesTTLMap) Destroy() {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if m.shards[0].index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	close(m.exit)
+	for _, s := range m.shards {
+		s.slab = nil
+		s.index = nil
+		s.overflow = nil
+		s.count = 0
+		s.dead = 0
+	}
+}
+
+//Size 数据数量，包括已过期但尚未清理的数据
+func (m *BytesTTLMap) Size() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.RLock()
+		if s.index == nil {
+			s.mu.RUnlock()
+			panic(errors.New(ErrMapDestroyed))
+		}
+		n += s.count
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+//MemoryUsage 估算map当前占用的内存字节数，包括slab中尚未回收的空间
+func (m *BytesTTLMap) MemoryUsage() int64 {
+	size := int64(0)
+	for _, s := range m.shards {
+		s.mu.RLock()
+		if s.index == nil {
+			s.mu.RUnlock()
+			panic(errors.New(ErrMapDestroyed))
+		}
+		size += 2*mapHeaderSize + int64(cap(s.slab)) + int64(len(s.index))*(12+mapEntryOverhead)
+		for _, offs := range s.overflow {
+			size += 32 + mapEntryOverhead + int64(cap(offs))*4
+		}
+		s.mu.RUnlock()
+	}
+	return size
+}
+
+//TTL 返回key剩余存活时间，永不过期时返回NoExpiration，key不存在时ok为false
+func (m *BytesTTLMap) TTL(key string) (ttl time.Duration, ok bool) {
+	s, h := m.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	now := time.Now().UnixNano()
+	off, ok := s.find(h, key)
+	if !ok || s.expired(off, now) {
+		return 0, false
+	}
+	expiration := s.expiration(off)
+	if expiration <= 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(expiration - now), true
+}
+
+//Expire 重新设置key的过期时间，ttl<=0时key立即过期，key不存在时返回false
+func (m *BytesTTLMap) Expire(key string, ttl time.Duration) bool {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	off, ok := s.find(h, key)
+	if !ok || s.expired(off, time.Now().UnixNano()) {
+		return false
+	}
+	if ttl <= 0 {
+		s.remove(h, off)
+		return true
+	}
+	s.setExpiration(off, time.Now().Add(ttl).UnixNano())
+	return true
+}
+
+//Persist 移除key的过期时间，key不存在或本就永不过期时返回false
+func (m *BytesTTLMap) Persist(key string) bool {
+	s, h := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	off, ok := s.find(h, key)
+	if !ok || s.expired(off, time.Now().UnixNano()) || s.expiration(off) <= 0 {
+		return false
+	}
+	s.setExpiration(off, -1)
+	return true
+}
+
+func (s *bytesShard) expiration(off uint32) int64 {
+	return int64(binary.LittleEndian.Uint64(s.slab[off:]))
+}
+
+func (s *bytesShard) setExpiration(off uint32, expiration int64) {
+	binary.LittleEndian.PutUint64(s.slab[off:], uint64(expiration))
+}
+
+func (s *bytesShard) expired(off uint32, now int64) bool {
+	expiration := s.expiration(off)
+	return expiration > 0 && now > expiration
+}
+
+func (s *bytesShard) hash(off uint32) uint64 {
+	return binary.LittleEndian.Uint64(s.slab[off+8:])
+}
+
+func (s *bytesShard) key(off uint32) []byte {
+	keyLen := binary.LittleEndian.Uint32(s.slab[off+16:])
+	start := off + bytesHeaderSize
+	return s.slab[start : start+keyLen]
+}
+
+func (s *bytesShard) value(off uint32) []byte {
+	keyLen := binary.LittleEndian.Uint32(s.slab[off+16:])
+	valLen := binary.LittleEndian.Uint32(s.slab[off+20:])
+	start := off + bytesHeaderSize + keyLen
+	return s.slab[start : start+valLen : start+valLen]
+}
+
+//size 记录总长度
+func (s *bytesShard) size(off uint32) int {
+	return bytesHeaderSize + int(binary.LittleEndian.Uint32(s.slab[off+16:])) + int(binary.LittleEndian.Uint32(s.slab[off+20:]))
+}
+
+//find 查找key所在记录
+func (s *bytesShard) find(h uint64, key string) (uint32, bool) {
+	off, ok := s.index[h]
+	if !ok {
+		return 0, false
+	}
+	if string(s.key(off)) == key {
+		return off, true
+	}
+	for _, off := range s.overflow[h] {
+		if string(s.key(off)) == key {
+			return off, true
+		}
+	}
+	return 0, false
+}
+
+//live 判断偏移量处的记录是否仍被索引引用
+func (s *bytesShard) live(h uint64, off uint32) bool {
+	if cur, ok := s.index[h]; !ok {
+		return false
+	} else if cur == off {
+		return true
+	}
+	for _, cur := range s.overflow[h] {
+		if cur == off {
+			return true
+		}
+	}
+	return false
+}
+
+//relink 将索引中的old替换为off，old为0xffffffff时新增
+func (s *bytesShard) relink(h uint64, old, off uint32) {
+	cur, ok := s.index[h]
+	if !ok {
+		s.index[h] = off
+		return
+	}
+	if cur == old {
+		s.index[h] = off
+		return
+	}
+	offs := s.overflow[h]
+	for i, cur := range offs {
+		if cur == old {
+			offs[i] = off
+			return
+		}
+	}
+	s.overflow[h] = append(offs, off)
+}
+
+//unlink 从索引中移除记录
+func (s *bytesShard) unlink(h uint64, off uint32) {
+	offs := s.overflow[h]
+	if s.index[h] == off {
+		if len(offs) == 0 {
+			delete(s.index, h)
+			return
+		}
+		s.index[h] = offs[len(offs)-1]
+		offs = offs[:len(offs)-1]
+	} else {
+		for i, cur := range offs {
+			if cur == off {
+				offs[i] = offs[len(offs)-1]
+				offs = offs[:len(offs)-1]
+				break
+			}
+		}
+	}
+	if len(offs) == 0 {
+		delete(s.overflow, h)
+	} else {
+		s.overflow[h] = offs
+	}
+}
+
+func (s *bytesShard) remove(h uint64, off uint32) {
+	s.unlink(h, off)
+	s.count--
+	s.dead += s.size(off)
+}
+
+//store 追加新记录，旧记录成为无效数据
+func (s *bytesShard) store(h uint64, key string, value []byte, expiration int64) {
+	size := bytesHeaderSize + len(key) + len(value)
+	if len(s.slab)+size > cap(s.slab) {
+		s.compact(size)
+	}
+	// 压缩可能移动旧记录，因此在分配空间后查找
+	old, loaded := s.find(h, key)
+	off := uint32(len(s.slab))
+	s.slab = s.slab[:len(s.slab)+size]
+	record := s.slab[off:]
+	binary.LittleEndian.PutUint64(record, uint64(expiration))
+	binary.LittleEndian.PutUint64(record[8:], h)
+	binary.LittleEndian.PutUint32(record[16:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[20:], uint32(len(value)))
+	copy(record[bytesHeaderSize:], key)
+	copy(record[bytesHeaderSize+len(key):], value)
+	if loaded {
+		s.dead += s.size(old)
+		s.relink(h, old, off)
+	} else {
+		s.count++
+		s.relink(h, math.MaxUint32, off)
+	}
+}
+
+//each 按写入顺序遍历有效记录
+func (s *bytesShard) each(f func(off uint32) bool) bool {
+	for off := 0; off < len(s.slab); {
+		cur := uint32(off)
+		off += s.size(cur)
+		if s.live(s.hash(cur), cur) && !f(cur) {
+			return false
+		}
+	}
+	return true
+}
+
+//deleteExpired 删除过期记录，无效数据过半时压缩
+func (s *bytesShard) deleteExpired(now int64) int {
+	n := 0
+	s.each(func(off uint32) bool {
+		if s.expired(off, now) {
+			s.remove(s.hash(off), off)
+			n++
+		}
+		return true
+	})
+	if s.dead > 0 && s.dead >= len(s.slab)/2 {
+		s.compact(0)
+	}
+	return n
+}
+
+//compact 将有效记录复制到新的slab，容量不足以再写入reserve字节时按倍数扩容
+func (s *bytesShard) compact(reserve int) {
+	need := len(s.slab) - s.dead + reserve
+	capacity := cap(s.slab)
+	if capacity == 0 {
+		capacity = bytesHeaderSize
+	}
+	for capacity < need {
+		capacity *= 2
+	}
+	if capacity > math.MaxUint32 {
+		if need > math.MaxUint32 {
+			panic(errors.New(ErrSlabFull))
+		}
+		capacity = math.MaxUint32
+	}
+	slab := make([]byte, 0, capacity)
+	s.each(func(off uint32) bool {
+		size := s.size(off)
+		dst := uint32(len(slab))
+		slab = append(slab, s.slab[off:int(off)+size]...)
+		s.relink(s.hash(off), off, dst)
+		return true
+	})
+	s.slab = slab
+	s.dead = 0
+}
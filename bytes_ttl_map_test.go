@@ -0,0 +1,121 @@
+package gomap
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBytesTTLMap_Map(t *testing.T) {
+	m := NewBytesTTLMap(NoExpiration, -1, false, BytesShards(4), BytesSlabSize(64))
+	defer m.Destroy()
+	for i := 0; i < 1000; i++ {
+		m.Store(strconv.Itoa(i), []byte("v"+strconv.Itoa(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || string(v) != "v"+strconv.Itoa(i) {
+			t.Fatal("Load", i, string(v), ok)
+		}
+	}
+	m.Store("1", []byte("new"))
+	if v, _ := m.Load("1"); string(v) != "new" {
+		t.Fatal("overwrite", string(v))
+	}
+	if actual, loaded := m.LoadOrStore("2", []byte("x")); !loaded || string(actual) != "v2" {
+		t.Fatal("LoadOrStore", string(actual), loaded)
+	}
+	m.StoreOrCompare("3", []byte("!"), func(stored, input []byte) []byte {
+		return append(stored, input...)
+	})
+	if v, _ := m.Load("3"); string(v) != "v3!" {
+		t.Fatal("StoreOrCompare", string(v))
+	}
+	if v := m.Delete("4"); string(v) != "v4" {
+		t.Fatal("Delete", string(v))
+	}
+	if m.Size() != 999 {
+		t.Fatal("Size", m.Size())
+	}
+	n := 0
+	m.Range(func(key string, value []byte) bool {
+		n++
+		return true
+	})
+	if n != 999 {
+		t.Fatal("Range", n)
+	}
+	if entries := m.Clear(); len(entries) != 999 || m.Size() != 0 {
+		t.Fatal("Clear", len(entries), m.Size())
+	}
+}
+
+func TestBytesTTLMap_Collision(t *testing.T) {
+	s := &bytesShard{index: map[uint64]uint32{}, overflow: map[uint64][]uint32{}}
+	for i := 0; i < 5; i++ {
+		s.store(42, strconv.Itoa(i), []byte{byte(i)}, -1)
+	}
+	s.store(42, "2", []byte{20}, -1)
+	off, _ := s.find(42, "0")
+	s.remove(42, off)
+	s.compact(0)
+	if s.count != 4 || s.dead != 0 {
+		t.Fatal("count", s.count, s.dead)
+	}
+	for i, want := range []byte{0, 1, 20, 3, 4} {
+		off, ok := s.find(42, strconv.Itoa(i))
+		if i == 0 {
+			if ok {
+				t.Fatal("removed key found")
+			}
+			continue
+		}
+		if !ok || !bytes.Equal(s.value(off), []byte{want}) {
+			t.Fatal("collided key", i, ok)
+		}
+	}
+}
+
+func TestBytesTTLMap_Expire(t *testing.T) {
+	m := NewBytesTTLMap(50*time.Millisecond, 10*time.Millisecond, false, BytesShards(1))
+	defer m.Destroy()
+	m.Store("1", []byte("1"))
+	m.StoreWithTTL("2", []byte("2"), NoExpiration)
+	m.Store("3", []byte("3"))
+	if !m.Persist("3") || !m.Expire("1", 20*time.Millisecond) {
+		t.Fatal("Persist/Expire")
+	}
+	if ttl, ok := m.TTL("1"); !ok || ttl > 20*time.Millisecond {
+		t.Fatal("TTL", ttl, ok)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := m.Load("1"); ok {
+		t.Fatal("not expired")
+	}
+	if m.Size() != 2 {
+		t.Fatal("expired data not deleted", m.Size())
+	}
+}
+
+func TestBytesTTLMap_Compact(t *testing.T) {
+	m := NewBytesTTLMap(NoExpiration, -1, false, BytesShards(1), BytesSlabSize(1024))
+	defer m.Destroy()
+	value := make([]byte, 100)
+	for i := 0; i < 10000; i++ {
+		m.Store(strconv.Itoa(i%10), value)
+	}
+	// 覆盖产生的无效数据被压缩回收，slab不会无限增长
+	if size := cap(m.shards[0].slab); size > 4096 {
+		t.Fatal("slab not compacted", size)
+	}
+}
+
+func BenchmarkBytesTTLMap_Store(b *testing.B) {
+	m := NewBytesTTLMap(NoExpiration, -1, false)
+	defer m.Destroy()
+	value := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Store(strconv.Itoa(i%1000000), value)
+	}
+}
@@ -0,0 +1,377 @@
+package gomap
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// ClusterTransport 访问各节点上map的方式，跨进程部署时可使用resp.ClusterTransport访问运行resp.Server的节点
+	ClusterTransport interface {
+		Load(peer, key string) (value interface{}, ok bool, err error)
+		Store(peer, key string, value interface{}, ttl time.Duration) error
+		Update(peer, key string, f UpdateFunc) (actual interface{}, stored bool, err error) // 远程实现需保证原子性，如基于CAS重试
+		Delete(peer, key string) (interface{}, error)
+		Range(peer string, f func(key string, value interface{}) bool) error
+		Clear(peer string) ([]Entry, error)
+		Size(peer string) (int, error)
+	}
+
+	// ClusterConfig Cluster配置
+	ClusterConfig struct {
+		Transport    ClusterTransport
+		Peers        []string                     // 初始节点
+		Replicas     int                          // 每个节点的虚拟节点数，默认100
+		NearCacheTTL time.Duration                // 本地近端缓存时间，<=0时不缓存
+		OnError      func(peer string, err error) // 访问节点失败时回调，为空时忽略
+	}
+
+	// Cluster 按一致性哈希将key分布到多个节点的map。
+	// 访问节点失败时读取视为未命中、写入视为未执行，错误通过OnError回调报告。
+	// 近端缓存只在本地写入时失效，其他客户端的修改最多在NearCacheTTL后可见。
+	Cluster struct {
+		ring      *HashRing
+		transport ClusterTransport
+		near      *TTLMap       // 近端缓存，可能为空
+		nearTTL   time.Duration // 近端缓存时间
+		onError   func(peer string, err error)
+	}
+
+	// ClusterLocalTransport 进程内transport，将节点名映射到本地map，用于测试
+	ClusterLocalTransport struct {
+		mu    sync.RWMutex
+		peers map[string]ExpirableMap
+	}
+)
+
+var (
+	ErrClusterNoPeer      = errors.New("ErrClusterNoPeer")
+	ErrClusterUnknownPeer = errors.New("ErrClusterUnknownPeer")
+)
+
+func NewCluster(cfg ClusterConfig) *Cluster {
+	c := &Cluster{
+		ring:      NewHashRing(cfg.Replicas, nil),
+		transport: cfg.Transport,
+		onError:   cfg.OnError,
+	}
+	if cfg.NearCacheTTL > 0 {
+		c.near = NewTTLMap(cfg.NearCacheTTL, cfg.NearCacheTTL, false)
+		c.nearTTL = cfg.NearCacheTTL
+	}
+	c.ring.Add(cfg.Peers...)
+	return c
+}
+
+//AddPeer 加入节点，只有约1/n的key改变归属，这些key在新节点上首次读取时未命中
+func (c *Cluster) AddPeer(peer string) {
+	c.ring.Add(peer)
+	c.clearNear()
+}
+
+//RemovePeer 移除节点，其上的key由环上相邻节点接管
+func (c *Cluster) RemovePeer(peer string) {
+	c.ring.Remove(peer)
+	c.clearNear()
+}
+
+//Peers 当前节点
+func (c *Cluster) Peers() []string {
+	return c.ring.Nodes()
+}
+
+//Owner key所属节点
+func (c *Cluster) Owner(key string) string {
+	return c.ring.Get(key)
+}
+
+func (c *Cluster) clearNear() {
+	if c.near != nil {
+		c.near.Clear()
+	}
+}
+
+func (c *Cluster) report(peer string, err error) {
+	if c.onError != nil {
+		c.onError(peer, err)
+	}
+}
+
+//owner key所属节点，没有节点时报告错误
+func (c *Cluster) owner(key string) (string, bool) {
+	peer := c.ring.Get(key)
+	if peer == "" {
+		c.report("", ErrClusterNoPeer)
+		return "", false
+	}
+	return peer, true
+}
+
+func (c *Cluster) cache(key string, value interface{}) {
+	c.cacheWithTTL(key, value, DefaultExpiration)
+}
+
+//cacheWithTTL 放入近端缓存，ttl短于NearCacheTTL时按ttl缓存，避免key在节点上过期后仍从近端缓存读到
+func (c *Cluster) cacheWithTTL(key string, value interface{}, ttl time.Duration) {
+	if c.near == nil {
+		return
+	}
+	if ttl > 0 && ttl < c.nearTTL {
+		c.near.StoreWithTTL(key, value, ttl)
+	} else {
+		c.near.Store(key, value)
+	}
+}
+
+func (c *Cluster) invalidate(key string) {
+	if c.near != nil {
+		c.near.Delete(key)
+	}
+}
+
+func (c *Cluster) Store(key string, value interface{}) {
+	c.StoreWithTTL(key, value, DefaultExpiration)
+}
+
+//StoreWithTTL 存储key-val并指定过期时间，DefaultExpiration使用节点的默认过期时间
+func (c *Cluster) StoreWithTTL(key string, value interface{}, ttl time.Duration) {
+	peer, ok := c.owner(key)
+	if !ok {
+		return
+	}
+	if err := c.transport.Store(peer, key, value, ttl); err != nil {
+		c.invalidate(key)
+		c.report(peer, err)
+		return
+	}
+	c.cacheWithTTL(key, value, ttl)
+}
+
+func (c *Cluster) Load(key string) (value interface{}, ok bool) {
+	if c.near != nil {
+		if value, ok := c.near.Load(key); ok {
+			return value, true
+		}
+	}
+	peer, ok := c.owner(key)
+	if !ok {
+		return nil, false
+	}
+	value, ok, err := c.transport.Load(peer, key)
+	if err != nil {
+		c.report(peer, err)
+		return nil, false
+	}
+	if ok {
+		c.cache(key, value)
+	}
+	return value, ok
+}
+
+func (c *Cluster) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	peer, ok := c.owner(key)
+	if !ok {
+		return value, false
+	}
+	actual, stored, err := c.transport.Update(peer, key, func(current interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return value, DefaultExpiration, !loaded
+	})
+	if err != nil {
+		c.report(peer, err)
+		return value, false
+	}
+	c.cache(key, actual)
+	return actual, !stored
+}
+
+func (c *Cluster) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	peer, ok := c.owner(key)
+	if !ok {
+		return
+	}
+	actual, _, err := c.transport.Update(peer, key, func(current interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if loaded && compare != nil {
+			return compare(current, value), DefaultExpiration, true
+		}
+		return value, DefaultExpiration, true
+	})
+	if err != nil {
+		c.invalidate(key)
+		c.report(peer, err)
+		return
+	}
+	c.cache(key, actual)
+}
+
+func (c *Cluster) Delete(key string) interface{} {
+	c.invalidate(key)
+	peer, ok := c.owner(key)
+	if !ok {
+		return nil
+	}
+	value, err := c.transport.Delete(peer, key)
+	// 删除期间并发的Load可能又缓存了旧值
+	c.invalidate(key)
+	if err != nil {
+		c.report(peer, err)
+		return nil
+	}
+	return value
+}
+
+//Clear 清空所有节点
+func (c *Cluster) Clear() []Entry {
+	c.clearNear()
+	var entries []Entry
+	for _, peer := range c.ring.Nodes() {
+		deleted, err := c.transport.Clear(peer)
+		if err != nil {
+			c.report(peer, err)
+			continue
+		}
+		entries = append(entries, deleted...)
+	}
+	return entries
+}
+
+//Range 依次遍历各节点，只包含归属于该节点的key
+func (c *Cluster) Range(f func(key interface{}, value interface{}) bool) {
+	for _, peer := range c.ring.Nodes() {
+		stopped := false
+		err := c.transport.Range(peer, func(key string, value interface{}) bool {
+			if c.ring.Get(key) != peer {
+				// 节点变更前遗留的数据
+				return true
+			}
+			if !f(key, value) {
+				stopped = true
+			}
+			return !stopped
+		})
+		if err != nil {
+			c.report(peer, err)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+//Destroy 销毁近端缓存，不影响各节点上的数据
+func (c *Cluster) Destroy() {
+	if c.near != nil {
+		c.near.Destroy()
+	}
+}
+
+//Size 各节点数据量之和，包括节点变更前遗留的数据
+//MemoryUsage 估算本地近端缓存占用的内存字节数，节点上的数据由各节点自行统计
+func (c *Cluster) MemoryUsage() int64 {
+	if c.near == nil {
+		return 0
+	}
+	return c.near.MemoryUsage()
+}
+
+func (c *Cluster) Size() int {
+	size := 0
+	for _, peer := range c.ring.Nodes() {
+		n, err := c.transport.Size(peer)
+		if err != nil {
+			c.report(peer, err)
+			continue
+		}
+		size += n
+	}
+	return size
+}
+
+func NewClusterLocalTransport() *ClusterLocalTransport {
+	return &ClusterLocalTransport{peers: map[string]ExpirableMap{}}
+}
+
+//Register 将节点名映射到本地map
+func (t *ClusterLocalTransport) Register(peer string, m ExpirableMap) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[peer] = m
+}
+
+func (t *ClusterLocalTransport) Unregister(peer string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.peers, peer)
+}
+
+func (t *ClusterLocalTransport) peer(peer string) (ExpirableMap, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	m, ok := t.peers[peer]
+	if !ok {
+		return nil, ErrClusterUnknownPeer
+	}
+	return m, nil
+}
+
+func (t *ClusterLocalTransport) Load(peer, key string) (interface{}, bool, error) {
+	m, err := t.peer(peer)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := m.Load(key)
+	return value, ok, nil
+}
+
+func (t *ClusterLocalTransport) Store(peer, key string, value interface{}, ttl time.Duration) error {
+	m, err := t.peer(peer)
+	if err != nil {
+		return err
+	}
+	m.StoreWithTTL(key, value, ttl)
+	return nil
+}
+
+func (t *ClusterLocalTransport) Update(peer, key string, f UpdateFunc) (interface{}, bool, error) {
+	m, err := t.peer(peer)
+	if err != nil {
+		return nil, false, err
+	}
+	actual, stored := m.Update(key, f)
+	return actual, stored, nil
+}
+
+func (t *ClusterLocalTransport) Delete(peer, key string) (interface{}, error) {
+	m, err := t.peer(peer)
+	if err != nil {
+		return nil, err
+	}
+	return m.Delete(key), nil
+}
+
+func (t *ClusterLocalTransport) Range(peer string, f func(key string, value interface{}) bool) error {
+	m, err := t.peer(peer)
+	if err != nil {
+		return err
+	}
+	m.Range(func(key, value interface{}) bool {
+		return f(key.(string), value)
+	})
+	return nil
+}
+
+func (t *ClusterLocalTransport) Clear(peer string) ([]Entry, error) {
+	m, err := t.peer(peer)
+	if err != nil {
+		return nil, err
+	}
+	return m.Clear(), nil
+}
+
+func (t *ClusterLocalTransport) Size(peer string) (int, error) {
+	m, err := t.peer(peer)
+	if err != nil {
+		return 0, err
+	}
+	return m.Size(), nil
+}
@@ -0,0 +1,147 @@
+package gomap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestCluster(peers []string, near time.Duration) (*Cluster, *ClusterLocalTransport, map[string]*TTLMap) {
+	transport := NewClusterLocalTransport()
+	maps := map[string]*TTLMap{}
+	for _, peer := range peers {
+		maps[peer] = NewTTLMap(NoExpiration, 0, false)
+		transport.Register(peer, maps[peer])
+	}
+	return NewCluster(ClusterConfig{Transport: transport, Peers: peers, NearCacheTTL: near}), transport, maps
+}
+
+func TestCluster_Route(t *testing.T) {
+	c, _, maps := newTestCluster([]string{"a", "b", "c"}, 0)
+	defer c.Destroy()
+	for i := 0; i < 300; i++ {
+		c.Store(strconv.Itoa(i), i)
+	}
+	for peer, m := range maps {
+		if m.Size() < 50 {
+			t.Fatal("unbalanced", peer, m.Size())
+		}
+		m.Range(func(key, value interface{}) bool {
+			if c.Owner(key.(string)) != peer {
+				t.Fatal("key on wrong peer", key, peer)
+			}
+			return true
+		})
+	}
+	if v, ok := c.Load("42"); !ok || v != 42 {
+		t.Fatal("Load", v, ok)
+	}
+	if actual, loaded := c.LoadOrStore("42", 0); !loaded || actual != 42 {
+		t.Fatal("LoadOrStore", actual, loaded)
+	}
+	c.StoreOrCompare("42", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	if v, _ := c.Load("42"); v != 43 {
+		t.Fatal("StoreOrCompare", v)
+	}
+	if v := c.Delete("42"); v != 43 {
+		t.Fatal("Delete", v)
+	}
+	if c.Size() != 299 {
+		t.Fatal("Size", c.Size())
+	}
+	count := 0
+	c.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	if count != 299 || len(c.Clear()) != 299 || c.Size() != 0 {
+		t.Fatal("Range/Clear", count)
+	}
+}
+
+func TestCluster_MinimalMovement(t *testing.T) {
+	ring := NewHashRing(0, nil)
+	ring.Add("a", "b", "c", "d")
+	const n = 10000
+	before := make([]string, n)
+	for i := range before {
+		before[i] = ring.Get(strconv.Itoa(i))
+	}
+	ring.Add("e")
+	moved := 0
+	for i := range before {
+		if owner := ring.Get(strconv.Itoa(i)); owner != before[i] {
+			if owner != "e" {
+				t.Fatal("key moved between existing peers")
+			}
+			moved++
+		}
+	}
+	// 理想情况下移动1/5
+	if moved < n/10 || moved > n*3/10 {
+		t.Fatal("moved", moved)
+	}
+	ring.Remove("e")
+	for i := range before {
+		if ring.Get(strconv.Itoa(i)) != before[i] {
+			t.Fatal("remove did not restore ownership")
+		}
+	}
+}
+
+func TestCluster_NearCache(t *testing.T) {
+	c, _, maps := newTestCluster([]string{"a", "b"}, 50*time.Millisecond)
+	defer c.Destroy()
+	c.Store("k", 1)
+	// 其他客户端直接修改节点数据，近端缓存过期前仍返回旧值
+	maps[c.Owner("k")].Store("k", 2)
+	if v, _ := c.Load("k"); v != 1 {
+		t.Fatal("near cache miss", v)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if v, _ := c.Load("k"); v != 2 {
+		t.Fatal("near cache not expired", v)
+	}
+	c.Delete("k")
+	if _, ok := c.Load("k"); ok {
+		t.Fatal("near cache not invalidated")
+	}
+	// 近端缓存不超过key自身的过期时间
+	c.StoreWithTTL("short", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Load("short"); ok {
+		t.Fatal("expired key served from near cache")
+	}
+}
+
+func TestCluster_PeerChange(t *testing.T) {
+	c, transport, _ := newTestCluster([]string{"a", "b"}, time.Minute)
+	defer c.Destroy()
+	var errs []string
+	c.onError = func(peer string, err error) {
+		errs = append(errs, peer)
+	}
+	c.AddPeer("c")
+	for i := 0; i < 100; i++ {
+		c.Store(strconv.Itoa(i), i)
+	}
+	if len(errs) == 0 {
+		t.Fatal("unregistered peer not reported")
+	}
+	transport.Register("c", NewTTLMap(NoExpiration, 0, false))
+	c.RemovePeer("a")
+	for _, peer := range c.Peers() {
+		if peer == "a" {
+			t.Fatal("peer not removed")
+		}
+	}
+	errs = nil
+	for i := 0; i < 100; i++ {
+		c.Store(strconv.Itoa(i), i)
+	}
+	if len(errs) != 0 || c.Size() != 100 {
+		t.Fatal("store after peer change", errs, c.Size())
+	}
+}
@@ -0,0 +1,157 @@
+package gomap
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// Counter 计数值，整数与浮点数分别累加，例如同一key同时记录请求数与总耗时
+	Counter struct {
+		Int   int64
+		Float float64
+	}
+
+	// CounterMap 带过期时间的原子计数器，不存在或已过期的key从0开始计数并按默认过期时间创建。
+	// 计数器在锁内原地修改，已存在的key递增时没有内存分配
+	CounterMap struct {
+		m     *TTLMap
+		renew bool // 递增时续租
+	}
+)
+
+//NewCounterMap 创建计数器map，renewOnIncr为false时递增不续租，计数器在创建后expiration过期，即固定窗口计数
+func NewCounterMap(expiration, gcInterval time.Duration, renewOnIncr bool) *CounterMap {
+	return &CounterMap{
+		m:     NewTTLMap(expiration, gcInterval, false),
+		renew: renewOnIncr,
+	}
+}
+
+//counter 调用方持有写锁，返回key的计数器，不存在时创建，存在时按配置续租
+func (c *CounterMap) counter(key string) *Counter {
+	m := c.m
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if item, ok := m.entryMap[key]; ok && !item.expired() {
+		if c.renew && item.renew(&m.policy, m.expiration) {
+			m.entryMap[key] = item
+		}
+		return item.Value.(*Counter)
+	}
+	v := &Counter{}
+	m.store(key, v)
+	return v
+}
+
+//Incr 加1并返回新值
+func (c *CounterMap) Incr(key string) int64 {
+	return c.IncrBy(key, 1)
+}
+
+//Decr 减1并返回新值
+func (c *CounterMap) Decr(key string) int64 {
+	return c.IncrBy(key, -1)
+}
+
+//IncrBy 整数计数加delta并返回新值
+func (c *CounterMap) IncrBy(key string, delta int64) int64 {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	v := c.counter(key)
+	v.Int += delta
+	return v.Int
+}
+
+//IncrByFloat 浮点数计数加delta并返回新值
+func (c *CounterMap) IncrByFloat(key string, delta float64) float64 {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	v := c.counter(key)
+	v.Float += delta
+	return v.Float
+}
+
+//Load 读取计数，不续租
+func (c *CounterMap) Load(key string) (counter Counter, ok bool) {
+	c.m.mu.RLock()
+	defer c.m.mu.RUnlock()
+	if c.m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := c.m.entryMap[key]
+	if !ok || item.expired() {
+		return Counter{}, false
+	}
+	return *item.Value.(*Counter), true
+}
+
+//Delete 删除计数器并返回删除前的计数
+func (c *CounterMap) Delete(key string) (counter Counter, ok bool) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if c.m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := c.m.entryMap[key]
+	if !ok || item.expired() {
+		return Counter{}, false
+	}
+	c.m.remove(key)
+	return *item.Value.(*Counter), true
+}
+
+//Snapshot 所有未过期计数的副本
+func (c *CounterMap) Snapshot() map[string]Counter {
+	c.m.mu.RLock()
+	defer c.m.mu.RUnlock()
+	if c.m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	counters := make(map[string]Counter, len(c.m.entryMap))
+	for key, item := range c.m.entryMap {
+		if !item.expired() {
+			counters[key] = *item.Value.(*Counter)
+		}
+	}
+	return counters
+}
+
+//Drain 返回所有未过期计数并原子地清空，用于周期性刷出。
+// 清空后再次递增的key重新创建，过期时间重新计算
+func (c *CounterMap) Drain() map[string]Counter {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if c.m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	counters := make(map[string]Counter, len(c.m.entryMap))
+	for key, item := range c.m.entryMap {
+		if !item.expired() {
+			counters[key] = *item.Value.(*Counter)
+		}
+	}
+	c.m.entryMap = map[string]ttlEntry{}
+	c.m.retrack()
+	return counters
+}
+
+//TTL 计数器剩余存活时间，永不过期时返回NoExpiration
+func (c *CounterMap) TTL(key string) (ttl time.Duration, ok bool) {
+	return c.m.TTL(key)
+}
+
+//Size 计数器数量，包括已过期未清理的
+func (c *CounterMap) Size() int {
+	return c.m.Size()
+}
+
+//MemoryUsage 估算map当前占用的内存字节数
+func (c *CounterMap) MemoryUsage() int64 {
+	return c.m.MemoryUsage()
+}
+
+func (c *CounterMap) Destroy() {
+	c.m.Destroy()
+}
@@ -0,0 +1,100 @@
+package gomap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterMap_Incr(t *testing.T) {
+	c := NewCounterMap(time.Minute, time.Minute, true)
+	defer c.Destroy()
+	if c.Incr("a") != 1 || c.IncrBy("a", 5) != 6 || c.Decr("a") != 5 {
+		t.Fatal("unexpected int counter")
+	}
+	if v := c.IncrByFloat("a", 1.5); v != 1.5 {
+		t.Fatal("unexpected float counter", v)
+	}
+	if v, ok := c.Load("a"); !ok || v != (Counter{Int: 5, Float: 1.5}) {
+		t.Fatal("Load", v)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Incr("b")
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Load("b"); v.Int != 10000 {
+		t.Fatal("concurrent Incr", v)
+	}
+	if n := testing.AllocsPerRun(100, func() { c.Incr("b") }); n != 0 {
+		t.Fatal("Incr should not allocate", n)
+	}
+}
+
+func TestCounterMap_Expiration(t *testing.T) {
+	// 递增不续租时计数器在创建后固定时间过期
+	c := NewCounterMap(30*time.Millisecond, time.Hour, false)
+	defer c.Destroy()
+	c.Incr("a")
+	time.Sleep(20 * time.Millisecond)
+	c.Incr("a")
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Load("a"); ok {
+		t.Fatal("counter should expire without renewal")
+	}
+	if c.Incr("a") != 1 {
+		t.Fatal("expired counter should restart from zero")
+	}
+
+	c2 := NewCounterMap(30*time.Millisecond, time.Hour, true)
+	defer c2.Destroy()
+	c2.Incr("a")
+	time.Sleep(20 * time.Millisecond)
+	c2.Incr("a")
+	time.Sleep(20 * time.Millisecond)
+	if v, ok := c2.Load("a"); !ok || v.Int != 2 {
+		t.Fatal("counter should be renewed", v)
+	}
+}
+
+func TestCounterMap_SnapshotDrain(t *testing.T) {
+	c := NewCounterMap(NoExpiration, NoExpiration, false)
+	defer c.Destroy()
+	for i := 0; i < 3; i++ {
+		c.IncrBy(strconv.Itoa(i), int64(i))
+	}
+	snapshot := c.Snapshot()
+	if len(snapshot) != 3 || snapshot["2"].Int != 2 || c.Size() != 3 {
+		t.Fatal("Snapshot", snapshot)
+	}
+	c.Incr("2")
+	if snapshot["2"].Int != 2 {
+		t.Fatal("Snapshot should be a copy")
+	}
+	drained := c.Drain()
+	if len(drained) != 3 || drained["2"].Int != 3 || c.Size() != 0 {
+		t.Fatal("Drain", drained)
+	}
+	if c.Incr("2") != 1 {
+		t.Fatal("drained counter should restart from zero")
+	}
+	if v, ok := c.Delete("2"); !ok || v.Int != 1 || c.Size() != 0 {
+		t.Fatal("Delete", v)
+	}
+}
+
+func BenchmarkCounterMap_Incr(b *testing.B) {
+	c := NewCounterMap(time.Minute, time.Minute, true)
+	defer c.Destroy()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Incr("a")
+	}
+}
@@ -0,0 +1,322 @@
+package gomap
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// HLCTimestamp 混合逻辑时钟时间戳，按Wall、Logical、Node依次比较
+	HLCTimestamp struct {
+		Wall    int64  // 物理时间，纳秒
+		Logical uint32 // 物理时间相同时的逻辑计数
+		Node    string // 节点标识，保证不同节点的时间戳全序
+	}
+
+	// HLC 混合逻辑时钟
+	HLC struct {
+		mu   sync.Mutex
+		last HLCTimestamp
+		now  func() int64
+	}
+
+	// CRDTRecord 一个key的最新状态，Deleted为true时表示墓碑
+	CRDTRecord struct {
+		Key       string
+		Value     interface{}
+		Timestamp HLCTimestamp
+		Deleted   bool
+		ExpireAt  int64 // 值的过期时间或墓碑的删除时间，-1表示永不过期
+	}
+
+	// CRDTMap LWW-element map，各节点可离线写入，通过Merge/Delta交换状态后最终一致。
+	// 并发写入以时间戳较大者为准，删除以墓碑记录，墓碑在保留时间后由gc清理；
+	// 墓碑清理后才到达的旧写入会使已删除的key复活，因此保留时间应大于节点间同步的最大间隔。
+	CRDTMap struct {
+		data         *TTLMap // 存储crdtEntry
+		clock        *HLC
+		expiration   time.Duration
+		tombstoneTTL time.Duration
+	}
+
+	// crdtEntry 存储的记录，updated为本节点写入或合并该记录时的本地时钟，用于Delta
+	crdtEntry struct {
+		CRDTRecord
+		updated HLCTimestamp
+	}
+)
+
+func NewHLC(node string) *HLC {
+	return &HLC{last: HLCTimestamp{Node: node}, now: func() int64 {
+		return time.Now().UnixNano()
+	}}
+}
+
+//Now 生成新的时间戳，保证单调递增
+func (c *HLC) Now() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.now(); pt > c.last.Wall {
+		c.last.Wall, c.last.Logical = pt, 0
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+//Update 收到远端时间戳后推进时钟，返回新的本地时间戳
+func (c *HLC) Update(remote HLCTimestamp) HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.now()
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last.Wall, c.last.Logical = pt, 0
+	case remote.Wall > c.last.Wall:
+		c.last.Wall, c.last.Logical = remote.Wall, remote.Logical+1
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	}
+	return c.last
+}
+
+//Last 最近生成的时间戳
+func (c *HLC) Last() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+//Compare 比较时间戳，返回-1、0、1
+func (t HLCTimestamp) Compare(o HLCTimestamp) int {
+	switch {
+	case t.Wall != o.Wall:
+		if t.Wall < o.Wall {
+			return -1
+		}
+		return 1
+	case t.Logical != o.Logical:
+		if t.Logical < o.Logical {
+			return -1
+		}
+		return 1
+	case t.Node != o.Node:
+		if t.Node < o.Node {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+//expired 值是否已过期，墓碑总是视为不存在
+func (r *CRDTRecord) expired(now int64) bool {
+	return r.Deleted || (r.ExpireAt > 0 && now > r.ExpireAt)
+}
+
+//NewCRDTMap node为节点标识，expiration为Store默认过期时间，tombstoneTTL为墓碑及过期记录的保留时间
+func NewCRDTMap(node string, expiration, tombstoneTTL, gcInterval time.Duration) *CRDTMap {
+	if tombstoneTTL <= 0 {
+		tombstoneTTL = time.Hour
+	}
+	return &CRDTMap{
+		data:         NewTTLMap(NoExpiration, gcInterval, false),
+		clock:        NewHLC(node),
+		expiration:   expiration,
+		tombstoneTTL: tombstoneTTL,
+	}
+}
+
+//live 读取未删除且未过期的记录，需持有锁
+func (m *CRDTMap) live(key string, now int64) (crdtEntry, bool) {
+	item, ok := m.data.entryMap[key]
+	if !ok || item.expired() {
+		return crdtEntry{}, false
+	}
+	e := item.Value.(crdtEntry)
+	if e.expired(now) {
+		return crdtEntry{}, false
+	}
+	return e, true
+}
+
+//put 写入记录，过期或删除的记录再保留tombstoneTTL供合并比较，需持有锁
+func (m *CRDTMap) put(record CRDTRecord, updated HLCTimestamp) {
+	gcAt := int64(-1)
+	if record.ExpireAt > 0 {
+		gcAt = record.ExpireAt + int64(m.tombstoneTTL)
+	}
+	m.data.storeAt(record.Key, crdtEntry{CRDTRecord: record, updated: updated}, gcAt)
+	m.data.schedule(gcAt)
+}
+
+//write 以本地新时间戳写入值或墓碑，需持有锁
+func (m *CRDTMap) write(key string, value interface{}, deleted bool) {
+	ts := m.clock.Now()
+	record := CRDTRecord{Key: key, Value: value, Timestamp: ts, Deleted: deleted}
+	if deleted {
+		record.Value, record.ExpireAt = nil, time.Now().UnixNano()
+	} else {
+		record.ExpireAt = expireAt(DefaultExpiration, m.expiration)
+	}
+	m.put(record, ts)
+}
+
+func (m *CRDTMap) lock() func() {
+	m.data.mu.Lock()
+	if m.data.entryMap == nil {
+		m.data.mu.Unlock()
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.data.mu.Unlock
+}
+
+func (m *CRDTMap) rlock() func() {
+	m.data.mu.RLock()
+	if m.data.entryMap == nil {
+		m.data.mu.RUnlock()
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.data.mu.RUnlock
+}
+
+func (m *CRDTMap) Store(key string, value interface{}) {
+	defer m.lock()()
+	m.write(key, value, false)
+}
+
+func (m *CRDTMap) Load(key string) (value interface{}, ok bool) {
+	defer m.rlock()()
+	e, ok := m.live(key, time.Now().UnixNano())
+	return e.Value, ok
+}
+
+func (m *CRDTMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	defer m.lock()()
+	if e, ok := m.live(key, time.Now().UnixNano()); ok {
+		return e.Value, true
+	}
+	m.write(key, value, false)
+	return value, false
+}
+
+func (m *CRDTMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	defer m.lock()()
+	if e, ok := m.live(key, time.Now().UnixNano()); ok && compare != nil {
+		value = compare(e.Value, value)
+	}
+	m.write(key, value, false)
+}
+
+//Delete 写入墓碑，返回被删除的值
+func (m *CRDTMap) Delete(key string) interface{} {
+	defer m.lock()()
+	e, ok := m.live(key, time.Now().UnixNano())
+	if !ok {
+		return nil
+	}
+	m.write(key, nil, true)
+	return e.Value
+}
+
+//Clear 为所有存在的key写入墓碑
+func (m *CRDTMap) Clear() []Entry {
+	defer m.lock()()
+	now := time.Now().UnixNano()
+	var entries []Entry
+	for key := range m.data.entryMap {
+		if e, ok := m.live(key, now); ok {
+			entries = append(entries, Entry{Key: key, Value: e.Value})
+			m.write(key, nil, true)
+		}
+	}
+	return entries
+}
+
+func (m *CRDTMap) Range(f func(key interface{}, value interface{}) bool) {
+	defer m.rlock()()
+	now := time.Now().UnixNano()
+	for key := range m.data.entryMap {
+		if e, ok := m.live(key, now); ok {
+			if !f(key, e.Value) {
+				break
+			}
+		}
+	}
+}
+
+func (m *CRDTMap) Destroy() {
+	m.data.Destroy()
+}
+
+//Size 未删除且未过期的key数量
+func (m *CRDTMap) Size() int {
+	defer m.rlock()()
+	now := time.Now().UnixNano()
+	size := 0
+	for key := range m.data.entryMap {
+		if _, ok := m.live(key, now); ok {
+			size++
+		}
+	}
+	return size
+}
+
+//MemoryUsage 估算map当前占用的内存字节数，包括尚未清理的墓碑
+func (m *CRDTMap) MemoryUsage() int64 {
+	return m.data.MemoryUsage()
+}
+
+//Clock 本节点的时钟
+func (m *CRDTMap) Clock() *HLC {
+	return m.clock
+}
+
+//Delta 返回本节点在since之后写入或合并的记录（包括墓碑），以及下次调用使用的since。
+// since为零值时返回全部记录。
+func (m *CRDTMap) Delta(since HLCTimestamp) ([]CRDTRecord, HLCTimestamp) {
+	defer m.rlock()()
+	var records []CRDTRecord
+	for _, item := range m.data.entryMap {
+		if item.expired() {
+			continue
+		}
+		if e := item.Value.(crdtEntry); e.updated.Compare(since) > 0 {
+			records = append(records, e.CRDTRecord)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	return records, m.clock.Last()
+}
+
+//MergeDelta 合并其他节点的记录，每个key保留时间戳较大的记录，返回被采纳的记录数
+func (m *CRDTMap) MergeDelta(records []CRDTRecord) int {
+	defer m.lock()()
+	merged := 0
+	for _, record := range records {
+		updated := m.clock.Update(record.Timestamp)
+		if item, ok := m.data.entryMap[record.Key]; ok && !item.expired() {
+			if item.Value.(crdtEntry).Timestamp.Compare(record.Timestamp) >= 0 {
+				continue
+			}
+		}
+		m.put(record, updated)
+		merged++
+	}
+	return merged
+}
+
+//Merge 合并other的全部状态
+func (m *CRDTMap) Merge(other *CRDTMap) int {
+	records, _ := other.Delta(HLCTimestamp{})
+	return m.MergeDelta(records)
+}
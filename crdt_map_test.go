@@ -0,0 +1,223 @@
+package gomap
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCRDTMap_Converge(t *testing.T) {
+	a := NewCRDTMap("a", NoExpiration, time.Minute, 0)
+	b := NewCRDTMap("b", NoExpiration, time.Minute, 0)
+	defer a.Destroy()
+	defer b.Destroy()
+
+	a.Store("k", "a1")
+	a.Store("x", 1)
+	b.Store("k", "b1") // 晚于a1，最终以b1为准
+	b.Store("y", 2)
+	a.Merge(b)
+	b.Merge(a)
+	for _, m := range []*CRDTMap{a, b} {
+		if v, _ := m.Load("k"); v != "b1" {
+			t.Fatal("lww", v)
+		}
+		if m.Size() != 3 {
+			t.Fatal("size", m.Size())
+		}
+	}
+
+	// 删除以墓碑传播
+	if v := a.Delete("x"); v != 1 {
+		t.Fatal("Delete", v)
+	}
+	delta, since := a.Delta(HLCTimestamp{})
+	b.MergeDelta(delta)
+	if _, ok := b.Load("x"); ok {
+		t.Fatal("tombstone not merged")
+	}
+	// 增量只包含since之后的变更
+	a.Store("z", 3)
+	delta, _ = a.Delta(since)
+	if len(delta) != 1 || delta[0].Key != "z" {
+		t.Fatal("delta", delta)
+	}
+	// 合并旧记录不会覆盖墓碑
+	b.MergeDelta([]CRDTRecord{{Key: "x", Value: 0, Timestamp: HLCTimestamp{Wall: 1, Node: "c"}, ExpireAt: -1}})
+	if _, ok := b.Load("x"); ok {
+		t.Fatal("stale write resurrected key")
+	}
+}
+
+func TestCRDTMap_TombstoneGC(t *testing.T) {
+	m := NewCRDTMap("a", NoExpiration, 50*time.Millisecond, 10*time.Millisecond)
+	defer m.Destroy()
+	m.Store("k", 1)
+	m.Delete("k")
+	if delta, _ := m.Delta(HLCTimestamp{}); len(delta) != 1 || !delta[0].Deleted {
+		t.Fatal("tombstone missing", delta)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if delta, _ := m.Delta(HLCTimestamp{}); len(delta) != 0 {
+		t.Fatal("tombstone not collected", delta)
+	}
+}
+
+func TestCRDTMap_TombstoneGCWithoutInterval(t *testing.T) {
+	// 未设置gcInterval时，写入墓碑也会启动清理
+	m := NewCRDTMap("a", NoExpiration, 50*time.Millisecond, 0)
+	defer m.Destroy()
+	m.Store("k", 1)
+	m.Delete("k")
+	time.Sleep(200 * time.Millisecond)
+	m.data.mu.RLock()
+	n := len(m.data.entryMap)
+	m.data.mu.RUnlock()
+	if n != 0 {
+		t.Fatal("tombstone not collected", n)
+	}
+}
+
+func TestCRDTMap_Expiration(t *testing.T) {
+	m := NewCRDTMap("a", 30*time.Millisecond, time.Minute, 0)
+	defer m.Destroy()
+	m.Store("k", 1)
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := m.Load("k"); ok {
+		t.Fatal("not expired")
+	}
+	// 过期记录仍参与合并比较，旧写入不会复活
+	m.MergeDelta([]CRDTRecord{{Key: "k", Value: 0, Timestamp: HLCTimestamp{Wall: 1, Node: "b"}, ExpireAt: -1}})
+	if _, ok := m.Load("k"); ok {
+		t.Fatal("stale write resurrected expired key")
+	}
+}
+
+//randomRecords 生成一个副本上的写入与删除，key范围很小使各副本的key大量重叠，
+// 时间戳带有副本的节点标识且在副本内唯一
+func randomRecords(r *rand.Rand, node string) []CRDTRecord {
+	records := make([]CRDTRecord, 5+r.Intn(20))
+	for i := range records {
+		records[i] = CRDTRecord{
+			Key:       strconv.Itoa(r.Intn(6)),
+			Value:     r.Intn(100),
+			Timestamp: HLCTimestamp{Wall: int64(r.Intn(5)), Logical: uint32(i), Node: node},
+			ExpireAt:  -1,
+		}
+		if r.Intn(3) == 0 {
+			records[i].Value, records[i].Deleted = nil, true
+			records[i].ExpireAt = time.Now().Add(time.Hour).UnixNano()
+		}
+	}
+	return records
+}
+
+//crdtOf 由一组记录构造map，同一时间戳只保留一条，模拟真实时钟的唯一性
+func crdtOf(records []CRDTRecord) *CRDTMap {
+	m := NewCRDTMap("test", NoExpiration, time.Hour, 0)
+	seen := map[HLCTimestamp]bool{}
+	for _, record := range records {
+		if !seen[record.Timestamp] {
+			seen[record.Timestamp] = true
+			m.MergeDelta([]CRDTRecord{record})
+		}
+	}
+	return m
+}
+
+func crdtState(m *CRDTMap) []CRDTRecord {
+	records, _ := m.Delta(HLCTimestamp{})
+	return records
+}
+
+//crdtMerge 依次合并各副本，返回新的map
+func crdtMerge(replicas ...[]CRDTRecord) []CRDTRecord {
+	m := crdtOf(nil)
+	defer m.Destroy()
+	for _, records := range replicas {
+		other := crdtOf(records)
+		m.Merge(other)
+		other.Destroy()
+	}
+	return crdtState(m)
+}
+
+func TestCRDTMap_MergeProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	overlapped, tombstones := 0, 0
+	for i := 0; i < 200; i++ {
+		// 三个副本在相同的key上各自写入、删除
+		x, y, z := randomRecords(r, "a"), randomRecords(r, "b"), randomRecords(r, "c")
+		// 副本之间已同步过的部分记录
+		y = append(y, x[:r.Intn(len(x))]...)
+		z = append(z, y[:r.Intn(len(y))]...)
+		keys := map[string]int{}
+		for _, records := range [][]CRDTRecord{x, y, z} {
+			seen := map[string]bool{}
+			for _, record := range records {
+				if !seen[record.Key] {
+					seen[record.Key] = true
+					keys[record.Key]++
+				}
+			}
+		}
+		for _, n := range keys {
+			if n > 1 {
+				overlapped++
+			}
+		}
+
+		// 交换律
+		xy, yx := crdtMerge(x, y), crdtMerge(y, x)
+		if !reflect.DeepEqual(xy, yx) {
+			t.Fatal("merge not commutative", xy, yx)
+		}
+		// 结合律
+		left := crdtMerge(x, y, z)
+		xm := crdtOf(x)
+		yz := crdtOf(y)
+		zm := crdtOf(z)
+		yz.Merge(zm)
+		xm.Merge(yz)
+		if right := crdtState(xm); !reflect.DeepEqual(left, right) {
+			t.Fatal("merge not associative", left, right)
+		}
+		// 幂等
+		twice := crdtMerge(x, y, x, y, z, z)
+		if !reflect.DeepEqual(left, twice) {
+			t.Fatal("merge not idempotent", left, twice)
+		}
+		xm.Merge(xm)
+		if !reflect.DeepEqual(left, crdtState(xm)) {
+			t.Fatal("self merge changed state")
+		}
+		// 每个key的结果是所有副本中时间戳最大的记录
+		latest := map[string]CRDTRecord{}
+		for _, records := range [][]CRDTRecord{x, y, z} {
+			for _, record := range records {
+				if old, ok := latest[record.Key]; !ok || old.Timestamp.Compare(record.Timestamp) < 0 {
+					latest[record.Key] = record
+				}
+			}
+		}
+		if len(left) != len(latest) {
+			t.Fatal("keys lost", left, latest)
+		}
+		for _, record := range left {
+			if !reflect.DeepEqual(record, latest[record.Key]) {
+				t.Fatal("not last writer wins", record, latest[record.Key])
+			}
+			if record.Deleted {
+				tombstones++
+			}
+		}
+		for _, m := range []*CRDTMap{xm, yz, zm} {
+			m.Destroy()
+		}
+	}
+	if overlapped == 0 || tombstones == 0 {
+		t.Fatal("generated replicas do not overlap", overlapped, tombstones)
+	}
+}
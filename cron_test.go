@@ -0,0 +1,105 @@
+package gomap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	from := time.Date(2024, 1, 31, 23, 30, 15, 0, shanghai)
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, shanghai)},
+		{"@hourly", time.Date(2024, 2, 1, 0, 0, 0, 0, shanghai)},
+		{"*/20 * * * *", time.Date(2024, 1, 31, 23, 40, 0, 0, shanghai)},
+		{"0 9-17/4 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, shanghai)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, shanghai)},
+		{"30 8 1,15 * 7", time.Date(2024, 2, 1, 8, 30, 0, 0, shanghai)},
+		{"0 0 * * sun", time.Date(2024, 2, 4, 0, 0, 0, 0, shanghai)},
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.expr, shanghai)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if next := s.Next(from); !next.Equal(c.want) {
+			t.Fatal(c.expr, next, c.want)
+		}
+	}
+	s, err := ParseCron("TZ=UTC 0 0 * * *", shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(from); !next.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatal("TZ prefix", next)
+	}
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "TZ=Nowhere/City * * * * *"} {
+		if _, err := ParseCron(expr, nil); err == nil {
+			t.Fatal("should fail", expr)
+		}
+	}
+	if s, _ := ParseCron("0 0 30 2 *", nil); !s.Next(from).IsZero() {
+		t.Fatal("impossible schedule")
+	}
+}
+
+func TestCronSchedule_DST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2024-03-10 02:00在纽约不存在
+	s, _ := ParseCron("30 2 * * *", ny)
+	next := s.Next(time.Date(2024, 3, 9, 12, 0, 0, 0, ny))
+	if !next.After(time.Date(2024, 3, 9, 12, 0, 0, 0, ny)) || next.Hour() != 2 {
+		t.Fatal("DST gap", next)
+	}
+}
+
+func TestTTLMap_Schedule(t *testing.T) {
+	m := NewTTLMap(time.Minute, -1, false)
+	defer m.Destroy()
+	at := time.Now().Add(time.Hour)
+	m.StoreUntil("until", 1, at)
+	if got, _ := m.ExpiresAt("until"); got.UnixNano() != at.UnixNano() {
+		t.Fatal("StoreUntil", got, at)
+	}
+	if m.StoreUntil("until", 2, time.Time{}) {
+		t.Fatal("StoreUntil zero time")
+	}
+	if v, _ := m.Peek("until"); v != 1 {
+		t.Fatal("zero time modified key", v)
+	}
+	if m.StoreUntil("until", 2, time.Now().Add(-time.Second)) {
+		t.Fatal("StoreUntil past time")
+	}
+	if _, ok := m.Peek("until"); ok {
+		t.Fatal("past time should delete key")
+	}
+	hourly, _ := ParseCron("@hourly", nil)
+	m.StoreWithPolicy("report", 1, ExpirationPolicy{Schedule: hourly})
+	got, _ := m.ExpiresAt("report")
+	if got.Minute() != 0 || got.Second() != 0 || time.Until(got) > time.Hour {
+		t.Fatal("hourly expiration", got)
+	}
+	l := NewLinkedTTLMap(time.Minute, -1, false)
+	defer l.Destroy()
+	midnight, _ := ParseCron("@midnight", time.UTC)
+	l.SetExpirationPolicy(ExpirationPolicy{Schedule: midnight})
+	l.Store("quota", 1)
+	if got, _ := l.ExpiresAt("quota"); !got.Equal(midnight.Next(time.Now())) {
+		t.Fatal("midnight expiration", got)
+	}
+	if l.StoreUntil("quota", 2, time.Time{}) || l.StoreUntil("quota", 2, time.Now().Add(-time.Second)) {
+		t.Fatal("StoreUntil stored with an invalid time")
+	}
+	if _, ok := l.Peek("quota"); ok || l.Size() != 0 {
+		t.Fatal("past time should delete key")
+	}
+}
@@ -0,0 +1,224 @@
+package gomap
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// DelayQueueConfig DelayQueue配置
+	DelayQueueConfig struct {
+		// 可见性超时，>0时Take取出的数据在该时间内未Ack会重新投递，即至少一次投递；
+		// <=0时Take即删除，数据最多投递一次
+		VisibilityTimeout time.Duration
+	}
+
+	// Delivery Take取出的一次投递
+	Delivery struct {
+		Entry
+		Attempt int    // 第几次投递，从1开始
+		id      uint64 // 投递标识，用于Ack
+	}
+
+	// DelayQueue 延迟队列，按到期时间排序，同一key只有一个数据，Take阻塞直到最早的数据到期
+	DelayQueue struct {
+		mu         sync.Mutex
+		cond       *sync.Cond            // 队列变化通知
+		items      map[string]*delayItem // 所有数据，包括投递中未确认的
+		queue      delayHeap             // 按到期时间排序的最小堆
+		visibility time.Duration         // 可见性超时
+		seq        uint64                // 投递序号
+	}
+
+	delayItem struct {
+		Entry
+		due      int64  // 到期时间戳，投递中时为重新投递的时间
+		index    int    // 在堆中的位置
+		attempt  int    // 已投递次数
+		delivery uint64 // 当前投递标识，0表示未在投递中
+	}
+
+	delayHeap []*delayItem
+)
+
+func (h delayHeap) Len() int           { return len(h) }
+func (h delayHeap) Less(i, j int) bool { return h[i].due < h[j].due }
+func (h delayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *delayHeap) Push(x interface{}) {
+	item := x.(*delayItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+func NewDelayQueue(cfg DelayQueueConfig) *DelayQueue {
+	q := &DelayQueue{
+		items:      map[string]*delayItem{},
+		visibility: cfg.VisibilityTimeout,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+//Offer 添加数据，delay后到期；key已存在时替换其值与到期时间，并使未确认的投递失效
+func (q *DelayQueue) Offer(key string, value interface{}, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	due := time.Now().Add(delay).UnixNano()
+	if item, ok := q.items[key]; ok {
+		item.Value = value
+		item.attempt = 0
+		item.delivery = 0
+		q.fix(item, due)
+		return
+	}
+	item := &delayItem{Entry: Entry{Key: key, Value: value}, due: due}
+	q.items[key] = item
+	heap.Push(&q.queue, item)
+	q.cond.Broadcast()
+}
+
+//fix 修改到期时间并调整堆
+func (q *DelayQueue) fix(item *delayItem, due int64) {
+	item.due = due
+	heap.Fix(&q.queue, item.index)
+	q.cond.Broadcast()
+}
+
+//Take 阻塞直到最早的数据到期并取出，ctx结束时返回ctx.Err()，队列被销毁时返回ErrDestroyed。
+// 设置了可见性超时时数据在Ack前仍保留在队列中，超时后重新投递
+func (q *DelayQueue) Take(ctx context.Context) (d Delivery, err error) {
+	q.mu.Lock()
+	defer wakeOnDone(ctx, &q.mu, q.cond)()
+	defer q.mu.Unlock()
+	for {
+		if q.items == nil {
+			return Delivery{}, ErrDestroyed
+		}
+		var wait time.Duration
+		if len(q.queue) > 0 {
+			if wait = time.Duration(q.queue[0].due - time.Now().UnixNano()); wait <= 0 {
+				return q.deliver(q.queue[0]), nil
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return Delivery{}, err
+		}
+		var timer *time.Timer
+		if wait > 0 {
+			timer = time.AfterFunc(wait, q.wake)
+		}
+		q.cond.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+//wake 唤醒等待到期的Take
+func (q *DelayQueue) wake() {
+	q.mu.Lock()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+//deliver 投递到期的数据，未设置可见性超时时直接删除
+func (q *DelayQueue) deliver(item *delayItem) Delivery {
+	item.attempt++
+	q.seq++
+	d := Delivery{Entry: item.Entry, Attempt: item.attempt, id: q.seq}
+	if q.visibility <= 0 {
+		q.remove(item)
+		return d
+	}
+	item.delivery = q.seq
+	item.due = time.Now().Add(q.visibility).UnixNano()
+	heap.Fix(&q.queue, item.index)
+	return d
+}
+
+func (q *DelayQueue) remove(item *delayItem) {
+	heap.Remove(&q.queue, item.index)
+	delete(q.items, item.Key)
+}
+
+//Ack 确认投递已处理并删除数据；投递已超时重新投递、数据被替换、重新调度或取消时返回false
+func (q *DelayQueue) Ack(d Delivery) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	item, ok := q.items[d.Key]
+	if !ok || item.delivery == 0 || item.delivery != d.id {
+		return false
+	}
+	q.remove(item)
+	return true
+}
+
+//Cancel 删除数据，包括投递中未确认的，不存在时返回false
+func (q *DelayQueue) Cancel(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	item, ok := q.items[key]
+	if ok {
+		q.remove(item)
+	}
+	return ok
+}
+
+//Reschedule 将数据改为delay后到期，投递中的数据使当前投递失效，不存在时返回false
+func (q *DelayQueue) Reschedule(key string, delay time.Duration) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	item, ok := q.items[key]
+	if ok {
+		item.delivery = 0
+		q.fix(item, time.Now().Add(delay).UnixNano())
+	}
+	return ok
+}
+
+//Len 队列中的数据数量，包括投递中未确认的
+func (q *DelayQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	return len(q.items)
+}
+
+//Destroy 销毁队列
+func (q *DelayQueue) Destroy() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.items == nil {
+		panic(ErrDestroyed)
+	}
+	q.items = nil
+	q.queue = nil
+	q.cond.Broadcast()
+}
@@ -0,0 +1,136 @@
+package gomap
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayQueue_Take(t *testing.T) {
+	q := NewDelayQueue(DelayQueueConfig{})
+	defer q.Destroy()
+	q.Offer("b", 2, 20*time.Millisecond)
+	q.Offer("a", 1, 10*time.Millisecond)
+	q.Offer("c", 3, 30*time.Millisecond)
+	start := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		d, err := q.Take(context.Background())
+		if err != nil || d.Key != key || d.Attempt != 1 {
+			t.Fatal("unexpected delivery", d, err)
+		}
+	}
+	if time.Since(start) < 30*time.Millisecond || q.Len() != 0 {
+		t.Fatal("Take should wait until due", time.Since(start), q.Len())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	q.Offer("d", 4, time.Hour)
+	if _, err := q.Take(ctx); err != context.DeadlineExceeded {
+		t.Fatal("Take should stop on ctx", err)
+	}
+}
+
+func TestDelayQueue_CancelReschedule(t *testing.T) {
+	q := NewDelayQueue(DelayQueueConfig{})
+	defer q.Destroy()
+	q.Offer("a", 1, time.Hour)
+	q.Offer("b", 2, time.Hour)
+	if !q.Cancel("a") || q.Cancel("a") || q.Len() != 1 {
+		t.Fatal("Cancel")
+	}
+	if q.Reschedule("a", 0) {
+		t.Fatal("Reschedule missing key")
+	}
+	// 等待中的Take在数据提前到期时被唤醒
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Reschedule("b", 0)
+	}()
+	if d, err := q.Take(context.Background()); err != nil || d.Key != "b" {
+		t.Fatal("Reschedule should wake Take", d, err)
+	}
+	// 覆盖写入替换值与到期时间
+	q.Offer("c", 1, time.Hour)
+	q.Offer("c", 2, 0)
+	if d, err := q.Take(context.Background()); err != nil || d.Value != 2 {
+		t.Fatal("Offer should replace", d, err)
+	}
+}
+
+func TestDelayQueue_Visibility(t *testing.T) {
+	q := NewDelayQueue(DelayQueueConfig{VisibilityTimeout: 20 * time.Millisecond})
+	defer q.Destroy()
+	q.Offer("a", 1, 0)
+	d1, err := q.Take(context.Background())
+	if err != nil || d1.Attempt != 1 || q.Len() != 1 {
+		t.Fatal("unexpected delivery", d1, err)
+	}
+	// 未确认的数据超时后重新投递，过期的投递不能确认
+	d2, err := q.Take(context.Background())
+	if err != nil || d2.Key != "a" || d2.Attempt != 2 {
+		t.Fatal("should redeliver", d2, err)
+	}
+	if q.Ack(d1) {
+		t.Fatal("stale delivery acked")
+	}
+	if !q.Ack(d2) || q.Len() != 0 || q.Ack(d2) {
+		t.Fatal("Ack")
+	}
+
+	q.Offer("b", 1, 0)
+	d, _ := q.Take(context.Background())
+	q.Reschedule("b", time.Hour)
+	if q.Ack(d) || q.Len() != 1 {
+		t.Fatal("Reschedule should invalidate delivery")
+	}
+}
+
+func TestDelayQueue_Concurrent(t *testing.T) {
+	q := NewDelayQueue(DelayQueueConfig{VisibilityTimeout: time.Second})
+	const n = 100
+	var mu sync.Mutex
+	seen := map[string]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				d, err := q.Take(ctx)
+				cancel()
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				seen[d.Key]++
+				mu.Unlock()
+				q.Ack(d)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		q.Offer(strconv.Itoa(i), i, time.Duration(i%10)*time.Millisecond)
+	}
+	wg.Wait()
+	if len(seen) != n || q.Len() != 0 {
+		t.Fatal("missing deliveries", len(seen), q.Len())
+	}
+	for k, c := range seen {
+		if c != 1 {
+			t.Fatal("duplicate delivery", k, c)
+		}
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Destroy()
+	}()
+	if _, err := q.Take(context.Background()); err != ErrDestroyed {
+		t.Fatal("destroyed queue should wake Take", err)
+	}
+	if _, err := q.Take(context.Background()); err != ErrDestroyed {
+		t.Fatal("Take on destroyed queue", err)
+	}
+}
@@ -0,0 +1,128 @@
+package gomap
+
+import (
+	"math/rand"
+	"time"
+)
+
+type (
+	// ExpirationMode 过期时间的计时方式
+	ExpirationMode int
+
+	// ExpirationPolicy 过期策略，零值沿用map当前的计时方式，使用map默认过期时间。
+	//
+	// 以默认过期时间写入与读取时各策略是否重新计时：
+	//
+	//	操作                                   Absolute   SlidingOnWrite   SlidingOnAccess
+	//	Store/LoadOrStore写入新key              计时       计时             计时
+	//	Store覆盖、StoreOrCompare、刷新结果写入  保留       重新计时         重新计时
+	//	Update、StoreWithTTL(DefaultExpiration) 保留       重新计时         重新计时
+	//	Load、LoadOrStore命中、Range            -          -                重新计时
+	//	Peek、PeekRange、TTL、ExpiresAt         -          -                -
+	//	Touch                                   重新计时   重新计时         重新计时
+	//
+	// 设置Schedule时按日历计时：过期时间为计时时刻之后的下一个时间点，例如每天零点或每小时整点，TTL被忽略。
+	// 指定ttl的StoreWithTTL、Update以及StoreUntil、Expire、ExpireAt、Persist总是按指定值设置，不受策略与MaxLifetime限制。
+	// MaxLifetime>0时，按策略计算的过期时间不超过key首次写入后MaxLifetime，覆盖写入不重置首次写入时间。
+	// Jitter、JitterRatio使按策略计算的过期时间随机偏移，避免同时写入的大量数据在同一时刻过期。
+	// 永不过期的数据不会被续租。
+	ExpirationPolicy struct {
+		Mode        ExpirationMode
+		TTL         time.Duration // 存活时间，DefaultExpiration时使用map默认过期时间
+		Schedule    Schedule      // 按日历计时，不为nil时代替TTL，例如ParseCron("0 0 * * *", loc)在每天零点过期
+		MaxLifetime time.Duration // 自首次写入起的最长存活时间，<=0时不限制
+		Jitter      time.Duration // 过期时间在±Jitter内随机偏移
+		JitterRatio float64       // 过期时间在±存活时间×JitterRatio内随机偏移，与Jitter同时设置时取较大者
+	}
+)
+
+const (
+	ExpireDefault         ExpirationMode = iota // 沿用map当前的计时方式，即构造时renewOnLoad决定的或之前设置的方式
+	ExpireSlidingOnWrite                        // 每次写入重新计时
+	ExpireAbsolute                              // 只在首次写入时计时，覆盖写入保留原过期时间
+	ExpireSlidingOnAccess                       // 每次写入与读取都重新计时
+)
+
+func (mode ExpirationMode) String() string {
+	switch mode {
+	case ExpireDefault:
+		return "Default"
+	case ExpireSlidingOnWrite:
+		return "SlidingOnWrite"
+	case ExpireAbsolute:
+		return "Absolute"
+	case ExpireSlidingOnAccess:
+		return "SlidingOnAccess"
+	}
+	return "Unknown"
+}
+
+//policyFor 根据renewOnLoad得到与原有行为一致的策略
+func policyFor(renewOnLoad bool) ExpirationPolicy {
+	if renewOnLoad {
+		return ExpirationPolicy{Mode: ExpireSlidingOnAccess}
+	}
+	return ExpirationPolicy{Mode: ExpireSlidingOnWrite}
+}
+
+//inherit 未指定计时方式时沿用mode
+func (p ExpirationPolicy) inherit(mode ExpirationMode) ExpirationPolicy {
+	if p.Mode == ExpireDefault {
+		p.Mode = mode
+	}
+	return p
+}
+
+//expiration 从当前时刻起计时的过期时间戳，-1表示永不过期
+func (p *ExpirationPolicy) expiration(def time.Duration) int64 {
+	if p.Schedule != nil {
+		if next := p.Schedule.Next(time.Now()); !next.IsZero() {
+			return p.jitter(next.UnixNano())
+		}
+		return -1
+	}
+	return p.jitter(expireAt(p.TTL, def))
+}
+
+//jitter 随机偏移过期时间，偏移后不早于当前时刻
+func (p *ExpirationPolicy) jitter(expiration int64) int64 {
+	if expiration <= 0 || (p.Jitter <= 0 && p.JitterRatio <= 0) {
+		return expiration
+	}
+	now := time.Now().UnixNano()
+	d := int64(p.Jitter)
+	if r := int64(float64(expiration-now) * p.JitterRatio); r > d {
+		d = r
+	}
+	if d <= 0 {
+		return expiration
+	}
+	expiration += rand.Int63n(2*d+1) - d
+	if expiration <= now {
+		expiration = now + 1
+	}
+	return expiration
+}
+
+//limit 将过期时间限制在created+MaxLifetime以内
+func (p *ExpirationPolicy) limit(expiration, created int64) int64 {
+	if p.MaxLifetime <= 0 {
+		return expiration
+	}
+	max := created + int64(p.MaxLifetime)
+	if expiration <= 0 || expiration > max {
+		return max
+	}
+	return expiration
+}
+
+//writeExpiration 以默认过期时间写入时的过期时间，old为key当前未过期的数据，不存在时为nil
+func (p *ExpirationPolicy) writeExpiration(old *ttlEntry, def time.Duration) int64 {
+	if old == nil {
+		return p.limit(p.expiration(def), time.Now().UnixNano())
+	}
+	if p.Mode == ExpireAbsolute {
+		return p.limit(old.expiration, old.created)
+	}
+	return p.limit(p.expiration(def), old.created)
+}
@@ -0,0 +1,194 @@
+package gomap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLMap_ExpireAbsolute(t *testing.T) {
+	m := NewTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.SetExpirationPolicy(ExpirationPolicy{Mode: ExpireAbsolute})
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	time.Sleep(time.Millisecond)
+	m.Store("k", 2)
+	m.StoreOrCompare("k", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	m.Load("k")
+	v, _ := m.Load("k")
+	if now, _ := m.ExpiresAt("k"); v != 3 || !now.Equal(at) {
+		t.Fatal("Absolute should keep deadline", v, at, now)
+	}
+}
+
+func TestTTLMap_ExpireSlidingOnWrite(t *testing.T) {
+	m := NewTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	time.Sleep(time.Millisecond)
+	m.Load("k")
+	if now, _ := m.ExpiresAt("k"); !now.Equal(at) {
+		t.Fatal("SlidingOnWrite renewed on read")
+	}
+	m.StoreOrCompare("k", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	if now, _ := m.ExpiresAt("k"); !now.After(at) {
+		t.Fatal("SlidingOnWrite not renewed on write")
+	}
+}
+
+func TestTTLMap_StoreWithPolicy(t *testing.T) {
+	m := NewTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	// 单独指定的策略覆盖map策略
+	m.StoreWithPolicy("a", 1, ExpirationPolicy{Mode: ExpireSlidingOnAccess, TTL: time.Hour})
+	at, _ := m.ExpiresAt("a")
+	time.Sleep(time.Millisecond)
+	m.Load("a")
+	if now, _ := m.ExpiresAt("a"); !now.After(at) || time.Until(now) < 59*time.Minute {
+		t.Fatal("per-entry SlidingOnAccess not renewed with its ttl")
+	}
+}
+
+func TestTTLMap_MaxLifetime(t *testing.T) {
+	m := NewTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.SetExpirationPolicy(ExpirationPolicy{Mode: ExpireSlidingOnAccess, MaxLifetime: 80 * time.Millisecond})
+	m.Store("k", 1)
+	for i := 0; i < 6; i++ {
+		time.Sleep(20 * time.Millisecond)
+		m.Load("k")
+	}
+	if _, ok := m.Peek("k"); ok {
+		t.Fatal("MaxLifetime exceeded")
+	}
+}
+
+func TestLinkedTTLMap_ExpireAbsolute(t *testing.T) {
+	m := NewLinkedTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.SetExpirationPolicy(ExpirationPolicy{Mode: ExpireAbsolute})
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	time.Sleep(time.Millisecond)
+	m.Store("k", 2)
+	m.StoreOrCompare("k", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	m.Load("k")
+	v, _ := m.Load("k")
+	if now, _ := m.ExpiresAt("k"); v != 3 || !now.Equal(at) {
+		t.Fatal("Absolute should keep deadline", v, at, now)
+	}
+}
+
+func TestLinkedTTLMap_ExpireSlidingOnWrite(t *testing.T) {
+	m := NewLinkedTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	time.Sleep(time.Millisecond)
+	m.Load("k")
+	if now, _ := m.ExpiresAt("k"); !now.Equal(at) {
+		t.Fatal("SlidingOnWrite renewed on read")
+	}
+	m.StoreOrCompare("k", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	if now, _ := m.ExpiresAt("k"); !now.After(at) {
+		t.Fatal("SlidingOnWrite not renewed on write")
+	}
+}
+
+func TestLinkedTTLMap_StoreWithPolicy(t *testing.T) {
+	m := NewLinkedTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	// 单独指定的策略覆盖map策略
+	m.StoreWithPolicy("a", 1, ExpirationPolicy{Mode: ExpireSlidingOnAccess, TTL: time.Hour})
+	at, _ := m.ExpiresAt("a")
+	time.Sleep(time.Millisecond)
+	m.Load("a")
+	if now, _ := m.ExpiresAt("a"); !now.After(at) || time.Until(now) < 59*time.Minute {
+		t.Fatal("per-entry SlidingOnAccess not renewed with its ttl")
+	}
+}
+
+func TestLinkedTTLMap_MaxLifetime(t *testing.T) {
+	m := NewLinkedTTLMap(50*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	m.SetExpirationPolicy(ExpirationPolicy{Mode: ExpireSlidingOnAccess, MaxLifetime: 80 * time.Millisecond})
+	m.Store("k", 1)
+	for i := 0; i < 6; i++ {
+		time.Sleep(20 * time.Millisecond)
+		m.Load("k")
+	}
+	if _, ok := m.Peek("k"); ok {
+		t.Fatal("MaxLifetime exceeded")
+	}
+}
+
+func TestExpirationPolicy_Jitter(t *testing.T) {
+	m := NewTTLMap(time.Minute, -1, false)
+	defer m.Destroy()
+	m.SetExpirationPolicy(ExpirationPolicy{JitterRatio: 0.1})
+	min, max := time.Duration(1<<62), time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		m.Store(key, i)
+		ttl, _ := m.TTL(key)
+		if ttl < min {
+			min = ttl
+		}
+		if ttl > max {
+			max = ttl
+		}
+	}
+	if min < 53*time.Second || max > 67*time.Second || max-min < 6*time.Second {
+		t.Fatal("jitter not applied", min, max)
+	}
+}
+
+func TestTTLMap_ExpireDefault(t *testing.T) {
+	m := NewTTLMap(50*time.Millisecond, 10*time.Millisecond, true)
+	defer m.Destroy()
+	// 未指定Mode时保留renewOnLoad对应的读取续租
+	m.SetExpirationPolicy(ExpirationPolicy{MaxLifetime: time.Hour})
+	m.StoreWithPolicy("a", 1, ExpirationPolicy{TTL: time.Hour})
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	atA, _ := m.ExpiresAt("a")
+	time.Sleep(time.Millisecond)
+	m.Load("k")
+	m.Load("a")
+	if now, _ := m.ExpiresAt("k"); !now.After(at) {
+		t.Fatal("renewOnLoad lost after SetExpirationPolicy")
+	}
+	if now, _ := m.ExpiresAt("a"); !now.After(atA) {
+		t.Fatal("StoreWithPolicy did not inherit map mode")
+	}
+}
+
+func TestLinkedTTLMap_ExpireDefault(t *testing.T) {
+	m := NewLinkedTTLMap(50*time.Millisecond, 10*time.Millisecond, true)
+	defer m.Destroy()
+	// 未指定Mode时保留renewOnLoad对应的读取续租
+	m.SetExpirationPolicy(ExpirationPolicy{MaxLifetime: time.Hour})
+	m.StoreWithPolicy("a", 1, ExpirationPolicy{TTL: time.Hour})
+	m.Store("k", 1)
+	at, _ := m.ExpiresAt("k")
+	atA, _ := m.ExpiresAt("a")
+	time.Sleep(time.Millisecond)
+	m.Load("k")
+	m.Load("a")
+	if now, _ := m.ExpiresAt("k"); !now.After(at) {
+		t.Fatal("renewOnLoad lost after SetExpirationPolicy")
+	}
+	if now, _ := m.ExpiresAt("a"); !now.After(atA) {
+		t.Fatal("StoreWithPolicy did not inherit map mode")
+	}
+}
@@ -0,0 +1,149 @@
+package gomap
+
+import (
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// FileBackend 以目录存储数据的Backend，每个key一个文件，适合本地开发使用。
+	// 值通过gob编码，自定义类型需要先gob.Register。
+	FileBackend struct {
+		dir       string
+		mu        sync.RWMutex
+		listeners map[int]func(key string)
+		nextID    int
+	}
+
+	fileRecord struct {
+		Key      string
+		Value    interface{}
+		ExpireAt int64 // -1表示永不过期
+	}
+)
+
+//NewFileBackend dir不存在时自动创建
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileBackend{dir: dir, listeners: map[int]func(string){}}, nil
+}
+
+func (b *FileBackend) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(b.dir, hex.EncodeToString(sum[:]))
+}
+
+//read 读取记录，文件不存在时ok为false
+func (b *FileBackend) read(key string) (record fileRecord, ok bool, err error) {
+	f, err := os.Open(b.path(key))
+	if os.IsNotExist(err) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	defer f.Close()
+	if err := gob.NewDecoder(f).Decode(&record); err != nil {
+		return record, false, err
+	}
+	return record, record.Key == key, nil
+}
+
+func (b *FileBackend) Get(key string) (interface{}, bool, error) {
+	b.mu.RLock()
+	record, ok, err := b.read(key)
+	b.mu.RUnlock()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if record.ExpireAt > 0 && time.Now().UnixNano() > record.ExpireAt {
+		b.mu.Lock()
+		// 加写锁后重新确认，避免删除并发写入的新值
+		record, ok, err = b.read(key)
+		expired := err == nil && ok && record.ExpireAt > 0 && time.Now().UnixNano() > record.ExpireAt
+		if expired {
+			err = os.Remove(b.path(key))
+		}
+		b.mu.Unlock()
+		if expired {
+			b.notify(key)
+		}
+		return nil, false, err
+	}
+	return record.Value, true, nil
+}
+
+//Set 先写临时文件再重命名，保证读取不到写入一半的数据
+func (b *FileBackend) Set(key string, value interface{}, ttl time.Duration) error {
+	record := fileRecord{Key: key, Value: value, ExpireAt: -1}
+	if ttl > 0 {
+		record.ExpireAt = time.Now().Add(ttl).UnixNano()
+	}
+	f, err := ioutil.TempFile(b.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if err = gob.NewEncoder(f).Encode(&record); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.Rename(f.Name(), b.path(key)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (b *FileBackend) Delete(key string) error {
+	b.mu.Lock()
+	err := os.Remove(b.path(key))
+	b.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		b.notify(key)
+	}
+	return err
+}
+
+//OnDelete 注册删除通知，包括Delete与读取时发现的过期
+func (b *FileBackend) OnDelete(f func(key string)) (cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.listeners[id] = f
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.listeners, id)
+	}
+}
+
+func (b *FileBackend) notify(key string) {
+	b.mu.RLock()
+	listeners := make([]func(string), 0, len(b.listeners))
+	for _, f := range b.listeners {
+		listeners = append(listeners, f)
+	}
+	b.mu.RUnlock()
+	for _, f := range listeners {
+		f(key)
+	}
+}
@@ -0,0 +1,98 @@
+package gomap
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// GCConfig 增量清理配置。每轮持锁检查SampleSize个随机数据并删除其中过期的，
+	// 过期比例超过ExpiredRatio时继续下一轮，直到比例回落或本次清理的预算耗尽。
+	// 过期比例较低时部分过期数据会留到之后的清理，在此之前读取不到但仍占用内存。
+	GCConfig struct {
+		SampleSize   int           // 每轮检查的数量，默认20
+		ExpiredRatio float64       // 继续抽样的过期比例阈值，默认0.25
+		MaxExamined  int           // 每次清理最多检查的数量，<=0时不限制
+		MaxDuration  time.Duration // 每次清理的最长耗时，默认1ms，<0时不限制
+	}
+
+	// GCPass 一次增量清理的统计
+	GCPass struct {
+		Rounds    int           // 抽样轮数
+		Examined  int           // 检查的数量
+		Expired   int           // 删除的过期数据数量
+		Duration  time.Duration // 耗时
+		Truncated bool          // 因预算耗尽而结束，可能仍有较多过期数据
+	}
+
+	// GCStats 增量清理的累计统计
+	GCStats struct {
+		Passes    int64  // 清理次数
+		Rounds    int64  // 抽样轮数
+		Examined  int64  // 检查的数量
+		Expired   int64  // 删除的过期数据数量
+		Truncated int64  // 因预算耗尽而结束的次数
+		Last      GCPass // 最近一次清理
+	}
+
+	gcRecorder struct {
+		mu    sync.Mutex
+		stats GCStats
+	}
+)
+
+func (c GCConfig) withDefaults() GCConfig {
+	if c.SampleSize <= 0 {
+		c.SampleSize = 20
+	}
+	if c.ExpiredRatio <= 0 {
+		c.ExpiredRatio = 0.25
+	}
+	if c.MaxDuration == 0 {
+		c.MaxDuration = time.Millisecond
+	}
+	return c
+}
+
+//pass 执行一次自适应抽样清理，round在持锁时检查最多n个数据，map已销毁时ok为false
+func (c GCConfig) pass(round func(n int) (examined, expired int, ok bool)) (p GCPass, ok bool) {
+	start := time.Now()
+	defer func() {
+		p.Duration = time.Since(start)
+	}()
+	for {
+		examined, expired, ok := round(c.SampleSize)
+		if !ok {
+			return p, false
+		}
+		p.Rounds++
+		p.Examined += examined
+		p.Expired += expired
+		if examined == 0 || float64(expired) <= c.ExpiredRatio*float64(examined) {
+			return p, true
+		}
+		if (c.MaxExamined > 0 && p.Examined >= c.MaxExamined) || (c.MaxDuration > 0 && time.Since(start) >= c.MaxDuration) {
+			p.Truncated = true
+			return p, true
+		}
+	}
+}
+
+func (r *gcRecorder) record(p GCPass) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Passes++
+	r.stats.Rounds += int64(p.Rounds)
+	r.stats.Examined += int64(p.Examined)
+	r.stats.Expired += int64(p.Expired)
+	if p.Truncated {
+		r.stats.Truncated++
+	}
+	r.stats.Last = p
+}
+
+func (r *gcRecorder) get() GCStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
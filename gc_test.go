@@ -0,0 +1,330 @@
+package gomap
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLMap_IncrementalGC(t *testing.T) {
+	m := NewTTLMap(DefaultExpiration, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: -1})
+	for i := 0; i < 1000; i++ {
+		m.StoreWithTTL(strconv.Itoa(i), i, time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		m.StoreWithTTL("keep"+strconv.Itoa(i), i, NoExpiration)
+	}
+	time.Sleep(2 * time.Millisecond)
+	// 抽样的过期比例降到阈值以下后停止，剩余少量过期数据由之后的清理逐步删除
+	for i := 0; i < 1000 && m.Size() > 100+40; i++ {
+		if !m.gc() {
+			t.Fatal("gc stopped")
+		}
+	}
+	if n := m.Size(); n > 100+40 {
+		t.Fatal("expired entries not collected", n)
+	}
+	stats := m.GCStats()
+	if stats.Passes == 0 || stats.Rounds < stats.Passes || int(stats.Expired) != 1100-m.Size() || stats.Examined < stats.Expired {
+		t.Fatal("unexpected stats", stats)
+	}
+	if v, ok := m.Load("keep1"); !ok || v != 1 {
+		t.Fatal("unexpired entry deleted")
+	}
+}
+
+func TestLinkedTTLMap_IncrementalGC(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: -1})
+	for i := 0; i < 1000; i++ {
+		m.StoreWithTTL(strconv.Itoa(i), i, time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		m.StoreWithTTL("keep"+strconv.Itoa(i), i, NoExpiration)
+	}
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 1000 && m.Size() > 100+40; i++ {
+		if !m.gc() {
+			t.Fatal("gc stopped")
+		}
+	}
+	if n := m.Size(); n > 100+40 {
+		t.Fatal("expired entries not collected", n)
+	}
+	stats := m.GCStats()
+	if stats.Passes == 0 || stats.Rounds < stats.Passes || int(stats.Expired) != 1100-m.Size() || stats.Examined < stats.Expired {
+		t.Fatal("unexpected stats", stats)
+	}
+	if v, ok := m.Load("keep1"); !ok || v != 1 {
+		t.Fatal("unexpired entry deleted")
+	}
+}
+
+func TestTTLMap_IncrementalGCChurn(t *testing.T) {
+	m := NewTTLMap(time.Minute, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: -1})
+	for i := 0; i < 10000; i++ {
+		m.Store(strconv.Itoa(i), i)
+		m.Delete(strconv.Itoa(i))
+		if i%100 == 0 {
+			m.gc()
+		}
+	}
+	// 删除的key立即移出抽样范围，不会在gcSlots中积累
+	m.StoreWithTTL("a", 1, time.Millisecond)
+	m.Store("b", 2)
+	if len(m.gcSlots) != 2 || m.Size() != 2 {
+		t.Fatal("deleted keys left in slots", len(m.gcSlots), m.Size())
+	}
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 100 && m.Size() > 1; i++ {
+		m.gc()
+	}
+	if _, ok := m.Peek("b"); !ok || len(m.gcSlots) != 1 || m.Size() != 1 {
+		t.Fatal("expired key not sampled", len(m.gcSlots), m.Size())
+	}
+}
+
+func TestLinkedTTLMap_IncrementalGCChurn(t *testing.T) {
+	m := NewLinkedTTLMap(time.Minute, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: -1})
+	for i := 0; i < 10000; i++ {
+		m.Store(strconv.Itoa(i), i)
+		m.Store(strconv.Itoa(i), i+1)
+		m.Delete(strconv.Itoa(i))
+	}
+	m.StoreWithTTL("a", 1, time.Millisecond)
+	m.Store("b", 2)
+	if len(m.gcSlots) != 2 || m.gcSlots[0] != m.entryMap["a"] || m.gcSlots[1].slot != 1 {
+		t.Fatal("deleted keys left in slots", len(m.gcSlots))
+	}
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 100 && m.Size() > 1; i++ {
+		m.gc()
+	}
+	if _, ok := m.Peek("b"); !ok || len(m.gcSlots) != 1 || m.Size() != 1 {
+		t.Fatal("expired key not sampled", len(m.gcSlots), m.Size())
+	}
+}
+
+func TestTTLMap_GCBatch(t *testing.T) {
+	m := NewTTLMap(10*time.Millisecond, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCBatch(7)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	m.StoreWithTTL("keep", 1, NoExpiration)
+	time.Sleep(20 * time.Millisecond)
+	if deleted := m.DeleteExpired(); len(deleted) != 100 || m.Size() != 1 {
+		t.Fatal("batched DeleteExpired", len(deleted), m.Size())
+	}
+	l := NewLinkedTTLMap(10*time.Millisecond, time.Hour, false)
+	defer l.Destroy()
+	l.SetGCBatch(7)
+	for i := 0; i < 100; i++ {
+		l.Store(strconv.Itoa(i), i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if deleted := l.DeleteExpired(); len(deleted) != 100 || l.Size() != 0 {
+		t.Fatal("batched DeleteExpired", len(deleted), l.Size())
+	}
+}
+
+func TestTTLMap_GCBatchBounded(t *testing.T) {
+	m := NewTTLMap(10*time.Millisecond, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCBatch(7)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// 每批最多检查7个key，期间被重新写入的key不删除
+	m.Store(m.gcSlots[0], "renewed")
+	deleted := map[string]interface{}{}
+	if done, _ := m.deleteExpiredBatch(true, deleted); done || len(deleted) != 6 || m.Size() != 94 {
+		t.Fatal("batch deleted", done, len(deleted), m.Size())
+	}
+	// 下一批从游标处继续
+	if done, _ := m.deleteExpiredBatch(false, deleted); done || len(deleted) != 13 || m.Size() != 87 {
+		t.Fatal("batch not resumed", done, len(deleted), m.Size())
+	}
+	for done := false; !done; {
+		done, _ = m.deleteExpiredBatch(false, deleted)
+	}
+	if len(deleted) != 99 || m.Size() != 1 || len(m.gcSlots) != 1 {
+		t.Fatal("expired keys left", len(deleted), m.Size(), len(m.gcSlots))
+	}
+}
+
+func TestLinkedTTLMap_GCBatchBounded(t *testing.T) {
+	m := NewLinkedTTLMap(10*time.Millisecond, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCBatch(7)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// 每批最多检查7个key，期间被重新写入的key不删除
+	m.Store("0", "renewed")
+	var deleted []Entry
+	if done, _ := m.deleteExpiredBatch(true, &deleted); done || len(deleted) != 6 || m.Size() != 94 {
+		t.Fatal("batch deleted", done, len(deleted), m.Size())
+	}
+	// 游标所在的节点被删除后从其下一节点继续
+	m.Delete("7")
+	if done, _ := m.deleteExpiredBatch(false, &deleted); done || len(deleted) != 13 || deleted[6].Key != "8" || m.Size() != 86 {
+		t.Fatal("batch not resumed", done, len(deleted), m.Size())
+	}
+	for done := false; !done; {
+		done, _ = m.deleteExpiredBatch(false, &deleted)
+	}
+	if len(deleted) != 98 || m.Size() != 1 {
+		t.Fatal("expired keys left", len(deleted), m.Size())
+	}
+}
+
+func TestTTLMap_GCBatchDeleteScanned(t *testing.T) {
+	m := NewTTLMap(NoExpiration, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCBatch(7)
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			m.StoreWithTTL(strconv.Itoa(i), i, time.Millisecond)
+		} else {
+			m.Store(strconv.Itoa(i), i)
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+	deleted := map[string]interface{}{}
+	m.deleteExpiredBatch(true, deleted)
+	// 删除已检查过的key后，末尾未检查的key仍在本次扫描范围内
+	for _, key := range append([]string(nil), m.gcSlots[:m.gcCursor]...) {
+		m.Delete(key)
+	}
+	for done := false; !done; {
+		done, _ = m.deleteExpiredBatch(false, deleted)
+	}
+	if len(deleted) != 50 {
+		t.Fatal("expired keys skipped", len(deleted))
+	}
+	for key, item := range m.entryMap {
+		if m.gcSlots[item.slot] != key {
+			t.Fatal("slot mismatch", key, item.slot)
+		}
+	}
+}
+
+func TestTTLMap_GCBudget(t *testing.T) {
+	m := NewTTLMap(DefaultExpiration, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxExamined: 30, MaxDuration: -1})
+	for i := 0; i < 1000; i++ {
+		m.StoreWithTTL(strconv.Itoa(i), i, time.Millisecond)
+	}
+	time.Sleep(2 * time.Millisecond)
+	m.gc()
+	last := m.GCStats().Last
+	if !last.Truncated || last.Rounds > 3 || last.Examined > 30 || last.Expired != last.Examined || m.Size() != 1000-last.Expired {
+		t.Fatal("examined budget not applied", last)
+	}
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: time.Nanosecond})
+	m.gc()
+	if last = m.GCStats().Last; !last.Truncated || last.Rounds != 1 {
+		t.Fatal("duration budget not applied", last)
+	}
+}
+
+func TestLinkedTTLMap_GCBudget(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, time.Hour, false)
+	defer m.Destroy()
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxExamined: 30, MaxDuration: -1})
+	for i := 0; i < 1000; i++ {
+		m.StoreWithTTL(strconv.Itoa(i), i, time.Millisecond)
+	}
+	time.Sleep(2 * time.Millisecond)
+	m.gc()
+	last := m.GCStats().Last
+	if !last.Truncated || last.Rounds > 3 || last.Examined > 30 || last.Expired != last.Examined || m.Size() != 1000-last.Expired {
+		t.Fatal("examined budget not applied", last)
+	}
+	m.SetGCConfig(GCConfig{SampleSize: 10, MaxDuration: time.Nanosecond})
+	m.gc()
+	if last = m.GCStats().Last; !last.Truncated || last.Rounds != 1 {
+		t.Fatal("duration budget not applied", last)
+	}
+}
+
+func TestGCConfig_Pass(t *testing.T) {
+	cfg := GCConfig{}.withDefaults()
+	if cfg.SampleSize != 20 || cfg.ExpiredRatio != 0.25 || cfg.MaxDuration != time.Millisecond {
+		t.Fatal("unexpected defaults", cfg)
+	}
+	cfg.MaxDuration = -1
+	expired := []int{20, 10, 5, 0}
+	p, ok := cfg.pass(func(n int) (int, int, bool) {
+		e := expired[0]
+		expired = expired[1:]
+		return n, e, true
+	})
+	if !ok || p.Rounds != 3 || p.Examined != 60 || p.Expired != 35 || p.Truncated {
+		t.Fatal("unexpected pass", p)
+	}
+	if _, ok = cfg.pass(func(n int) (int, int, bool) { return 0, 0, false }); ok {
+		t.Fatal("destroyed map should stop pass")
+	}
+}
+
+//BenchmarkTTLMap_LoadDuringGC 100万数据中一半在测量期间陆续过期，比较各清理方式下Load的p99延迟
+func BenchmarkTTLMap_LoadDuringGC(b *testing.B) {
+	const size = 1000000
+	cases := []struct {
+		name  string
+		setup func(m *TTLMap)
+	}{
+		{"FullScan", func(m *TTLMap) { m.SetGCBatch(0) }},
+		{"Batched", func(m *TTLMap) {}},
+		{"Incremental", func(m *TTLMap) { m.SetGCConfig(GCConfig{}) }},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			m := NewTTLMap(NoExpiration, 100*time.Millisecond, false)
+			defer m.Destroy()
+			c.setup(m)
+			for i := 0; i < size; i++ {
+				m.Store(strconv.Itoa(i), i)
+			}
+			// 在一次持锁中设置过期时间，过期时刻相对写入完成的时刻分散在之后的1s内，从第一个数据过期时开始测量
+			m.mu.Lock()
+			base := time.Now().Add(time.Second)
+			for i := 0; i < size; i += 2 {
+				key := strconv.Itoa(i)
+				item := m.entryMap[key]
+				item.expiration = base.Add(time.Duration(i%1000) * time.Millisecond).UnixNano()
+				m.entryMap[key] = item
+			}
+			m.mu.Unlock()
+			if time.Now().After(base) {
+				b.Fatal("setting expirations took too long")
+			}
+			time.Sleep(time.Until(base))
+			latencies := make([]time.Duration, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				m.Load(strconv.Itoa(i % size))
+				latencies[i] = time.Since(start)
+			}
+			b.StopTimer()
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+			b.ReportMetric(float64(latencies[len(latencies)-1].Nanoseconds()), "max-ns")
+		})
+	}
+}
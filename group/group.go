@@ -0,0 +1,218 @@
+//Package group 提供groupcache风格的分布式只读缓存：
+// key由一致性哈希选出的节点负责加载，其他节点向其请求，避免每个节点都访问数据源。
+// 同一key的并发加载在本地与负责节点上均只执行一次，热门的远程key会缓存在本地。
+package group
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type (
+	// Getter 从数据源加载key，key不存在时应返回ErrNotFound
+	Getter func(ctx context.Context, key string) ([]byte, error)
+
+	// PeerPicker 选择key的负责节点，负责节点为自身时返回false
+	PeerPicker interface {
+		PickPeer(key string) (peer PeerGetter, ok bool)
+	}
+
+	// PeerGetter 向远程节点请求key
+	PeerGetter interface {
+		Get(ctx context.Context, group, key string) ([]byte, error)
+	}
+
+	Group struct {
+		name     string
+		getter   Getter
+		peers    PeerPicker    // 为空时只在本地加载
+		main     *gomap.TTLMap // 本节点负责的key
+		hot      *gomap.TTLMap // 热门的远程key
+		hotEvery int32         // 远程加载的结果每hotEvery次放入hot一次
+		loads    flightGroup
+		stats    Stats
+	}
+
+	// Stats 统计数据
+	Stats struct {
+		Gets          int64 // Get调用次数
+		MainHits      int64 // main缓存命中
+		HotHits       int64 // hot缓存命中
+		Loads         int64 // 缓存未命中后的加载次数（去重后）
+		LoadsDeduped  int64 // 被合并的并发加载
+		PeerLoads     int64 // 从远程节点加载成功
+		PeerErrors    int64 // 远程节点加载失败，随后改为本地加载
+		LocalLoads    int64 // 调用Getter
+		LocalErrors   int64 // Getter返回错误
+		ServerRequest int64 // 作为负责节点收到的请求
+	}
+)
+
+const defaultHotEvery = 10
+
+var ErrNotFound = errors.New("group: not found")
+
+//NewGroup 创建只在本地加载的group，expiration为main缓存的过期时间，hot缓存的过期时间为其1/10
+func NewGroup(name string, expiration time.Duration, getter Getter) *Group {
+	return newGroup(name, expiration, getter, nil)
+}
+
+func newGroup(name string, expiration time.Duration, getter Getter, peers PeerPicker) *Group {
+	hotExpiration := expiration / 10
+	if expiration <= 0 {
+		hotExpiration = time.Minute
+	}
+	return &Group{
+		name:     name,
+		getter:   getter,
+		peers:    peers,
+		main:     gomap.NewTTLMap(expiration, 0, false),
+		hot:      gomap.NewTTLMap(hotExpiration, 0, false),
+		hotEvery: defaultHotEvery,
+	}
+}
+
+func (g *Group) Name() string {
+	return g.name
+}
+
+//Get 依次查找main、hot缓存，未命中时由负责节点加载；返回值可以修改
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	return g.get(ctx, key, true)
+}
+
+//getOwned 作为负责节点处理远程请求，未命中时只在本地加载
+func (g *Group) getOwned(ctx context.Context, key string) ([]byte, error) {
+	return g.get(ctx, key, false)
+}
+
+func (g *Group) get(ctx context.Context, key string, forward bool) ([]byte, error) {
+	atomic.AddInt64(&g.stats.Gets, 1)
+	if value, ok := g.lookup(key); ok {
+		return clone(value), nil
+	}
+	value, err, shared := g.loads.do(key, func() ([]byte, error) {
+		// 等待期间其他调用可能已写入缓存
+		if value, ok := g.lookup(key); ok {
+			return value, nil
+		}
+		atomic.AddInt64(&g.stats.Loads, 1)
+		// 合并的加载由多个调用方共享，不随发起者的ctx取消
+		ctx := detachedContext{ctx}
+		if !forward {
+			return g.loadLocally(ctx, key)
+		}
+		return g.load(ctx, key)
+	})
+	if shared {
+		atomic.AddInt64(&g.stats.LoadsDeduped, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return clone(value), nil
+}
+
+//detachedContext 保留parent中的值，但没有截止时间也不会被取消
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+func (g *Group) lookup(key string) ([]byte, bool) {
+	if value, ok := g.main.Load(key); ok {
+		atomic.AddInt64(&g.stats.MainHits, 1)
+		return value.([]byte), true
+	}
+	if value, ok := g.hot.Load(key); ok {
+		atomic.AddInt64(&g.stats.HotHits, 1)
+		return value.([]byte), true
+	}
+	return nil, false
+}
+
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			value, err := peer.Get(ctx, g.name, key)
+			if err == nil {
+				atomic.AddInt64(&g.stats.PeerLoads, 1)
+				// 抽样放入hot缓存，越常访问的key越可能被缓存
+				if every := atomic.LoadInt32(&g.hotEvery); every <= 1 || rand.Int31n(every) == 0 {
+					g.hot.Store(key, value)
+				}
+				return value, nil
+			}
+			if err == ErrNotFound || ctx.Err() != nil {
+				return nil, err
+			}
+			// 负责节点不可用时本地加载
+			atomic.AddInt64(&g.stats.PeerErrors, 1)
+		}
+	}
+	return g.loadLocally(ctx, key)
+}
+
+func (g *Group) loadLocally(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddInt64(&g.stats.LocalLoads, 1)
+	value, err := g.getter(ctx, key)
+	if err != nil {
+		atomic.AddInt64(&g.stats.LocalErrors, 1)
+		return nil, err
+	}
+	value = clone(value)
+	g.main.Store(key, value)
+	return value, nil
+}
+
+//Remove 从本节点的缓存中移除key，其他节点的hot缓存在过期前仍可能返回旧值
+func (g *Group) Remove(key string) {
+	g.main.Delete(key)
+	g.hot.Delete(key)
+}
+
+//Stats 统计数据快照
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:          atomic.LoadInt64(&g.stats.Gets),
+		MainHits:      atomic.LoadInt64(&g.stats.MainHits),
+		HotHits:       atomic.LoadInt64(&g.stats.HotHits),
+		Loads:         atomic.LoadInt64(&g.stats.Loads),
+		LoadsDeduped:  atomic.LoadInt64(&g.stats.LoadsDeduped),
+		PeerLoads:     atomic.LoadInt64(&g.stats.PeerLoads),
+		PeerErrors:    atomic.LoadInt64(&g.stats.PeerErrors),
+		LocalLoads:    atomic.LoadInt64(&g.stats.LocalLoads),
+		LocalErrors:   atomic.LoadInt64(&g.stats.LocalErrors),
+		ServerRequest: atomic.LoadInt64(&g.stats.ServerRequest),
+	}
+}
+
+//Destroy 销毁缓存
+func (g *Group) Destroy() {
+	g.main.Destroy()
+	g.hot.Destroy()
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,198 @@
+package group
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	pool   *HTTPPool
+	group  *Group
+	server *httptest.Server
+}
+
+//newTestNodes 启动n个节点，返回各节点以及数据源被调用的次数
+func newTestNodes(t *testing.T, n int, getter Getter) []*testNode {
+	nodes := make([]*testNode, n)
+	var urls []string
+	for i := range nodes {
+		node := &testNode{}
+		node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.pool.ServeHTTP(w, r)
+		}))
+		nodes[i] = node
+		urls = append(urls, node.server.URL)
+	}
+	for i, node := range nodes {
+		node.pool = NewHTTPPool(urls[i])
+		node.pool.Set(urls...)
+		node.group = node.pool.NewGroup("test", time.Minute, getter)
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.server.Close()
+			node.group.Destroy()
+		}
+	})
+	return nodes
+}
+
+func TestGroup_LoadOnce(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	nodes := newTestNodes(t, 3, func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return []byte("v" + key), nil
+	})
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(g *Group) {
+				defer wg.Done()
+				value, err := g.Get(context.Background(), "k")
+				if err != nil || string(value) != "vk" {
+					t.Error("Get", string(value), err)
+				}
+			}(node.group)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatal("loaded more than once", calls)
+	}
+	var deduped, served int64
+	for _, node := range nodes {
+		stats := node.group.Stats()
+		deduped += stats.LoadsDeduped
+		served += stats.ServerRequest
+	}
+	if deduped == 0 || served > 2 {
+		t.Fatal("singleflight not effective", deduped, served)
+	}
+}
+
+func TestGroup_HotCache(t *testing.T) {
+	var calls int64
+	nodes := newTestNodes(t, 2, func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		return []byte(key), nil
+	})
+	// 找到由另一个节点负责的key
+	g := nodes[0].group
+	g.hotEvery = 1
+	var key string
+	for i := 0; ; i++ {
+		key = strconv.Itoa(i)
+		if _, remote := nodes[0].pool.PickPeer(key); remote {
+			break
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := g.Get(context.Background(), key); err != nil || string(value) != key {
+			t.Fatal("Get", err)
+		}
+	}
+	stats := g.Stats()
+	if stats.PeerLoads != 1 || stats.HotHits != 2 || nodes[1].group.Stats().ServerRequest != 1 {
+		t.Fatal("hot cache not used", stats)
+	}
+}
+
+func TestGroup_NotFoundAndPeerDown(t *testing.T) {
+	nodes := newTestNodes(t, 2, func(ctx context.Context, key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, ErrNotFound
+		}
+		return []byte(key), nil
+	})
+	for _, node := range nodes {
+		if _, err := node.group.Get(context.Background(), "missing"); err != ErrNotFound {
+			t.Fatal("not found", err)
+		}
+	}
+	// 负责节点不可用时本地加载
+	nodes[1].server.Close()
+	for i := 0; i < 20; i++ {
+		key := strconv.Itoa(i)
+		if value, err := nodes[0].group.Get(context.Background(), key); err != nil || string(value) != key {
+			t.Fatal("fallback", err)
+		}
+	}
+	if nodes[0].group.Stats().PeerErrors == 0 {
+		t.Fatal("peer errors not counted")
+	}
+}
+
+func TestGroup_SharedLoad(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("shared", time.Minute, func(ctx context.Context, key string) ([]byte, error) {
+		close(started)
+		<-release
+		// 发起者取消后合并的加载仍继续
+		return []byte(key), ctx.Err()
+	})
+	defer g.Destroy()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := g.Get(ctx, "k")
+		done <- err
+	}()
+	<-started
+	go func() {
+		value, err := g.Get(context.Background(), "k")
+		if err == nil && string(value) != "k" {
+			err = ErrNotFound
+		}
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal("shared load failed", err)
+		}
+	}
+	if deduped := g.Stats().LoadsDeduped; deduped != 1 {
+		t.Fatal("LoadsDeduped", deduped)
+	}
+}
+
+func TestFlightGroup_Panic(t *testing.T) {
+	var f flightGroup
+	started, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() {
+			recover()
+		}()
+		f.do("k", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("load")
+		})
+	}()
+	<-started
+	result := make(chan error)
+	go func() {
+		_, err, _ := f.do("k", func() ([]byte, error) {
+			return []byte("v"), nil
+		})
+		result <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-result; err != errLoadPanicked {
+		t.Fatal("waiter should get an error when the load panics", err)
+	}
+}
@@ -0,0 +1,150 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+const defaultBasePath = "/_gomap/"
+
+type (
+	// HTTPPool 基于HTTP的节点集合，既为本节点的group提供服务，也负责选择并请求远程节点。
+	//
+	//	GET {basePath}{group}/{key}  200返回值，404表示数据源中不存在，500为加载失败
+	HTTPPool struct {
+		self     string // 本节点地址，如http://10.0.0.1:8000
+		basePath string
+		client   *http.Client
+		mu       sync.RWMutex
+		ring     *gomap.HashRing
+		getters  map[string]*httpGetter // 远程节点
+		groups   map[string]*Group
+	}
+
+	httpGetter struct {
+		client  *http.Client
+		baseURL string
+	}
+)
+
+//NewHTTPPool self为本节点地址，需与Set中使用的地址一致
+func NewHTTPPool(self string) *HTTPPool {
+	return &HTTPPool{
+		self:     self,
+		basePath: defaultBasePath,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		getters:  map[string]*httpGetter{},
+		groups:   map[string]*Group{},
+	}
+}
+
+//Set 设置全部节点地址，应包含本节点
+func (p *HTTPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ring = gomap.NewHashRing(0, nil)
+	p.ring.Add(peers...)
+	p.getters = map[string]*httpGetter{}
+	for _, peer := range peers {
+		p.getters[peer] = &httpGetter{client: p.client, baseURL: strings.TrimSuffix(peer, "/") + p.basePath}
+	}
+}
+
+//NewGroup 创建由该节点集合共同加载的group
+func (p *HTTPPool) NewGroup(name string, expiration time.Duration, getter Getter) *Group {
+	g := newGroup(name, expiration, getter, p)
+	p.mu.Lock()
+	p.groups[name] = g
+	p.mu.Unlock()
+	return g
+}
+
+func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.ring == nil {
+		return nil, false
+	}
+	if peer := p.ring.Get(key); peer != "" && peer != p.self {
+		return p.getters[peer], true
+	}
+	return nil, false
+}
+
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.EscapedPath(), p.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), p.basePath), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	name, err1 := url.PathUnescape(parts[0])
+	key, err2 := url.PathUnescape(parts[1])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	p.mu.RLock()
+	g, ok := p.groups[name]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return
+	}
+	atomic.AddInt64(&g.stats.ServerRequest, 1)
+	// 负责节点上同样经过缓存与singleflight，但不再转发，避免节点视图不一致时循环请求
+	value, err := g.getOwned(r.Context(), key)
+	if err == ErrNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+func (h *httpGetter) Get(ctx context.Context, group, key string) ([]byte, error) {
+	u := h.baseURL + url.PathEscape(group) + "/" + url.PathEscape(key)
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		if strings.HasPrefix(string(body), ErrNotFound.Error()) {
+			return nil, ErrNotFound
+		}
+	}
+	return nil, fmt.Errorf("group: peer returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
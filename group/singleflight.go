@@ -0,0 +1,55 @@
+package group
+
+import (
+	"errors"
+	"sync"
+)
+
+type (
+	// call 一次进行中的加载
+	call struct {
+		wg    sync.WaitGroup
+		value []byte
+		err   error
+	}
+
+	// flightGroup 相同key的并发加载只执行一次
+	flightGroup struct {
+		mu    sync.Mutex
+		calls map[string]*call
+	}
+)
+
+var errLoadPanicked = errors.New("group: load panicked")
+
+//do 执行fn，若key已有进行中的加载则等待其结果；shared表示结果来自其他调用
+func (f *flightGroup) do(key string, fn func() ([]byte, error)) (value []byte, err error, shared bool) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]*call{}
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		c.wg.Wait()
+		return c.value, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	returned := false
+	defer func() {
+		// fn panic时也要唤醒等待者，并让其得到错误而不是空结果
+		if !returned {
+			c.value, c.err = nil, errLoadPanicked
+		}
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.value, c.err = fn()
+	returned = true
+	return c.value, c.err, false
+}
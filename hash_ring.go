@@ -0,0 +1,110 @@
+package gomap
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type (
+	// HashRing 带虚拟节点的一致性哈希环，增删节点时只有相邻区间的key改变归属
+	HashRing struct {
+		mu       sync.RWMutex
+		replicas int                 // 每个节点的虚拟节点数
+		hash     func([]byte) uint32 // 哈希函数
+		points   []uint32            // 排序后的虚拟节点哈希
+		owners   map[uint32]string   // 虚拟节点哈希到节点
+		nodes    map[string]struct{} // 节点
+	}
+)
+
+const defaultReplicas = 100
+
+//NewHashRing replicas为每个节点的虚拟节点数，<=0时为100；hash为空时使用crc32
+func NewHashRing(replicas int, hash func([]byte) uint32) *HashRing {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &HashRing{
+		replicas: replicas,
+		hash:     hash,
+		owners:   map[uint32]string{},
+		nodes:    map[string]struct{}{},
+	}
+}
+
+//Add 加入节点，已存在的节点忽略
+func (r *HashRing) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := r.nodes[node]; ok {
+			continue
+		}
+		r.nodes[node] = struct{}{}
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + node))
+			if _, ok := r.owners[h]; ok {
+				// 哈希冲突时保留原有归属
+				continue
+			}
+			r.owners[h] = node
+			r.points = append(r.points, h)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+}
+
+//Remove 移除节点
+func (r *HashRing) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+	points := r.points[:0]
+	for _, h := range r.points {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+		} else {
+			points = append(points, h)
+		}
+	}
+	r.points = points
+}
+
+//Get key所属的节点，环为空时返回空字符串
+func (r *HashRing) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+//Nodes 所有节点，按字典序排列
+func (r *HashRing) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
@@ -0,0 +1,167 @@
+package gomap
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// Lease 租约
+	Lease struct {
+		Owner     string        // 持有者
+		Token     uint64        // 防护令牌，每次被获得时单调递增，存储层可据此拒绝旧持有者的写入
+		TTL       time.Duration // 租期，续租时按该值延长
+		ExpiresAt time.Time     // 到期时刻
+	}
+
+	// LeaseConfig Leases配置
+	LeaseConfig struct {
+		GCInterval time.Duration                 // 过期检查周期，默认100ms
+		OnLost     func(key string, lease Lease) // 租约过期失去时回调，在单独的协程中依次调用
+	}
+
+	// Leases 基于TTLMap的租约管理，所有操作在map的锁内完成。
+	// 租约到期即失去，到期后Renew、Release返回ErrLeaseLost，其他持有者可以获得
+	Leases struct {
+		m      *TTLMap
+		fence  uint64 // 最近发放的令牌，由m.mu保护
+		cancel context.CancelFunc
+	}
+)
+
+var (
+	ErrLeaseHeld       = errors.New("ErrLeaseHeld")
+	ErrLeaseLost       = errors.New("ErrLeaseLost")
+	ErrLeaseInvalidTTL = errors.New("ErrLeaseInvalidTTL")
+)
+
+func NewLeases(cfg LeaseConfig) *Leases {
+	if cfg.GCInterval <= 0 {
+		cfg.GCInterval = 100 * time.Millisecond
+	}
+	l := &Leases{m: NewTTLMap(NoExpiration, cfg.GCInterval, false)}
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	if cfg.OnLost != nil {
+		// 通知在订阅者的队列中排队，由单独的协程依次回调，回调中可以再操作租约而不会阻塞写入方
+		events := l.m.Watch(ctx, WatchFilter{Ops: []Op{OpExpire}}, WatchOverflow(overflowGrow))
+		go func() {
+			for ev := range events {
+				cfg.OnLost(ev.Key, ev.Old.(Lease))
+			}
+		}()
+	}
+	return l
+}
+
+//Acquire 获得租约并返回防护令牌；已被其他持有者持有时返回ErrLeaseHeld。
+// 持有者重复获得时按新的ttl续租，令牌不变
+func (l *Leases) Acquire(key, owner string, ttl time.Duration) (token uint64, err error) {
+	if ttl <= 0 {
+		return 0, ErrLeaseInvalidTTL
+	}
+	m := l.m
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if lease, ok := l.current(key); ok {
+		if lease.Owner != owner {
+			return 0, ErrLeaseHeld
+		}
+		lease.TTL = ttl
+		l.extend(key, lease)
+		return lease.Token, nil
+	}
+	l.fence++
+	lease := Lease{Owner: owner, Token: l.fence, TTL: ttl, ExpiresAt: time.Now().Add(ttl)}
+	expiration := lease.ExpiresAt.UnixNano()
+	m.schedule(expiration)
+	m.storeAt(key, lease, expiration)
+	return lease.Token, nil
+}
+
+//Renew 按租期续租，租约已失去或令牌不匹配时返回ErrLeaseLost
+func (l *Leases) Renew(key string, token uint64) error {
+	m := l.m
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	lease, ok := l.current(key)
+	if !ok || lease.Token != token {
+		return ErrLeaseLost
+	}
+	l.extend(key, lease)
+	return nil
+}
+
+//Release 释放租约，租约已失去或令牌不匹配时返回ErrLeaseLost
+func (l *Leases) Release(key string, token uint64) error {
+	m := l.m
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	lease, ok := l.current(key)
+	if !ok || lease.Token != token {
+		return ErrLeaseLost
+	}
+	m.remove(key)
+	m.watchers.notify(Event{Op: OpDelete, Key: key, Old: lease})
+	return nil
+}
+
+//Get 当前租约
+func (l *Leases) Get(key string) (lease Lease, ok bool) {
+	m := l.m
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return Lease{}, false
+	}
+	return item.Value.(Lease), true
+}
+
+//current 调用方持有写锁，返回未到期的租约；已到期但未清理的租约立即删除并通知失去
+func (l *Leases) current(key string) (Lease, bool) {
+	item, ok := l.m.entryMap[key]
+	if !ok {
+		return Lease{}, false
+	}
+	if item.expired() {
+		l.m.remove(key)
+		l.m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		return Lease{}, false
+	}
+	return item.Value.(Lease), true
+}
+
+//extend 调用方持有写锁，将租约延长至当前时刻之后TTL
+func (l *Leases) extend(key string, lease Lease) {
+	item := l.m.entryMap[key]
+	old := item.Value
+	lease.ExpiresAt = time.Now().Add(lease.TTL)
+	item.Value = lease
+	item.expiration = lease.ExpiresAt.UnixNano()
+	l.m.entryMap[key] = item
+	l.m.schedule(item.expiration)
+	l.m.watchers.notify(Event{Op: OpRenew, Key: key, Old: old, New: lease, ExpireAt: lease.ExpiresAt})
+}
+
+//Destroy 销毁租约管理，之后不再回调OnLost
+func (l *Leases) Destroy() {
+	l.cancel()
+	l.m.Destroy()
+}
@@ -0,0 +1,127 @@
+package gomap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLeases_AcquireRelease(t *testing.T) {
+	l := NewLeases(LeaseConfig{})
+	defer l.Destroy()
+	t1, err := l.Acquire("shard-7", "x", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Acquire("shard-7", "y", time.Minute); err != ErrLeaseHeld {
+		t.Fatal("lease should be held", err)
+	}
+	// 持有者重复获得时令牌不变
+	if token, err := l.Acquire("shard-7", "x", time.Minute); err != nil || token != t1 {
+		t.Fatal("reacquire should keep token")
+	}
+	if err = l.Release("shard-7", t1+1); err != ErrLeaseLost {
+		t.Fatal("wrong token released", err)
+	}
+	if err = l.Release("shard-7", t1); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Renew("shard-7", t1); err != ErrLeaseLost {
+		t.Fatal("released lease renewed", err)
+	}
+	t2, err := l.Acquire("shard-7", "y", time.Minute)
+	if err != nil || t2 <= t1 {
+		t.Fatal("fencing token should increase", t1, t2, err)
+	}
+	if lease, ok := l.Get("shard-7"); !ok || lease.Owner != "y" || lease.Token != t2 {
+		t.Fatal("Get", lease)
+	}
+	if _, err = l.Acquire("k", "x", 0); err != ErrLeaseInvalidTTL {
+		t.Fatal("invalid ttl", err)
+	}
+}
+
+func TestLeases_Expire(t *testing.T) {
+	lost := make(chan Lease, 10)
+	l := NewLeases(LeaseConfig{
+		GCInterval: 5 * time.Millisecond,
+		OnLost: func(key string, lease Lease) {
+			lost <- lease
+		},
+	})
+	defer l.Destroy()
+	token, _ := l.Acquire("a", "x", 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if err := l.Renew("a", token); err != nil {
+			t.Fatal("Renew", err)
+		}
+	}
+	select {
+	case lease := <-lost:
+		t.Fatal("renewed lease lost", lease)
+	default:
+	}
+	select {
+	case lease := <-lost:
+		if lease.Owner != "x" || lease.Token != token {
+			t.Fatal("unexpected lost lease", lease)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected lost notification")
+	}
+	if err := l.Renew("a", token); err != ErrLeaseLost {
+		t.Fatal("expired lease renewed", err)
+	}
+
+	// 到期但未清理的租约在被其他持有者获得时通知失去
+	l2 := NewLeases(LeaseConfig{
+		GCInterval: time.Hour,
+		OnLost: func(key string, lease Lease) {
+			lost <- lease
+		},
+	})
+	defer l2.Destroy()
+	t1, _ := l2.Acquire("a", "x", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	t2, err := l2.Acquire("a", "y", time.Minute)
+	if err != nil || t2 <= t1 {
+		t.Fatal("Acquire expired lease", err)
+	}
+	select {
+	case lease := <-lost:
+		if lease.Token != t1 {
+			t.Fatal("unexpected lost lease", lease)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected lost notification")
+	}
+}
+
+func TestLeases_OnLostReentrant(t *testing.T) {
+	const n = 2000
+	var l *Leases
+	lost := make(chan string, n)
+	l = NewLeases(LeaseConfig{
+		GCInterval: 5 * time.Millisecond,
+		OnLost: func(key string, lease Lease) {
+			// 回调中操作租约，大量通知积压时不应与清理协程互相等待
+			l.Acquire(key, "y", time.Minute)
+			lost <- key
+		},
+	})
+	defer l.Destroy()
+	for i := 0; i < n; i++ {
+		l.Acquire(strconv.Itoa(i), "x", time.Millisecond)
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case <-lost:
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnLost blocked", i)
+		}
+	}
+	if lease, ok := l.Get("0"); !ok || lease.Owner != "y" {
+		t.Fatal("lease not acquired in OnLost", lease)
+	}
+}
@@ -1,6 +1,7 @@
 package gomap
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
@@ -11,10 +12,17 @@ type (
 		mu       sync.RWMutex            // 锁
 		head     *linkedEntry            // 头节点
 		tail     *linkedEntry            // 尾节点
+		watchers watchers                // 订阅者
+		capacity int64                   // 容量，<=0时不限制
+		weigher  Weigher                 // 权重计算，为空时每项权重为1
+		weight   int64                   // 当前总权重
+		onEvict  func(e Entry)           // 淘汰回调
+		cond     *sync.Cond              // 写入通知，唤醒阻塞读取
 	}
 
 	linkedEntry struct {
 		Entry               // 对象
+		weight int64        // 权重
 		before *linkedEntry // 前一节点
 		after  *linkedEntry // 后一节点
 	}
@@ -26,11 +34,63 @@ func NewLinkedMap() *LinkedMap {
 		head:     nil,
 		mu:       sync.RWMutex{},
 	}
+	c.cond = sync.NewCond(&c.mu)
 	return c
 }
 
+//NewBoundedLinkedMap 创建有容量限制的LinkedMap，写入后总权重超过capacity时从头节点开始淘汰。
+// weigher为空时每项权重为1，即capacity为最大数量；onEvict在持有锁时调用，不能再访问该map。
+func NewBoundedLinkedMap(capacity int64, weigher Weigher, onEvict func(e Entry)) *LinkedMap {
+	c := NewLinkedMap()
+	c.capacity = capacity
+	c.weigher = weigher
+	c.onEvict = onEvict
+	return c
+}
+
+func (m *LinkedMap) weigh(key string, value interface{}) int64 {
+	if m.capacity <= 0 {
+		return 0
+	}
+	if m.weigher == nil {
+		return 1
+	}
+	return m.weigher(key, value)
+}
+
+//evict 从头节点开始淘汰，直到总权重不超过容量
+func (m *LinkedMap) evict() {
+	for m.capacity > 0 && m.weight > m.capacity && m.head != nil {
+		item := m.head
+		m.remove(item)
+		m.watchers.notify(Event{Op: OpEvict, Key: item.Key, Old: item.Value})
+		if m.onEvict != nil {
+			m.onEvict(item.Entry)
+		}
+	}
+}
+
+//remove 从map与链表中移除节点
+func (m *LinkedMap) remove(item *linkedEntry) {
+	delete(m.entryMap, item.Key)
+	m.weight -= item.weight
+	if item.after != nil {
+		item.after.before = item.before
+	} else {
+		m.tail = item.before
+	}
+	if item.before != nil {
+		item.before.after = item.after
+	} else {
+		m.head = item.after
+	}
+	item.before = nil
+	item.after = nil
+}
+
 func (m *LinkedMap) Store(key string, value interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -39,13 +99,17 @@ func (m *LinkedMap) Store(key string, value interface{}) {
 }
 
 func (m *LinkedMap) store(key string, value interface{}) {
+	weight := m.weigh(key, value)
 	entry, ok := m.entryMap[key]
 	if ok {
-		entry := &linkedEntry{
+		m.watchers.notify(Event{Op: OpStore, Key: key, Old: entry.Value, New: value})
+		m.weight -= entry.weight
+		entry = &linkedEntry{
 			Entry: Entry{
 				Key:   key,
 				Value: value,
 			},
+			weight: weight,
 			before: entry.before,
 			after:  entry.after,
 		}
@@ -60,11 +124,13 @@ func (m *LinkedMap) store(key string, value interface{}) {
 			m.tail = entry
 		}
 	} else {
+		m.watchers.notify(Event{Op: OpStore, Key: key, New: value})
 		entry = &linkedEntry{
 			Entry: Entry{
 				Key:   key,
 				Value: value,
 			},
+			weight: weight,
 			before: m.tail,
 			after:  nil,
 		}
@@ -76,6 +142,9 @@ func (m *LinkedMap) store(key string, value interface{}) {
 		m.tail = entry
 	}
 	m.entryMap[key] = entry
+	m.weight += weight
+	m.evict()
+	m.cond.Broadcast()
 }
 
 func (m *LinkedMap) Load(key string) (value interface{}, ok bool) {
@@ -91,8 +160,69 @@ func (m *LinkedMap) Load(key string) (value interface{}, ok bool) {
 	return nil, false
 }
 
+//WaitLoad 读取key，不存在时阻塞直到key被写入或ctx结束，ctx结束时返回ctx.Err()，map被销毁时返回ErrDestroyed
+func (m *LinkedMap) WaitLoad(ctx context.Context, key string) (value interface{}, err error) {
+	m.mu.Lock()
+	defer wakeOnDone(ctx, &m.mu, m.cond)()
+	defer m.mu.Unlock()
+	for {
+		if m.entryMap == nil {
+			return nil, ErrDestroyed
+		}
+		if item, ok := m.entryMap[key]; ok {
+			return item.Value, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		m.cond.Wait()
+	}
+}
+
+//PollFirst 移除并返回头节点，map为空时ok为false
+func (m *LinkedMap) PollFirst() (entry Entry, ok bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.pollFirst()
+}
+
+//PollFirstWait 移除并返回头节点，map为空时阻塞直到有数据写入或ctx结束，ctx结束时返回ctx.Err()，map被销毁时返回ErrDestroyed
+func (m *LinkedMap) PollFirstWait(ctx context.Context) (entry Entry, err error) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer wakeOnDone(ctx, &m.mu, m.cond)()
+	defer m.mu.Unlock()
+	for {
+		if m.entryMap == nil {
+			return Entry{}, ErrDestroyed
+		}
+		if entry, ok := m.pollFirst(); ok {
+			return entry, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return Entry{}, err
+		}
+		m.cond.Wait()
+	}
+}
+
+func (m *LinkedMap) pollFirst() (Entry, bool) {
+	item := m.head
+	if item == nil {
+		return Entry{}, false
+	}
+	m.watchers.notify(Event{Op: OpDelete, Key: item.Key, Old: item.Value})
+	m.remove(item)
+	return item.Entry, true
+}
+
 func (m *LinkedMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -106,16 +236,22 @@ func (m *LinkedMap) LoadOrStore(key string, value interface{}) (actual interface
 
 func (m *LinkedMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 
 	if item, ok := m.entryMap[key]; ok {
+		old := item.Value
 		if compare != nil {
 			item.Value = compare(item.Value, value)
 		}
-		m.entryMap[key] = item
+		weight := m.weigh(key, item.Value)
+		m.weight += weight - item.weight
+		item.weight = weight
+		m.watchers.notify(Event{Op: OpStore, Key: key, Old: old, New: item.Value})
+		m.evict()
 		return
 	}
 	// 存入值
@@ -124,24 +260,14 @@ func (m *LinkedMap) StoreOrCompare(key string, value interface{}, compare func(s
 
 func (m *LinkedMap) Delete(key string) interface{} {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 	if item, ok := m.entryMap[key]; ok {
-		delete(m.entryMap, item.Key)
-		if item.after != nil {
-			item.after.before = item.before
-			item.after = nil
-		} else {
-			m.tail = item.before
-		}
-		if item.before != nil {
-			item.before.after = item.after
-			item.before = nil
-		} else {
-			m.head = item.after
-		}
+		m.watchers.notify(Event{Op: OpDelete, Key: key, Old: item.Value})
+		m.remove(item)
 	}
 	return nil
 }
@@ -156,7 +282,10 @@ func (m *LinkedMap) Clear() []Entry {
 	m.entryMap = map[string]*linkedEntry{}
 	m.head = nil
 	m.tail = nil
+	m.weight = 0
+	m.watchers.notify(Event{Op: OpClear})
 	m.mu.Unlock()
+	m.watchers.wait()
 	var entries []Entry
 	for node != nil {
 		entries = append(entries, node.Entry)
@@ -185,13 +314,16 @@ func (m *LinkedMap) Range(f func(key interface{}, value interface{}) bool) {
 }
 
 func (m *LinkedMap) Destroy() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
-	m.Clear()
 	m.entryMap = nil
+	m.head = nil
+	m.tail = nil
+	m.watchers.closeAll()
+	m.cond.Broadcast()
 }
 
 func (m *LinkedMap) Size() int {
@@ -202,3 +334,27 @@ func (m *LinkedMap) Size() int {
 	}
 	return len(m.entryMap)
 }
+
+//MemoryUsage 估算map当前占用的内存字节数
+func (m *LinkedMap) MemoryUsage() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	size := int64(mapHeaderSize)
+	for node := m.head; node != nil; node = node.after {
+		size += linkedEntrySize + int64(len(node.Key)) + DefaultSizer.Sizeof(node.Value)
+	}
+	return size
+}
+
+//Watch 订阅变更事件，ctx结束或map销毁时关闭通道
+func (m *LinkedMap) Watch(ctx context.Context, filter WatchFilter, opts ...WatchOption) <-chan Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.watchers.watch(ctx, filter, opts)
+}
@@ -1,8 +1,11 @@
 package gomap
 
 import (
+	"context"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLinkedMap_Store(t *testing.T) {
@@ -44,6 +47,24 @@ func TestLinkedMap_StoreOrCompare(t *testing.T) {
 	})
 }
 
+func TestLinkedMap_StoreOverwrite(t *testing.T) {
+	m := NewLinkedMap()
+	m.Store("1", 1)
+	m.Store("2", 2)
+	m.Store("1", 3)
+	if v, _ := m.Load("1"); v != 3 {
+		t.Fatal("overwrite should replace value", v)
+	}
+	var values []interface{}
+	m.Range(func(key, value interface{}) bool {
+		values = append(values, value)
+		return true
+	})
+	if len(values) != 2 || values[0] != 3 || values[1] != 2 {
+		t.Fatal("overwrite should keep position", values)
+	}
+}
+
 func TestLinkedMap_Delete(t *testing.T) {
 	m := NewLinkedMap()
 	m.Store("1", 3)
@@ -83,3 +104,109 @@ func TestLinkedMap_Destroy(t *testing.T) {
 	}()
 	m.Load("1")
 }
+
+func TestLinkedMap_Bounded(t *testing.T) {
+	var evicted []string
+	m := NewBoundedLinkedMap(3, nil, func(e Entry) {
+		evicted = append(evicted, e.Key)
+	})
+	for i := 0; i < 5; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	// 覆盖已有key不改变位置也不触发淘汰
+	m.Store("3", 3)
+	if m.Size() != 3 || len(evicted) != 2 || evicted[0] != "0" || evicted[1] != "1" {
+		t.Fatal("evict from head", m.Size(), evicted)
+	}
+	m.Delete("2")
+	m.Store("5", 5)
+	if len(evicted) != 2 {
+		t.Fatal("Delete should release capacity", evicted)
+	}
+
+	w := NewBoundedLinkedMap(10, func(key string, value interface{}) int64 {
+		return int64(len(value.(string)))
+	}, nil)
+	w.Store("a", "12345")
+	w.Store("b", "12345")
+	w.StoreOrCompare("b", "", func(stored, input interface{}) interface{} {
+		return stored.(string) + "6"
+	})
+	if _, ok := w.Load("a"); ok {
+		t.Fatal("weight not updated by StoreOrCompare")
+	}
+}
+
+func TestLinkedMap_WaitLoad(t *testing.T) {
+	m := NewLinkedMap()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Store("1", 1)
+	}()
+	if v, err := m.WaitLoad(context.Background(), "1"); err != nil || v != 1 {
+		t.Fatal("WaitLoad", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.WaitLoad(ctx, "2"); err != context.DeadlineExceeded {
+		t.Fatal("WaitLoad should stop on ctx", err)
+	}
+}
+
+func TestLinkedMap_PollFirstWait(t *testing.T) {
+	m := NewLinkedMap()
+	m.Store("1", 1)
+	m.Store("2", 2)
+	if e, ok := m.PollFirst(); !ok || e.Key != "1" {
+		t.Fatal("PollFirst", e)
+	}
+	if e, err := m.PollFirstWait(context.Background()); err != nil || e.Key != "2" || m.Size() != 0 {
+		t.Fatal("PollFirstWait", e, err)
+	}
+	// 多个消费者各取得一个数据
+	results := make(chan string, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e, err := m.PollFirstWait(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results <- e.Key
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	wg.Wait()
+	close(results)
+	seen := map[string]bool{}
+	for k := range results {
+		seen[k] = true
+	}
+	if len(seen) != 10 || m.Size() != 0 {
+		t.Fatal("each entry should be polled once", seen)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := m.PollFirstWait(ctx); err != context.Canceled {
+		t.Fatal("PollFirstWait should stop on ctx", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Destroy()
+	}()
+	if _, err := m.PollFirstWait(context.Background()); err != ErrDestroyed {
+		t.Fatal("destroyed map should wake waiters", err)
+	}
+	if _, err := m.WaitLoad(context.Background(), "1"); err != ErrDestroyed {
+		t.Fatal("WaitLoad on destroyed map", err)
+	}
+}
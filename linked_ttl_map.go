@@ -1,7 +1,9 @@
 package gomap
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -16,6 +18,17 @@ type (
 		renewOnLoad bool                       // 读取时续租时间
 		head        *linkedTTLEntry            // 头节点
 		tail        *linkedTTLEntry
+		watchers    watchers          // 订阅者
+		refresher   *refresher        // 软过期刷新
+		gcRunning   bool              // 清理协程已启动
+		policy      ExpirationPolicy  // 默认过期策略
+		accessKeys  bool              // 存在单独使用SlidingOnAccess策略的数据
+		gcBatch     int               // 清理时每批最多删除的数量
+		gcConfig    *GCConfig         // 增量清理配置，nil时每次删除全部过期数据
+		gcStats     gcRecorder        // 增量清理统计
+		gcNext      *linkedTTLEntry   // 清理时下次检查的节点，nil时从头节点开始
+		gcSlots     []*linkedTTLEntry // 所有节点，供增量清理随机抽样
+		cond        *sync.Cond        // 写入通知，唤醒阻塞读取
 	}
 
 	linkedTTLEntry struct {
@@ -33,8 +46,12 @@ func NewLinkedTTLMap(expiration, gcInterval time.Duration, renewOnLoad bool) *Li
 		mu:          &sync.RWMutex{},
 		exit:        make(chan bool),
 		renewOnLoad: renewOnLoad,
+		policy:      policyFor(renewOnLoad),
+		gcBatch:     defaultGCBatch,
 	}
-	if expiration > 0 {
+	m.cond = sync.NewCond(m.mu)
+	if expiration > 0 || gcInterval > 0 {
+		m.gcRunning = true
 		go m.gcLoop()
 	}
 	return m
@@ -45,14 +62,14 @@ func (m *LinkedTTLMap) gcLoop() {
 	if m.gcInterval <= 0 {
 		m.gcInterval = 100 * time.Millisecond
 	}
-	if m.expiration <= 0 {
-		return
-	}
 	ticker := time.NewTicker(m.gcInterval)
 	for {
 		select {
 		case <-ticker.C:
-			m.DeleteExpired()
+			if !m.gc() {
+				ticker.Stop()
+				return
+			}
 		case <-m.exit:
 			ticker.Stop()
 			return
@@ -60,39 +77,180 @@ func (m *LinkedTTLMap) gcLoop() {
 	}
 }
 
-//DeleteExpired 删除过期数据项
-func (m *LinkedTTLMap) DeleteExpired() []Entry {
+//gc 执行一次清理，设置了增量清理时按抽样清理，否则删除全部过期数据；map已销毁时返回false
+func (m *LinkedTTLMap) gc() bool {
+	m.mu.RLock()
+	cfg := m.gcConfig
+	m.mu.RUnlock()
+	if cfg == nil {
+		_, ok := m.deleteExpired()
+		return ok
+	}
+	p, ok := cfg.pass(m.gcRound)
+	if ok {
+		m.gcStats.record(p)
+	}
+	return ok
+}
+
+//gcRound 持锁随机抽取最多n个数据检查，删除其中过期的
+func (m *LinkedTTLMap) gcRound(n int) (examined, expired int, ok bool) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
+		return 0, 0, false
+	}
+	now := time.Now().UnixNano()
+	for ; examined < n && len(m.gcSlots) > 0; examined++ {
+		item := m.gcSlots[rand.Intn(len(m.gcSlots))]
+		if item.expiration > 0 && now > item.expiration {
+			expired++
+			m.delete(item)
+			m.watchers.notify(Event{Op: OpExpire, Key: item.Key, Old: item.Value})
+		}
+	}
+	return examined, expired, true
+}
+
+//SetGCConfig 使后台清理改为增量抽样清理，每轮持锁时间有界
+func (m *LinkedTTLMap) SetGCConfig(cfg GCConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	cfg = cfg.withDefaults()
+	m.gcConfig = &cfg
+}
+
+//GCStats 增量清理的统计
+func (m *LinkedTTLMap) GCStats() GCStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.gcStats.get()
+}
+
+//schedule 数据设置了过期时间而清理协程尚未启动时启动清理，需持有写锁
+func (m *LinkedTTLMap) schedule(expiration int64) {
+	if expiration > 0 && !m.gcRunning {
+		m.gcRunning = true
+		go m.gcLoop()
+	}
+}
+
+//DeleteExpired 删除过期数据项
+func (m *LinkedTTLMap) DeleteExpired() []Entry {
+	deleted, ok := m.deleteExpired()
+	if !ok {
 		panic(errors.New(ErrMapDestroyed))
 	}
+	return deleted
+}
+
+//deleteExpired 从头节点开始分批持写锁检查，每批最多gcBatch个key，批间释放锁并从游标处继续；map已销毁时返回false，供gc协程使用以免与Destroy竞争
+func (m *LinkedTTLMap) deleteExpired() ([]Entry, bool) {
 	var entries []Entry
-	for _, v := range m.entryMap {
-		if v.expired() {
-			m.delete(v)
-			entries = append(entries, v.Entry)
+	for restart := true; ; restart = false {
+		done, ok := m.deleteExpiredBatch(restart, &entries)
+		if !ok || done {
+			return entries, ok
 		}
 	}
-	return entries
+}
+
+//deleteExpiredBatch 从游标处检查最多gcBatch个key并删除其中过期的，restart时从头节点开始；检查到尾节点时done为true
+func (m *LinkedTTLMap) deleteExpiredBatch(restart bool, entries *[]Entry) (done, ok bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return false, false
+	}
+	if restart {
+		m.gcNext = m.head
+	}
+	now := time.Now().UnixNano()
+	for n := 0; m.gcNext != nil; n++ {
+		if m.gcBatch > 0 && n == m.gcBatch {
+			return false, true
+		}
+		item := m.gcNext
+		if m.sweep(now) {
+			*entries = append(*entries, item.Entry)
+		}
+	}
+	return true, true
+}
+
+//sweep 需持有写锁，检查游标处的节点并将游标移到下一节点，已过期时删除并返回true
+func (m *LinkedTTLMap) sweep(now int64) bool {
+	item := m.gcNext
+	m.gcNext = item.after
+	if item.expiration > 0 && now > item.expiration {
+		m.delete(item)
+		m.watchers.notify(Event{Op: OpExpire, Key: item.Key, Old: item.Value})
+		return true
+	}
+	return false
+}
+
+//SetGCBatch 设置清理过期数据时每批持写锁检查的数量，每批之间释放锁并从停下的位置继续，<=0时一次检查全部，默认1000
+func (m *LinkedTTLMap) SetGCBatch(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	m.gcBatch = size
 }
 
 func (m *LinkedTTLMap) store(key string, value interface{}) {
-	var expiration int64
-	if m.expiration > 0 {
-		expiration = time.Now().Add(m.expiration).UnixNano()
-	} else {
-		expiration = -1
+	m.storeWith(key, value, nil)
+}
+
+//storeWith 按过期策略以默认过期时间写入，policy为nil时使用map的策略
+func (m *LinkedTTLMap) storeWith(key string, value interface{}, policy *ExpirationPolicy) {
+	p := policy
+	if p == nil {
+		p = &m.policy
 	}
+	var old *ttlEntry
+	if item, ok := m.entryMap[key]; ok && !item.expired() {
+		old = item.ttlEntry
+	}
+	expiration := p.writeExpiration(old, m.expiration)
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+	m.entryMap[key].policy = policy
+}
+
+//storeAt 以指定过期时间写入，保留未过期数据的首次写入时间与过期策略
+func (m *LinkedTTLMap) storeAt(key string, value interface{}, expiration int64) {
+	m.refresher.written(key)
 	entry, ok := m.entryMap[key]
+	ev := Event{Op: OpStore, Key: key, New: value}
+	created, policy := time.Now().UnixNano(), (*ExpirationPolicy)(nil)
+	if ok && !entry.expired() {
+		ev.Old = entry.Value
+		created, policy = entry.created, entry.policy
+	}
 	if ok {
-		entry := &linkedTTLEntry{
+		replaced := entry
+		entry = &linkedTTLEntry{
 			ttlEntry: &ttlEntry{
 				Entry: Entry{
 					Key:   key,
 					Value: value,
 				},
 				expiration: expiration,
+				staleAt:    m.refresher.staleAt(),
+				created:    created,
+				policy:     policy,
+				slot:       entry.slot,
 			},
 			before: entry.before,
 			after:  entry.after,
@@ -107,6 +265,10 @@ func (m *LinkedTTLMap) store(key string, value interface{}) {
 		} else {
 			m.tail = entry
 		}
+		if m.gcNext == replaced {
+			m.gcNext = entry
+		}
+		m.gcSlots[entry.slot] = entry
 	} else {
 		entry = &linkedTTLEntry{
 			ttlEntry: &ttlEntry{
@@ -115,6 +277,10 @@ func (m *LinkedTTLMap) store(key string, value interface{}) {
 					Value: value,
 				},
 				expiration: expiration,
+				staleAt:    m.refresher.staleAt(),
+				created:    created,
+				policy:     policy,
+				slot:       len(m.gcSlots),
 			},
 			before: m.tail,
 			after:  nil,
@@ -125,12 +291,17 @@ func (m *LinkedTTLMap) store(key string, value interface{}) {
 			m.tail.after = entry
 		}
 		m.tail = entry
+		m.gcSlots = append(m.gcSlots, entry)
 	}
 	m.entryMap[key] = entry
+	ev.ExpireAt = entry.deadline()
+	m.watchers.notify(ev)
+	m.cond.Broadcast()
 }
 
 func (m *LinkedTTLMap) Store(key string, value interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -138,48 +309,207 @@ func (m *LinkedTTLMap) Store(key string, value interface{}) {
 	m.store(key, value)
 }
 
-func (m *LinkedTTLMap) Load(key string) (value interface{}, ok bool) {
+//lockLoad 读取时加锁，可能续租时加写锁；返回的函数解锁，加写锁时还会等待订阅者消费续租事件
+func (m *LinkedTTLMap) lockLoad() func() {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	if !m.renewOnLoad {
+		return m.mu.RUnlock
+	}
+	m.mu.RUnlock()
+	m.mu.Lock()
+	return func() {
+		m.mu.Unlock()
+		m.watchers.wait()
+	}
+}
+
+func (m *LinkedTTLMap) Load(key string) (value interface{}, ok bool) {
+	defer m.lockLoad()()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
+	return m.load(key)
+}
+
+//load 读取未过期数据并按策略续租，调用方持有锁，续租时需持有写锁
+func (m *LinkedTTLMap) load(key string) (value interface{}, ok bool) {
 	item, ok := m.entryMap[key]
 	if ok {
 		if !item.expired() {
+			m.refresher.check(key, item.ttlEntry, m.refreshed)
 			if m.renewOnLoad {
-				item.renew(m.expiration)
+				if m.renewOnAccess(item.ttlEntry) {
+					m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+				}
 			}
 			return item.Value, true
-		} else {
-			m.delete(item)
 		}
+		// 过期数据由DeleteExpired清理
 	}
 	return nil, false
 }
 
+//policyOf 数据生效的过期策略
+func (m *LinkedTTLMap) policyOf(item *ttlEntry) *ExpirationPolicy {
+	if item.policy != nil {
+		return item.policy
+	}
+	return &m.policy
+}
+
+//renewOnAccess 读取时按SlidingOnAccess策略续租，返回是否更新了过期时间
+func (m *LinkedTTLMap) renewOnAccess(item *ttlEntry) bool {
+	p := m.policyOf(item)
+	return p.Mode == ExpireSlidingOnAccess && item.renew(p, m.expiration)
+}
+
+//SetExpirationPolicy 设置默认过期策略，对之后的写入与读取生效，单独指定策略的数据不受影响；Mode为ExpireDefault时保留当前计时方式
+func (m *LinkedTTLMap) SetExpirationPolicy(p ExpirationPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	p = p.inherit(m.policy.Mode)
+	m.policy = p
+	m.renewOnLoad = p.Mode == ExpireSlidingOnAccess || m.accessKeys
+	m.schedule(p.expiration(m.expiration))
+}
+
+//StoreWithPolicy 存储key-val并单独指定过期策略，之后不指定策略的写入恢复使用map的策略；Mode为ExpireDefault时使用map当前的计时方式
+func (m *LinkedTTLMap) StoreWithPolicy(key string, value interface{}, p ExpirationPolicy) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	p = p.inherit(m.policy.Mode)
+	if p.Mode == ExpireSlidingOnAccess {
+		m.accessKeys = true
+		m.renewOnLoad = true
+	}
+	m.storeWith(key, value, &p)
+}
+
+//SetRefresh 设置软过期与提前刷新，只对之后写入的数据计算软过期时间，刷新结果按默认过期时间写入
+func (m *LinkedTTLMap) SetRefresh(cfg RefreshConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	m.refresher = newRefresher(cfg)
+}
+
+//refreshed 写入刷新结果，key已被删除、过期、刷新期间被写入或map已销毁时丢弃
+func (m *LinkedTTLMap) refreshed(key string, value interface{}, fresh func() bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return
+	}
+	if item, ok := m.entryMap[key]; ok && !item.expired() && fresh() {
+		m.store(key, value)
+	}
+}
+
 func (m *LinkedTTLMap) delete(item *linkedTTLEntry) interface{} {
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 	delete(m.entryMap, item.Key)
-	if item.after != nil {
-		item.after.before = item.before
-		item.after = nil
+	before, after := item.before, item.after
+	if m.gcNext == item {
+		m.gcNext = after
+	}
+	last := len(m.gcSlots) - 1
+	m.gcSlots[item.slot] = m.gcSlots[last]
+	m.gcSlots[item.slot].slot = item.slot
+	m.gcSlots[last] = nil
+	m.gcSlots = m.gcSlots[:last]
+	if after != nil {
+		after.before = before
 	} else {
-		m.tail = item.before
+		m.tail = before
 	}
-	if item.before != nil {
-		item.before.after = item.after
-		item.before = nil
+	if before != nil {
+		before.after = after
 	} else {
-		m.head = item.after
+		m.head = after
 	}
+	item.before, item.after = nil, nil
 	return item.Value
 }
 
+//WaitLoad 读取key，不存在或已过期时阻塞直到key被写入或ctx结束，ctx结束时返回ctx.Err()，map被销毁时返回ErrDestroyed
+func (m *LinkedTTLMap) WaitLoad(ctx context.Context, key string) (value interface{}, err error) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer wakeOnDone(ctx, m.mu, m.cond)()
+	defer m.mu.Unlock()
+	for {
+		if m.entryMap == nil {
+			return nil, ErrDestroyed
+		}
+		if value, ok := m.load(key); ok {
+			return value, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		m.cond.Wait()
+	}
+}
+
+//PollFirst 移除并返回第一个未过期的数据，之前的过期数据一并删除，没有未过期数据时ok为false
+func (m *LinkedTTLMap) PollFirst() (entry Entry, ok bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.pollFirst()
+}
+
+//PollFirstWait 移除并返回第一个未过期的数据，没有时阻塞直到有数据写入或ctx结束，ctx结束时返回ctx.Err()，map被销毁时返回ErrDestroyed
+func (m *LinkedTTLMap) PollFirstWait(ctx context.Context) (entry Entry, err error) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer wakeOnDone(ctx, m.mu, m.cond)()
+	defer m.mu.Unlock()
+	for {
+		if m.entryMap == nil {
+			return Entry{}, ErrDestroyed
+		}
+		if entry, ok := m.pollFirst(); ok {
+			return entry, nil
+		}
+		if err = ctx.Err(); err != nil {
+			return Entry{}, err
+		}
+		m.cond.Wait()
+	}
+}
+
+func (m *LinkedTTLMap) pollFirst() (Entry, bool) {
+	for item := m.head; item != nil; item = m.head {
+		m.delete(item)
+		if item.expired() {
+			m.watchers.notify(Event{Op: OpExpire, Key: item.Key, Old: item.Value})
+			continue
+		}
+		m.watchers.notify(Event{Op: OpDelete, Key: item.Key, Old: item.Value})
+		return item.Entry, true
+	}
+	return Entry{}, false
+}
+
 func (m *LinkedTTLMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -187,7 +517,9 @@ func (m *LinkedTTLMap) LoadOrStore(key string, value interface{}) (actual interf
 	if item, ok := m.entryMap[key]; ok {
 		if !item.expired() {
 			if m.renewOnLoad {
-				item.renew(m.expiration)
+				if m.renewOnAccess(item.ttlEntry) {
+					m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+				}
 			}
 			return item.Value, true
 		}
@@ -199,6 +531,7 @@ func (m *LinkedTTLMap) LoadOrStore(key string, value interface{}) (actual interf
 
 func (m *LinkedTTLMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -206,12 +539,9 @@ func (m *LinkedTTLMap) StoreOrCompare(key string, value interface{}, compare fun
 
 	if item, ok := m.entryMap[key]; ok {
 		if !item.expired() {
-			item.renew(m.expiration)
 			if compare != nil {
-				item.Value = compare(item.Value, value)
+				value = compare(item.Value, value)
 			}
-			m.entryMap[key] = item
-			return
 		}
 	}
 	// 存入值
@@ -220,11 +550,17 @@ func (m *LinkedTTLMap) StoreOrCompare(key string, value interface{}, compare fun
 
 func (m *LinkedTTLMap) Delete(key string) interface{} {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 	if item, ok := m.entryMap[key]; ok {
+		if item.expired() {
+			m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		} else {
+			m.watchers.notify(Event{Op: OpDelete, Key: key, Old: item.Value})
+		}
 		return m.delete(item)
 	}
 	return nil
@@ -240,7 +576,11 @@ func (m *LinkedTTLMap) Clear() []Entry {
 	m.entryMap = map[string]*linkedTTLEntry{}
 	m.head = nil
 	m.tail = nil
+	m.gcNext = nil
+	m.gcSlots = nil
+	m.watchers.notify(Event{Op: OpClear})
 	m.mu.Unlock()
+	m.watchers.wait()
 	var entries []Entry
 	for node != nil {
 		if !node.expired() {
@@ -255,32 +595,69 @@ func (m *LinkedTTLMap) Clear() []Entry {
 	return entries
 }
 
+//Range 按写入顺序遍历未过期数据。需要续租时先在读锁下复制数据，在锁外调用f，再为遍历到的key续租，f中可以读写该map
 func (m *LinkedTTLMap) Range(f func(key interface{}, value interface{}) bool) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
 	if m.entryMap == nil {
+		m.mu.RUnlock()
 		panic(errors.New(ErrMapDestroyed))
 	}
-	node := m.head
-	for node != nil {
-		if !node.expired() {
-			if !f(node.Key, node.Value) {
+	if !m.renewOnLoad {
+		defer m.mu.RUnlock()
+		for node := m.head; node != nil; node = node.after {
+			if !node.expired() && !f(node.Key, node.Value) {
 				break
 			}
 		}
-		node = node.after
+		return
+	}
+	entries := make([]Entry, 0, len(m.entryMap))
+	for node := m.head; node != nil; node = node.after {
+		if !node.expired() {
+			entries = append(entries, node.Entry)
+		}
+	}
+	m.mu.RUnlock()
+	visited := entries[:0]
+	for _, entry := range entries {
+		visited = append(visited, entry)
+		if !f(entry.Key, entry.Value) {
+			break
+		}
+	}
+	m.renewVisited(visited)
+}
+
+//renewVisited 为Range遍历到的数据续租，遍历期间被删除或过期的跳过
+func (m *LinkedTTLMap) renewVisited(entries []Entry) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return
+	}
+	for _, entry := range entries {
+		item, ok := m.entryMap[entry.Key]
+		if ok && !item.expired() && m.renewOnAccess(item.ttlEntry) {
+			m.watchers.notify(Event{Op: OpRenew, Key: entry.Key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+		}
 	}
 }
 
 func (m *LinkedTTLMap) Destroy() {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
-	m.Clear()
 	m.entryMap = nil
+	m.head = nil
+	m.tail = nil
+	m.gcNext = nil
+	m.gcSlots = nil
 	close(m.exit)
+	m.watchers.closeAll()
+	m.cond.Broadcast()
 }
 
 func (m *LinkedTTLMap) Size() int {
@@ -291,3 +668,231 @@ func (m *LinkedTTLMap) Size() int {
 	}
 	return len(m.entryMap)
 }
+
+//MemoryUsage 估算map当前占用的内存字节数
+func (m *LinkedTTLMap) MemoryUsage() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	size := int64(mapHeaderSize)
+	for node := m.head; node != nil; node = node.after {
+		size += linkedTTLEntrySize + int64(len(node.Key)) + DefaultSizer.Sizeof(node.Value)
+	}
+	return size
+}
+
+//StoreWithTTL 存储key-val并指定过期时间
+func (m *LinkedTTLMap) StoreWithTTL(key string, value interface{}, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if ttl == DefaultExpiration {
+		m.store(key, value)
+		return
+	}
+	expiration := expireAt(ttl, m.expiration)
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+}
+
+//StoreUntil 存储key-val并在t时刻过期，返回是否存入；t不晚于当前时间时删除key，t为零值时不做修改
+func (m *LinkedTTLMap) StoreUntil(key string, value interface{}, t time.Time) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if t.IsZero() {
+		return false
+	}
+	expiration := t.UnixNano()
+	if expiration <= time.Now().UnixNano() {
+		// 写入的数据立即过期，原有数据也不再可见
+		if item, ok := m.entryMap[key]; ok {
+			m.delete(item)
+			m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		}
+		return false
+	}
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+	return true
+}
+
+//Update 在锁内根据key当前值计算并存储新值，返回最终值以及是否存入；f返回ExpireNow时删除key，最终值为nil
+func (m *LinkedTTLMap) Update(key string, f UpdateFunc) (actual interface{}, stored bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	var current interface{}
+	item, loaded := m.entryMap[key]
+	if loaded && item.expired() {
+		loaded = false
+	}
+	if loaded {
+		current = item.Value
+	}
+	value, ttl, store := f(current, loaded)
+	if !store {
+		return current, false
+	}
+	if ttl == ExpireNow {
+		if loaded {
+			m.delete(item)
+			m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		}
+		return nil, true
+	}
+	if ttl == KeepTTL && loaded {
+		m.storeAt(key, value, item.expiration)
+	} else {
+		if ttl == KeepTTL || ttl == DefaultExpiration {
+			m.store(key, value)
+			return value, true
+		}
+		expiration := expireAt(ttl, m.expiration)
+		m.schedule(expiration)
+		m.storeAt(key, value, expiration)
+	}
+	return value, true
+}
+
+//TTL 返回key剩余存活时间，永不过期时返回NoExpiration，key不存在时ok为false
+func (m *LinkedTTLMap) TTL(key string) (ttl time.Duration, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return 0, false
+	}
+	if item.expiration <= 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(item.expiration - time.Now().UnixNano()), true
+}
+
+//Expire 重新设置key的过期时间，ttl<=0时key立即过期，key不存在时返回false
+func (m *LinkedTTLMap) Expire(key string, ttl time.Duration) bool {
+	return m.ExpireAt(key, time.Now().Add(ttl))
+}
+
+//ExpireAt 将key的过期时间设置为t，t不晚于当前时间时key立即过期，key不存在时返回false
+func (m *LinkedTTLMap) ExpireAt(key string, t time.Time) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return false
+	}
+	expiration := t.UnixNano()
+	if expiration <= time.Now().UnixNano() {
+		m.delete(item)
+		m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		return true
+	}
+	item.expiration = expiration
+	m.schedule(expiration)
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+	return true
+}
+
+//ExpiresAt 返回key的过期时间，永不过期时为零值，key不存在时ok为false
+func (m *LinkedTTLMap) ExpiresAt(key string) (t time.Time, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return time.Time{}, false
+	}
+	return item.deadline(), true
+}
+
+//Touch 按默认过期时间续租，效果与renewOnLoad时的读取相同；key不存在、永不过期或map无默认过期时间时返回false
+func (m *LinkedTTLMap) Touch(key string) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || !item.renew(m.policyOf(item.ttlEntry), m.expiration) {
+		return false
+	}
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+	return true
+}
+
+//Peek 读取key的值，不续租也不触发刷新
+func (m *LinkedTTLMap) Peek(key string) (value interface{}, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+//PeekRange 按写入顺序遍历未过期数据及其过期时刻，永不过期时为零值；不续租也不触发刷新，f中不能修改该map
+func (m *LinkedTTLMap) PeekRange(f func(key string, value interface{}, expiresAt time.Time) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	for node := m.head; node != nil; node = node.after {
+		if !node.expired() && !f(node.Key, node.Value, node.deadline()) {
+			break
+		}
+	}
+}
+
+//Persist 移除key的过期时间，key不存在或本就永不过期时返回false
+func (m *LinkedTTLMap) Persist(key string) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() || item.expiration <= 0 {
+		return false
+	}
+	item.expiration = -1
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value})
+	return true
+}
+
+//Watch 订阅变更事件，ctx结束或map销毁时关闭通道
+func (m *LinkedTTLMap) Watch(ctx context.Context, filter WatchFilter, opts ...WatchOption) <-chan Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.watchers.watch(ctx, filter, opts)
+}
@@ -1,6 +1,7 @@
 package gomap
 
 import (
+	"context"
 	"strconv"
 	"testing"
 	"time"
@@ -82,6 +83,24 @@ func TestLinkedTTLMap_StoreOrCompare(t *testing.T) {
 	})
 }
 
+func TestLinkedTTLMap_StoreOverwrite(t *testing.T) {
+	m := NewLinkedTTLMap(-1, -1, false)
+	m.Store("1", 1)
+	m.Store("2", 2)
+	m.Store("1", 3)
+	if v, _ := m.Load("1"); v != 3 {
+		t.Fatal("overwrite should replace value", v)
+	}
+	var values []interface{}
+	m.Range(func(key, value interface{}) bool {
+		values = append(values, value)
+		return true
+	})
+	if len(values) != 2 || values[0] != 3 || values[1] != 2 {
+		t.Fatal("overwrite should keep position", values)
+	}
+}
+
 func TestLinkedTTLMap_Delete(t *testing.T) {
 	m := NewLinkedTTLMap(-1, -1, false)
 	for i := 0; i < 10; i++ {
@@ -113,6 +132,28 @@ func TestLinkedTTLMap_Range(t *testing.T) {
 	})
 }
 
+func TestLinkedTTLMap_RangeReentrant(t *testing.T) {
+	m := NewLinkedTTLMap(time.Minute, -1, true)
+	defer m.Destroy()
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	at, _ := m.ExpiresAt("0")
+	time.Sleep(time.Millisecond)
+	// renewOnLoad时f在锁外执行，可以读写该map
+	m.Range(func(key interface{}, value interface{}) bool {
+		m.Load(key.(string))
+		m.Store(key.(string)+"_copy", value)
+		return true
+	})
+	if m.Size() != 20 {
+		t.Fatal("size", m.Size())
+	}
+	if now, _ := m.ExpiresAt("0"); !now.After(at) {
+		t.Fatal("Range did not renew")
+	}
+}
+
 func TestLinkedTTLMap_Destroy(t *testing.T) {
 	m := NewLinkedTTLMap(time.Second, time.Second, false)
 	m.Destroy()
@@ -123,3 +164,156 @@ func TestLinkedTTLMap_Destroy(t *testing.T) {
 	}()
 	m.Load("1")
 }
+
+func TestLinkedTTLMap_StoreWithTTL(t *testing.T) {
+	m := NewLinkedTTLMap(-1, 100*time.Millisecond, false)
+	m.StoreWithTTL("1", 1, 200*time.Millisecond)
+	m.StoreWithTTL("2", 2, NoExpiration)
+	t.Log(m.TTL("1"))
+	t.Log(m.TTL("2"))
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := m.Load("1"); ok {
+		t.Fatal("key 1 should be expired")
+	}
+	t.Log(m.Size())
+}
+
+func TestLinkedTTLMap_Update(t *testing.T) {
+	m := NewLinkedTTLMap(-1, -1, false)
+	add := func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return 1, time.Second, !loaded
+	}
+	t.Log(m.Update("1", add))
+	t.Log(m.Update("1", add))
+	t.Log(m.Update("1", func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return stored.(int) + 1, KeepTTL, loaded
+	}))
+	if ttl, _ := m.TTL("1"); ttl <= 0 || ttl > time.Second {
+		t.Fatal("ttl should be kept", ttl)
+	}
+	m.Update("1", func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return nil, ExpireNow, true
+	})
+	if _, ok := m.Peek("1"); ok || m.Size() != 0 {
+		t.Fatal("ExpireNow should delete the key")
+	}
+}
+
+func TestLinkedTTLMap_ExpirePersist(t *testing.T) {
+	m := NewLinkedTTLMap(time.Second, time.Second, false)
+	m.Store("1", 1)
+	t.Log(m.Persist("1"))
+	t.Log(m.TTL("1"))
+	t.Log(m.Expire("1", time.Minute))
+	t.Log(m.TTL("1"))
+	t.Log(m.Expire("1", 0))
+	t.Log(m.Load("1"))
+}
+
+func TestLinkedTTLMap_TTLAPI(t *testing.T) {
+	m := NewLinkedTTLMap(time.Minute, -1, true)
+	defer m.Destroy()
+	m.Store("1", 1)
+	at, _ := m.ExpiresAt("1")
+	m.Peek("1")
+	if now, _ := m.ExpiresAt("1"); !now.Equal(at) {
+		t.Fatal("Peek renewed", at, now)
+	}
+	time.Sleep(time.Millisecond)
+	if !m.Touch("1") {
+		t.Fatal("Touch")
+	}
+	if now, _ := m.ExpiresAt("1"); !now.After(at) {
+		t.Fatal("Touch not renewed", at, now)
+	}
+	m.StoreWithTTL("2", 2, NoExpiration)
+	m.ExpireAt("2", time.Now().Add(10*time.Millisecond))
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := m.Peek("2"); ok || m.Size() != 1 {
+		t.Fatal("expired key not collected", m.Size())
+	}
+}
+
+func TestLinkedTTLMap_WaitLoad(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, DefaultExpiration, false)
+	defer m.Destroy()
+	m.StoreWithTTL("1", 0, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Store("1", 1)
+	}()
+	if v, err := m.WaitLoad(context.Background(), "1"); err != nil || v != 1 {
+		t.Fatal("WaitLoad should skip expired entry", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.WaitLoad(ctx, "2"); err != context.DeadlineExceeded {
+		t.Fatal("WaitLoad should stop on ctx", err)
+	}
+}
+
+func TestLinkedTTLMap_PollFirstWait(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, DefaultExpiration, false)
+	defer m.Destroy()
+	m.StoreWithTTL("1", 1, time.Millisecond)
+	m.Store("2", 2)
+	m.Store("3", 3)
+	time.Sleep(2 * time.Millisecond)
+	if e, ok := m.PollFirst(); !ok || e.Key != "2" || m.Size() != 1 {
+		t.Fatal("PollFirst should skip expired head", e)
+	}
+	if e, err := m.PollFirstWait(context.Background()); err != nil || e.Key != "3" {
+		t.Fatal("PollFirstWait", e, err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Store("4", 4)
+	}()
+	if e, err := m.PollFirstWait(context.Background()); err != nil || e.Key != "4" || m.Size() != 0 {
+		t.Fatal("PollFirstWait should wait for store", e, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.PollFirstWait(ctx); err != context.DeadlineExceeded {
+		t.Fatal("PollFirstWait should stop on ctx", err)
+	}
+}
+
+func TestLinkedTTLMap_WaitDestroyed(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, DefaultExpiration, false)
+	errs := make(chan error, 2)
+	go func() {
+		_, err := m.WaitLoad(context.Background(), "1")
+		errs <- err
+	}()
+	go func() {
+		_, err := m.PollFirstWait(context.Background())
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m.Destroy()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != ErrDestroyed {
+			t.Fatal("destroyed map should wake waiters", err)
+		}
+	}
+}
+
+func TestLinkedTTLMap_DeleteMiddle(t *testing.T) {
+	m := NewLinkedTTLMap(DefaultExpiration, DefaultExpiration, false)
+	defer m.Destroy()
+	for i := 0; i < 4; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	m.Delete("0")
+	m.Delete("2")
+	var keys []interface{}
+	m.Range(func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "1" || keys[1] != "3" {
+		t.Fatal("list broken after delete", keys)
+	}
+}
@@ -1,5 +1,10 @@
 package gomap
 
+import (
+	"context"
+	"time"
+)
+
 type (
 	Map interface {
 		Store(key string, value interface{})                                                                           // 存储key-val
@@ -12,6 +17,22 @@ type (
 		Destroy()                                                                                                      // 销毁
 		Size() int                                                                                                     // 大小
 	}
+	// ExpirableMap 支持按key设置过期时间的map，TTLMap与LinkedTTLMap均实现了该接口
+	ExpirableMap interface {
+		Map
+		StoreWithTTL(key string, value interface{}, ttl time.Duration)                   // 存储key-val并指定过期时间
+		StoreUntil(key string, value interface{}, t time.Time) bool                      // 存储key-val并在指定时刻过期
+		Update(key string, f UpdateFunc) (actual interface{}, stored bool)               // 在锁内计算并存储新值
+		TTL(key string) (ttl time.Duration, ok bool)                                     // 剩余存活时间
+		Expire(key string, ttl time.Duration) bool                                       // 重新设置过期时间
+		Persist(key string) bool                                                         // 移除过期时间
+		ExpireAt(key string, t time.Time) bool                                           // 将过期时间设置为指定时刻
+		ExpiresAt(key string) (t time.Time, ok bool)                                     // 过期时刻，永不过期时为零值
+		Touch(key string) bool                                                           // 按默认过期时间续租
+		Peek(key string) (value interface{}, ok bool)                                    // 读取但不续租
+		PeekRange(f func(key string, value interface{}, expiresAt time.Time) bool)       // 遍历数据及过期时刻但不续租
+		Watch(ctx context.Context, filter WatchFilter, opts ...WatchOption) <-chan Event // 订阅变更事件
+	}
 	Entry struct {
 		Key   string
 		Value interface{}
@@ -19,3 +40,14 @@ type (
 )
 
 const ErrMapDestroyed = "ErrMapDestroyed"
+
+var (
+	_ ExpirableMap = (*TTLMap)(nil)
+	_ ExpirableMap = (*LinkedTTLMap)(nil)
+	_ Map          = (*RaftMap)(nil)
+	_ Map          = (*CRDTMap)(nil)
+	_ Map          = (*Cluster)(nil)
+	_ Map          = (*TieredMap)(nil)
+	_ Map          = (*WriteBehindMap)(nil)
+	_ Map          = (*SpillMap)(nil)
+)
@@ -0,0 +1,367 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+const (
+	errLine      = "ERROR"
+	errBadFormat = "CLIENT_ERROR bad command line format"
+	errBadChunk  = "CLIENT_ERROR bad data chunk"
+	errTooLarge  = "SERVER_ERROR object too large for cache"
+	errNonNumber = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	errNumber    = "CLIENT_ERROR invalid numeric delta argument"
+)
+
+type client struct {
+	s  *Server
+	rd *bufio.Reader
+	wr *bufio.Writer
+}
+
+//handle 处理一条命令，返回quit表示客户端请求断开
+func (c *client) handle() (quit bool, err error) {
+	line, err := c.rd.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		c.reply("CLIENT_ERROR line too long")
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		c.reply(errLine)
+		return false, nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "get":
+		c.get(args, false)
+	case "gets":
+		c.get(args, true)
+	case "set", "add", "replace", "append", "prepend":
+		return false, c.store(fields[0], args, false)
+	case "cas":
+		return false, c.store(fields[0], args, true)
+	case "delete":
+		c.delete(args)
+	case "incr":
+		c.incr(args, true)
+	case "decr":
+		c.incr(args, false)
+	case "touch":
+		c.touch(args)
+	case "flush_all":
+		c.flushAll(args)
+	case "stats":
+		c.stats(args)
+	case "version":
+		c.reply("VERSION " + version)
+	case "verbosity":
+		c.replyUnless(noreply(args), "OK")
+	case "quit":
+		return true, nil
+	default:
+		c.reply(errLine)
+	}
+	return false, nil
+}
+
+func (c *client) reply(line string) {
+	c.wr.WriteString(line)
+	c.wr.WriteString("\r\n")
+}
+
+func (c *client) replyUnless(noreply bool, line string) {
+	if !noreply {
+		c.reply(line)
+	}
+}
+
+//noreply 判断最后一个参数是否为noreply
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *client) get(keys []string, withCAS bool) {
+	if len(keys) == 0 {
+		c.reply(errLine)
+		return
+	}
+	for _, key := range keys {
+		atomic.AddUint64(&c.s.stats.cmdGet, 1)
+		value, ok := c.s.m.Load(key)
+		if !ok {
+			atomic.AddUint64(&c.s.stats.getMisses, 1)
+			continue
+		}
+		atomic.AddUint64(&c.s.stats.getHits, 1)
+		it := toItem(value)
+		c.wr.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(it.flags), 10) + " " + strconv.Itoa(len(it.data)))
+		if withCAS {
+			c.wr.WriteString(" " + strconv.FormatUint(it.cas, 10))
+		}
+		c.wr.WriteString("\r\n")
+		c.wr.Write(it.data)
+		c.wr.WriteString("\r\n")
+	}
+	c.reply("END")
+}
+
+//store 处理set/add/replace/append/prepend/cas，数据块读取失败时返回错误断开连接
+func (c *client) store(cmd string, args []string, withCAS bool) error {
+	want := 4
+	if withCAS {
+		want = 5
+	}
+	quiet := len(args) == want+1 && args[want] == "noreply"
+	if len(args) != want && !quiet {
+		c.reply(errLine)
+		return nil
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(args[1], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[2], 10, 64)
+	size, err3 := strconv.Atoi(args[3])
+	var casUnique uint64
+	var err4 error
+	if withCAS {
+		casUnique, err4 = strconv.ParseUint(args[4], 10, 64)
+	}
+	if !validKey(key) || err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		c.reply(errBadFormat)
+		return nil
+	}
+	if size > maxValueLen {
+		// 丢弃数据块
+		if _, err := io.CopyN(ioutil.Discard, c.rd, int64(size)+2); err != nil {
+			return err
+		}
+		c.reply(errTooLarge)
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.rd, data); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		c.reply(errBadChunk)
+		return nil
+	}
+	data = data[:size]
+	atomic.AddUint64(&c.s.stats.cmdSet, 1)
+	ttl, expired := expiration(exptime)
+	if expired {
+		// 已过期的exptime使写入的数据立即过期，在Update内删除以免其他连接读到
+		ttl = gomap.ExpireNow
+	}
+	result := "STORED"
+	c.s.m.Update(key, func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		var old item
+		if loaded {
+			old = toItem(stored)
+		}
+		it := item{flags: uint32(flags), data: data}
+		switch cmd {
+		case "add":
+			if loaded {
+				result = "NOT_STORED"
+				return nil, 0, false
+			}
+		case "replace":
+			if !loaded {
+				result = "NOT_STORED"
+				return nil, 0, false
+			}
+		case "append", "prepend":
+			if !loaded {
+				result = "NOT_STORED"
+				return nil, 0, false
+			}
+			// append/prepend忽略flags与exptime
+			joined := make([]byte, 0, len(old.data)+len(data))
+			if cmd == "append" {
+				joined = append(append(joined, old.data...), data...)
+			} else {
+				joined = append(append(joined, data...), old.data...)
+			}
+			it = item{flags: old.flags, data: joined, cas: c.s.nextCAS()}
+			return it, gomap.KeepTTL, true
+		case "cas":
+			if !loaded {
+				atomic.AddUint64(&c.s.stats.casMisses, 1)
+				result = "NOT_FOUND"
+				return nil, 0, false
+			}
+			if old.cas != casUnique {
+				result = "EXISTS"
+				return nil, 0, false
+			}
+			atomic.AddUint64(&c.s.stats.casHits, 1)
+		}
+		it.cas = c.s.nextCAS()
+		return it, ttl, true
+	})
+	c.replyUnless(quiet, result)
+	return nil
+}
+
+func (c *client) delete(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 1 && !(len(args) == 2 && args[1] == "0") {
+		c.reply("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]")
+		return
+	}
+	if c.s.m.Delete(args[0]) != nil {
+		c.replyUnless(quiet, "DELETED")
+	} else {
+		c.replyUnless(quiet, "NOT_FOUND")
+	}
+}
+
+//incr 处理incr/decr，值按64位无符号整数处理，incr溢出回绕，decr最小为0
+func (c *client) incr(args []string, incr bool) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.reply(errLine)
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply(errNumber)
+		return
+	}
+	result := "NOT_FOUND"
+	c.s.m.Update(args[0], func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if !loaded {
+			return nil, 0, false
+		}
+		it := toItem(stored)
+		n, err := strconv.ParseUint(strings.TrimSpace(string(it.data)), 10, 64)
+		if err != nil {
+			result = errNonNumber
+			return nil, 0, false
+		}
+		if incr {
+			n += delta
+		} else if delta > n {
+			n = 0
+		} else {
+			n -= delta
+		}
+		result = strconv.FormatUint(n, 10)
+		return item{flags: it.flags, data: []byte(result), cas: c.s.nextCAS()}, gomap.KeepTTL, true
+	})
+	c.replyUnless(quiet, result)
+}
+
+func (c *client) touch(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.reply(errLine)
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+	atomic.AddUint64(&c.s.stats.cmdTouch, 1)
+	var touched bool
+	if ttl, expired := expiration(exptime); expired {
+		touched = c.s.m.Delete(args[0]) != nil
+	} else {
+		_, touched = c.s.m.Update(args[0], func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+			return stored, ttl, loaded
+		})
+	}
+	if touched {
+		c.replyUnless(quiet, "TOUCHED")
+	} else {
+		c.replyUnless(quiet, "NOT_FOUND")
+	}
+}
+
+//flushAll flush_all [delay] [noreply]，delay秒后清空
+func (c *client) flushAll(args []string) {
+	quiet := noreply(args)
+	if quiet {
+		args = args[:len(args)-1]
+	}
+	var delay int64
+	if len(args) > 0 {
+		var err error
+		if delay, err = strconv.ParseInt(args[0], 10, 64); err != nil || len(args) > 1 {
+			c.reply(errBadFormat)
+			return
+		}
+	}
+	if delay > 0 {
+		c.s.delayFlush(time.Duration(delay) * time.Second)
+	} else {
+		c.s.m.Clear()
+	}
+	c.replyUnless(quiet, "OK")
+}
+
+func (c *client) stats(args []string) {
+	if len(args) > 0 {
+		// 不支持的子统计项返回空
+		c.reply("END")
+		return
+	}
+	s := c.s
+	stat := func(name string, value interface{}) {
+		c.reply("STAT " + name + " " + fmt.Sprint(value))
+	}
+	now := time.Now()
+	stat("pid", os.Getpid())
+	stat("uptime", int64(now.Sub(s.started)/time.Second))
+	stat("time", now.Unix())
+	stat("version", version)
+	stat("curr_connections", atomic.LoadUint64(&s.stats.currConns))
+	stat("total_connections", atomic.LoadUint64(&s.stats.totalConns))
+	stat("curr_items", s.m.Size())
+	stat("bytes", s.m.MemoryUsage())
+	stat("cmd_get", atomic.LoadUint64(&s.stats.cmdGet))
+	stat("cmd_set", atomic.LoadUint64(&s.stats.cmdSet))
+	stat("cmd_touch", atomic.LoadUint64(&s.stats.cmdTouch))
+	stat("get_hits", atomic.LoadUint64(&s.stats.getHits))
+	stat("get_misses", atomic.LoadUint64(&s.stats.getMisses))
+	stat("cas_hits", atomic.LoadUint64(&s.stats.casHits))
+	stat("cas_misses", atomic.LoadUint64(&s.stats.casMisses))
+	c.reply("END")
+}
@@ -0,0 +1,233 @@
+//Package memcache 以memcached文本协议对外提供gomap.TTLMap的访问。
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+var ErrServerClosed = errors.New("memcache: server closed")
+
+const (
+	maxKeyLen   = 250               // key最大长度
+	maxValueLen = 1 << 20           // value最大长度
+	maxLineLen  = 2048              // 命令行最大长度
+	relativeMax = 60 * 60 * 24 * 30 // exptime不超过30天时按相对秒数处理，否则为unix时间戳
+	version     = "gomap-1.0"
+)
+
+type (
+	Server struct {
+		m         *gomap.TTLMap             // 数据
+		mu        sync.Mutex                // 锁
+		listeners map[net.Listener]struct{} // 监听器
+		conns     map[net.Conn]struct{}     // 活跃连接
+		closed    bool                      // 是否已关闭
+		wg        sync.WaitGroup            // 连接处理协程
+		casID     uint64                    // 全局递增的版本号
+		started   time.Time                 // 启动时间
+		stats     stats                     // 统计
+		flush     *time.Timer               // flush_all延迟清空的定时器
+	}
+
+	// item 存储在map中的值，cas为该值的版本号
+	item struct {
+		flags uint32
+		data  []byte
+		cas   uint64
+	}
+
+	stats struct {
+		cmdGet, getHits, getMisses uint64
+		cmdSet, cmdTouch           uint64
+		casHits, casMisses         uint64
+		currConns, totalConns      uint64
+	}
+)
+
+//NewServer 创建服务，m需设置gcInterval才能及时清理按key设置的过期数据
+func NewServer(m *gomap.TTLMap) *Server {
+	return &Server{
+		m:         m,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+		started:   time.Now(),
+	}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+//Serve 在ln上接受连接，直到ln出错或服务关闭
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+//Close 关闭所有监听器与连接，等待连接处理结束
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+//delayFlush delay后清空数据，新的延迟清空覆盖尚未执行的旧定时器
+func (s *Server) delayFlush(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.flush != nil {
+		s.flush.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		if s.flush != timer {
+			// 已被停止或覆盖
+			s.mu.Unlock()
+			return
+		}
+		s.flush = nil
+		s.mu.Unlock()
+		defer func() {
+			// map可能已被外部销毁
+			if err := recover(); err != nil && fmt.Sprint(err) != gomap.ErrMapDestroyed {
+				panic(err)
+			}
+		}()
+		s.m.Clear()
+	})
+	s.flush = timer
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.connOpened()
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.connClosed()
+		s.wg.Done()
+	}()
+	c := &client{
+		s:  s,
+		rd: bufio.NewReaderSize(conn, maxLineLen),
+		wr: bufio.NewWriter(conn),
+	}
+	for {
+		quit, err := c.handle()
+		if err != nil || quit {
+			c.wr.Flush()
+			return
+		}
+		if c.rd.Buffered() == 0 {
+			if err := c.wr.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//expiration 将memcached的exptime转换为ttl，expired表示写入即过期
+func expiration(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime == 0:
+		return gomap.NoExpiration, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= relativeMax:
+		return time.Duration(exptime) * time.Second, false
+	}
+	ttl = time.Until(time.Unix(exptime, 0))
+	return ttl, ttl <= 0
+}
+
+//toItem 兼容由Go代码直接写入map的非item值
+func toItem(value interface{}) item {
+	switch v := value.(type) {
+	case item:
+		return v
+	case []byte:
+		return item{data: v}
+	case string:
+		return item{data: []byte(v)}
+	}
+	return item{data: []byte(fmt.Sprint(value))}
+}
+
+func (s *Server) nextCAS() uint64 {
+	return atomic.AddUint64(&s.casID, 1)
+}
+
+func (s *Server) connOpened() {
+	atomic.AddUint64(&s.stats.currConns, 1)
+	atomic.AddUint64(&s.stats.totalConns, 1)
+}
+
+func (s *Server) connClosed() {
+	atomic.AddUint64(&s.stats.currConns, ^uint64(0))
+}
@@ -0,0 +1,157 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newTestServer(t *testing.T) (*Server, *testClient) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(gomap.NewTTLMap(-1, 100*time.Millisecond, false))
+	go s.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &testClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+}
+
+//do 发送请求并读取n行回复
+func (c *testClient) do(req string, n int) string {
+	if _, err := c.conn.Write([]byte(req)); err != nil {
+		c.t.Fatal(err)
+	}
+	var lines []string
+	for i := 0; i < n; i++ {
+		line, err := c.rd.ReadString('\n')
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+	return strings.Join(lines, "|")
+}
+
+func (c *testClient) expect(want string, req string) {
+	if got := c.do(req, strings.Count(want, "|")+1); got != want {
+		c.t.Fatalf("%q: want %q, got %q", req, want, got)
+	}
+}
+
+func TestServer_Storage(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("END", "get a\r\n")
+	c.expect("STORED", "set a 5 0 3\r\nabc\r\n")
+	c.expect("VALUE a 5 3|abc|END", "get a\r\n")
+	c.expect("NOT_STORED", "add a 0 0 1\r\nx\r\n")
+	c.expect("NOT_STORED", "replace b 0 0 1\r\nx\r\n")
+	c.expect("STORED", "append a 0 0 2\r\nde\r\n")
+	c.expect("STORED", "prepend a 0 0 2\r\nxy\r\n")
+	c.expect("VALUE a 5 7|xyabcde|END", "get a\r\n")
+	c.expect("STORED", "add b 0 0 1\r\nx\r\n")
+	c.expect("VALUE a 5 7|xyabcde|VALUE b 0 1|x|END", "get a c b\r\n")
+	c.expect("DELETED", "delete a\r\n")
+	c.expect("NOT_FOUND", "delete a\r\n")
+	c.expect("CLIENT_ERROR bad data chunk", "set a 0 0 1\r\nabc\r\n")
+}
+
+func TestServer_Cas(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("NOT_FOUND", "cas a 0 0 1 1\r\nx\r\n")
+	c.do("set a 0 0 1\r\nx\r\n", 1)
+	reply := c.do("gets a\r\n", 3)
+	cas := strings.Fields(strings.Split(reply, "|")[0])[4]
+	c.expect("STORED", "cas a 0 0 1 "+cas+"\r\ny\r\n")
+	c.expect("EXISTS", "cas a 0 0 1 "+cas+"\r\nz\r\n")
+	c.expect("VALUE a 0 1|y|END", "get a\r\n")
+}
+
+func TestServer_Incr(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("NOT_FOUND", "incr a 1\r\n")
+	c.do("set a 0 0 2\r\n10\r\n", 1)
+	c.expect("15", "incr a 5\r\n")
+	c.expect("0", "decr a 20\r\n")
+	c.do("set b 0 0 1\r\nx\r\n", 1)
+	c.expect(errNonNumber, "incr b 1\r\n")
+	c.expect(errNumber, "incr a x\r\n")
+}
+
+func TestServer_Expire(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.do("set a 0 1 1\r\nx\r\n", 1)
+	c.do("set b 0 0 1\r\nx\r\n", 1)
+	c.expect("STORED", "set c 0 -1 1\r\nx\r\n")
+	c.expect("END", "get c\r\n")
+	// append/prepend忽略exptime，其他写入以过期的exptime覆盖时删除原有数据
+	c.do("set d 0 0 1\r\nx\r\n", 1)
+	c.expect("STORED", "append d 0 -1 1\r\ny\r\n")
+	c.expect("VALUE d 0 2|xy|END", "get d\r\n")
+	c.expect("STORED", "set d 0 -1 1\r\nz\r\n")
+	c.expect("END", "get d\r\n")
+	c.expect("TOUCHED", "touch b 1\r\n")
+	c.expect("TOUCHED", "touch a 0\r\n")
+	c.expect("NOT_FOUND", "touch x 0\r\n")
+	time.Sleep(1100 * time.Millisecond)
+	c.expect("VALUE a 0 1|x|END", "get a b\r\n")
+	c.expect("OK", "flush_all\r\n")
+	c.expect("END", "get a\r\n")
+}
+
+func TestServer_FlushDelay(t *testing.T) {
+	s, c := newTestServer(t)
+	c.do("set a 0 0 1\r\nx\r\n", 1)
+	c.expect("OK", "flush_all 1\r\n")
+	c.expect("VALUE a 0 1|x|END", "get a\r\n")
+	time.Sleep(1100 * time.Millisecond)
+	c.expect("END", "get a\r\n")
+	// 关闭服务后尚未执行的延迟清空不再触发，销毁map也不会panic
+	c.do("set a 0 0 1\r\nx\r\n", 1)
+	c.expect("OK", "flush_all 1\r\n")
+	s.Close()
+	s.m.Destroy()
+	time.Sleep(1100 * time.Millisecond)
+}
+
+func TestServer_Noreply(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.conn.Write([]byte("set a 0 0 1 noreply\r\nx\r\ndelete b noreply\r\n"))
+	c.expect("VALUE a 0 1|x|END", "get a\r\n")
+}
+
+func TestServer_Stats(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.do("set a 0 0 1\r\nx\r\n", 1)
+	c.conn.Write([]byte("stats\r\n"))
+	for {
+		line, err := c.rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(strings.TrimSpace(line))
+		if line == "END\r\n" {
+			break
+		}
+	}
+	c.expect("VERSION "+version, "version\r\n")
+}
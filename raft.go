@@ -0,0 +1,846 @@
+package gomap
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// raft一致性协议实现，包括选举、日志复制、快照与单节点成员变更。
+// 日志与投票状态仅保存在内存中，节点重启后应以新节点身份重新加入集群。
+
+type (
+	// RaftTransport 节点间通信
+	RaftTransport interface {
+		Listen(handler RaftHandler) error                                            // 开始处理发往本节点的消息
+		Send(ctx context.Context, to string, msg *RaftMessage) (*RaftMessage, error) // 发送消息并等待响应
+		Close() error                                                                // 关闭
+	}
+
+	RaftHandler func(msg *RaftMessage) *RaftMessage
+
+	// RaftMessage 节点间的请求与响应
+	RaftMessage struct {
+		Type          int
+		Term          uint64
+		From          string
+		LastLogIndex  uint64      // RequestVote
+		LastLogTerm   uint64      // RequestVote
+		PrevLogIndex  uint64      // AppendEntries
+		PrevLogTerm   uint64      // AppendEntries
+		Entries       []RaftEntry // AppendEntries、Propose
+		LeaderCommit  uint64      // AppendEntries
+		SnapshotIndex uint64      // InstallSnapshot
+		SnapshotTerm  uint64      // InstallSnapshot
+		Snapshot      []byte      // InstallSnapshot
+		Peers         []string    // InstallSnapshot中快照对应的成员
+		Success       bool        // 响应：投票/追加是否成功
+		ConflictIndex uint64      // 响应：追加失败时leader应回退到的索引
+		Index         uint64      // 响应：Propose提交的索引
+		Result        []byte      // 响应：Propose的执行结果
+		Error         string      // 响应：Propose失败原因
+	}
+
+	RaftEntry struct {
+		Index uint64
+		Term  uint64
+		Type  int
+		Data  []byte
+	}
+
+	raftState int
+
+	raftWaiter struct {
+		term uint64
+		ch   chan raftApplied
+	}
+
+	raftApplied struct {
+		result []byte
+		err    error
+	}
+
+	raftNode struct {
+		id        string
+		transport RaftTransport
+		cfg       RaftConfig
+		apply     func(entry RaftEntry) []byte // 应用到状态机，返回执行结果
+		snapshot  func() []byte                // 生成状态机快照
+		restore   func(data []byte)            // 从快照恢复状态机
+
+		mu              sync.Mutex
+		applyCond       *sync.Cond
+		state           raftState
+		term            uint64
+		votedFor        string
+		leader          string
+		log             []RaftEntry // log[0]为快照位置的占位记录
+		snapData        []byte      // 最新快照
+		snapPeers       []string    // 快照对应的成员
+		pendingSnapshot bool        // 快照待安装到状态机
+		commitIndex     uint64
+		lastApplied     uint64
+		peers           []string             // 当前成员，以日志中最新的配置为准
+		nextIndex       map[string]uint64    // leader：下一条发送给各节点的索引
+		matchIndex      map[string]uint64    // leader：各节点已复制的索引
+		replicators     map[string]chan bool // leader：各节点复制协程的触发通道
+		waiters         map[uint64]raftWaiter
+		deadline        time.Time // 选举超时时间点
+		lastContact     time.Time // 最近一次收到leader消息的时间
+		closed          bool
+		exit            chan bool
+	}
+)
+
+const (
+	raftFollower raftState = iota
+	raftCandidate
+	raftLeader
+)
+
+const (
+	raftMsgVote = iota + 1
+	raftMsgAppend
+	raftMsgSnapshot
+	raftMsgPropose
+)
+
+const (
+	raftEntryNoop = iota
+	raftEntryCommand
+	raftEntryConfig
+)
+
+const raftMaxBatch = 256
+
+var (
+	ErrRaftNotLeader      = errors.New("ErrRaftNotLeader")
+	ErrRaftNoLeader       = errors.New("ErrRaftNoLeader")
+	ErrRaftLeadershipLost = errors.New("ErrRaftLeadershipLost")
+	ErrRaftConfigChange   = errors.New("ErrRaftConfigChange")
+	ErrRaftClosed         = errors.New("ErrRaftClosed")
+)
+
+func newRaftNode(cfg RaftConfig, apply func(RaftEntry) []byte, snapshot func() []byte, restore func([]byte)) *raftNode {
+	r := &raftNode{
+		id:        cfg.ID,
+		transport: cfg.Transport,
+		cfg:       cfg,
+		apply:     apply,
+		snapshot:  snapshot,
+		restore:   restore,
+		log:       []RaftEntry{{}},
+		snapPeers: append([]string(nil), cfg.Peers...),
+		peers:     append([]string(nil), cfg.Peers...),
+		waiters:   map[uint64]raftWaiter{},
+		exit:      make(chan bool),
+	}
+	r.applyCond = sync.NewCond(&r.mu)
+	r.resetDeadline()
+	return r
+}
+
+func (r *raftNode) start() error {
+	if err := r.transport.Listen(r.handle); err != nil {
+		return err
+	}
+	go r.tickLoop()
+	go r.applyLoop()
+	return nil
+}
+
+func (r *raftNode) stop() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.exit)
+	for index, w := range r.waiters {
+		w.ch <- raftApplied{err: ErrRaftClosed}
+		delete(r.waiters, index)
+	}
+	r.applyCond.Broadcast()
+	r.mu.Unlock()
+	r.transport.Close()
+}
+
+func (r *raftNode) snapIndex() uint64 {
+	return r.log[0].Index
+}
+
+func (r *raftNode) lastIndex() uint64 {
+	return r.log[len(r.log)-1].Index
+}
+
+func (r *raftNode) lastTerm() uint64 {
+	return r.log[len(r.log)-1].Term
+}
+
+//termAt 索引对应的任期，索引早于快照时返回0
+func (r *raftNode) termAt(index uint64) uint64 {
+	if index < r.snapIndex() || index > r.lastIndex() {
+		return 0
+	}
+	return r.log[index-r.snapIndex()].Term
+}
+
+func (r *raftNode) resetDeadline() {
+	timeout := r.cfg.ElectionTimeout
+	r.deadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+func (r *raftNode) isMember(id string) bool {
+	for _, peer := range r.peers {
+		if peer == id {
+			return true
+		}
+	}
+	return false
+}
+
+//configAt 索引处生效的成员配置
+func (r *raftNode) configAt(index uint64) []string {
+	for i := len(r.log) - 1; i > 0; i-- {
+		if e := r.log[i]; e.Index <= index && e.Type == raftEntryConfig {
+			return decodePeers(e.Data)
+		}
+	}
+	return r.snapPeers
+}
+
+//updatePeers 日志变化后重新计算当前成员，leader同时维护复制协程
+func (r *raftNode) updatePeers() {
+	r.peers = r.configAt(r.lastIndex())
+	if r.state != raftLeader {
+		return
+	}
+	for _, peer := range r.peers {
+		if peer == r.id {
+			continue
+		}
+		if _, ok := r.replicators[peer]; !ok {
+			r.nextIndex[peer] = r.lastIndex() + 1
+			r.matchIndex[peer] = 0
+			r.startReplicator(peer)
+		}
+	}
+	for peer, trigger := range r.replicators {
+		if !r.isMember(peer) {
+			close(trigger)
+			delete(r.replicators, peer)
+		}
+	}
+}
+
+//pendingConfig 是否有未提交的成员变更
+func (r *raftNode) pendingConfig() bool {
+	for i := len(r.log) - 1; i > 0 && r.log[i].Index > r.commitIndex; i-- {
+		if r.log[i].Type == raftEntryConfig {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *raftNode) becomeFollower(term uint64) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+	}
+	if r.state == raftLeader {
+		for _, trigger := range r.replicators {
+			close(trigger)
+		}
+		r.replicators = nil
+	}
+	r.state = raftFollower
+}
+
+func (r *raftNode) becomeLeader() {
+	r.state = raftLeader
+	r.leader = r.id
+	r.nextIndex = map[string]uint64{}
+	r.matchIndex = map[string]uint64{}
+	r.replicators = map[string]chan bool{}
+	// 追加空日志，以便提交之前任期的日志
+	r.appendEntry(raftEntryNoop, nil)
+	r.updatePeers()
+}
+
+func (r *raftNode) startReplicator(peer string) {
+	trigger := make(chan bool, 1)
+	r.replicators[peer] = trigger
+	go r.replicate(peer, r.term, trigger)
+	trigger <- true
+}
+
+//appendEntry leader追加日志并通知复制
+func (r *raftNode) appendEntry(typ int, data []byte) RaftEntry {
+	entry := RaftEntry{Index: r.lastIndex() + 1, Term: r.term, Type: typ, Data: data}
+	r.log = append(r.log, entry)
+	if typ == raftEntryConfig {
+		r.updatePeers()
+	}
+	for _, trigger := range r.replicators {
+		select {
+		case trigger <- true:
+		default:
+		}
+	}
+	r.advanceCommit()
+	return entry
+}
+
+//advanceCommit leader根据多数派的复制进度推进提交索引
+func (r *raftNode) advanceCommit() {
+	for n := r.lastIndex(); n > r.commitIndex && n > r.snapIndex(); n-- {
+		if r.termAt(n) != r.term {
+			break
+		}
+		count, voters := 0, 0
+		for _, peer := range r.configAt(n) {
+			voters++
+			if peer == r.id || r.matchIndex[peer] >= n {
+				count++
+			}
+		}
+		if count*2 > voters {
+			r.commitIndex = n
+			r.applyCond.Broadcast()
+			break
+		}
+	}
+}
+
+func (r *raftNode) tickLoop() {
+	interval := r.cfg.HeartbeatInterval / 2
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.exit:
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		if r.state != raftLeader && time.Now().After(r.deadline) && r.isMember(r.id) {
+			r.startElection()
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *raftNode) startElection() {
+	r.state = raftCandidate
+	r.term++
+	r.votedFor = r.id
+	r.leader = ""
+	r.resetDeadline()
+	term := r.term
+	req := &RaftMessage{
+		Type:         raftMsgVote,
+		Term:         term,
+		From:         r.id,
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.lastTerm(),
+	}
+	votes, voters := 1, len(r.peers)
+	if votes*2 > voters {
+		r.becomeLeader()
+		return
+	}
+	for _, peer := range r.peers {
+		if peer == r.id {
+			continue
+		}
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ElectionTimeout)
+			defer cancel()
+			resp, err := r.transport.Send(ctx, peer, req)
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if resp.Term > r.term {
+				r.becomeFollower(resp.Term)
+				r.resetDeadline()
+				return
+			}
+			if r.state != raftCandidate || r.term != term || !resp.Success {
+				return
+			}
+			votes++
+			if votes*2 > voters {
+				r.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+//replicate leader向单个节点复制日志的协程，任期变化或节点移除时退出
+func (r *raftNode) replicate(peer string, term uint64, trigger chan bool) {
+	timer := time.NewTimer(r.cfg.HeartbeatInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-trigger:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+		case <-r.exit:
+			return
+		}
+		for r.replicateOnce(peer, term) {
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.cfg.HeartbeatInterval)
+	}
+}
+
+//replicateOnce 发送一次AppendEntries或InstallSnapshot，返回是否还有数据需要立即发送
+func (r *raftNode) replicateOnce(peer string, term uint64) bool {
+	r.mu.Lock()
+	if r.state != raftLeader || r.term != term || !r.isMember(peer) {
+		r.mu.Unlock()
+		return false
+	}
+	next := r.nextIndex[peer]
+	var req *RaftMessage
+	if next <= r.snapIndex() {
+		req = &RaftMessage{
+			Type:          raftMsgSnapshot,
+			Term:          term,
+			From:          r.id,
+			SnapshotIndex: r.snapIndex(),
+			SnapshotTerm:  r.log[0].Term,
+			Snapshot:      r.snapData,
+			Peers:         r.snapPeers,
+		}
+	} else {
+		end := r.lastIndex() + 1
+		if end-next > raftMaxBatch {
+			end = next + raftMaxBatch
+		}
+		req = &RaftMessage{
+			Type:         raftMsgAppend,
+			Term:         term,
+			From:         r.id,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  r.termAt(next - 1),
+			Entries:      append([]RaftEntry(nil), r.log[next-r.snapIndex():end-r.snapIndex()]...),
+			LeaderCommit: r.commitIndex,
+		}
+	}
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ElectionTimeout)
+	resp, err := r.transport.Send(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if resp.Term > r.term {
+		r.becomeFollower(resp.Term)
+		r.resetDeadline()
+		return false
+	}
+	if r.state != raftLeader || r.term != term {
+		return false
+	}
+	switch {
+	case req.Type == raftMsgSnapshot:
+		r.matchIndex[peer] = req.SnapshotIndex
+		r.nextIndex[peer] = req.SnapshotIndex + 1
+	case resp.Success:
+		match := req.PrevLogIndex + uint64(len(req.Entries))
+		if match > r.matchIndex[peer] {
+			r.matchIndex[peer] = match
+		}
+		r.nextIndex[peer] = r.matchIndex[peer] + 1
+	default:
+		r.nextIndex[peer] = resp.ConflictIndex
+		if r.nextIndex[peer] < 1 {
+			r.nextIndex[peer] = 1
+		}
+	}
+	r.advanceCommit()
+	// leader被移出集群且变更已提交后退位
+	if !r.isMember(r.id) && !r.pendingConfig() {
+		r.becomeFollower(r.term)
+		return false
+	}
+	return r.nextIndex[peer] <= r.lastIndex()
+}
+
+//handle 处理来自其他节点的消息
+func (r *raftNode) handle(msg *RaftMessage) *RaftMessage {
+	if msg.Type == raftMsgPropose {
+		return r.handlePropose(msg)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return &RaftMessage{Term: r.term}
+	}
+	switch msg.Type {
+	case raftMsgVote:
+		return r.handleVote(msg)
+	case raftMsgAppend:
+		return r.handleAppend(msg)
+	case raftMsgSnapshot:
+		return r.handleSnapshot(msg)
+	}
+	return &RaftMessage{Term: r.term}
+}
+
+func (r *raftNode) handleVote(req *RaftMessage) *RaftMessage {
+	resp := &RaftMessage{Term: r.term}
+	if req.Term < r.term {
+		return resp
+	}
+	// 确认leader仍然存活时忽略投票请求，避免被移出集群的节点干扰
+	if r.state == raftLeader || (r.leader != "" && time.Since(r.lastContact) < r.cfg.ElectionTimeout) {
+		return resp
+	}
+	if req.Term > r.term {
+		r.becomeFollower(req.Term)
+		resp.Term = r.term
+	}
+	upToDate := req.LastLogTerm > r.lastTerm() || (req.LastLogTerm == r.lastTerm() && req.LastLogIndex >= r.lastIndex())
+	if (r.votedFor == "" || r.votedFor == req.From) && upToDate {
+		r.votedFor = req.From
+		r.resetDeadline()
+		resp.Success = true
+	}
+	return resp
+}
+
+func (r *raftNode) handleAppend(req *RaftMessage) *RaftMessage {
+	resp := &RaftMessage{Term: r.term}
+	if req.Term < r.term {
+		return resp
+	}
+	if req.Term > r.term || r.state != raftFollower {
+		r.becomeFollower(req.Term)
+		resp.Term = r.term
+	}
+	r.leader = req.From
+	r.lastContact = time.Now()
+	r.resetDeadline()
+
+	prev, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if prev < r.snapIndex() {
+		// 已包含在快照中的部分直接跳过
+		skip := r.snapIndex() - prev
+		if skip >= uint64(len(entries)) {
+			resp.Success = true
+			return resp
+		}
+		entries = entries[skip:]
+		prev, prevTerm = r.snapIndex(), r.log[0].Term
+	}
+	if prev > r.lastIndex() {
+		resp.ConflictIndex = r.lastIndex() + 1
+		return resp
+	}
+	if term := r.termAt(prev); term != prevTerm {
+		// 回退到冲突任期的第一条日志
+		index := prev
+		for index > r.snapIndex()+1 && r.termAt(index-1) == term {
+			index--
+		}
+		resp.ConflictIndex = index
+		return resp
+	}
+	changed := false
+	for i, entry := range entries {
+		if entry.Index <= r.lastIndex() {
+			if r.termAt(entry.Index) == entry.Term {
+				continue
+			}
+			r.log = r.log[:entry.Index-r.snapIndex()]
+		}
+		r.log = append(r.log, entries[i:]...)
+		changed = true
+		break
+	}
+	if changed {
+		r.updatePeers()
+	}
+	last := prev + uint64(len(entries))
+	if req.LeaderCommit > r.commitIndex {
+		r.commitIndex = req.LeaderCommit
+		if last < r.commitIndex {
+			r.commitIndex = last
+		}
+		r.applyCond.Broadcast()
+	}
+	resp.Success = true
+	return resp
+}
+
+func (r *raftNode) handleSnapshot(req *RaftMessage) *RaftMessage {
+	resp := &RaftMessage{Term: r.term}
+	if req.Term < r.term {
+		return resp
+	}
+	if req.Term > r.term || r.state != raftFollower {
+		r.becomeFollower(req.Term)
+		resp.Term = r.term
+	}
+	r.leader = req.From
+	r.lastContact = time.Now()
+	r.resetDeadline()
+	if req.SnapshotIndex <= r.snapIndex() {
+		return resp
+	}
+	placeholder := RaftEntry{Index: req.SnapshotIndex, Term: req.SnapshotTerm}
+	if req.SnapshotIndex < r.lastIndex() && r.termAt(req.SnapshotIndex) == req.SnapshotTerm {
+		r.log = append([]RaftEntry{placeholder}, r.log[req.SnapshotIndex-r.snapIndex()+1:]...)
+	} else {
+		r.log = []RaftEntry{placeholder}
+	}
+	r.snapData = req.Snapshot
+	r.snapPeers = req.Peers
+	r.updatePeers()
+	if r.commitIndex < req.SnapshotIndex {
+		r.commitIndex = req.SnapshotIndex
+	}
+	if r.lastApplied < req.SnapshotIndex {
+		r.pendingSnapshot = true
+		r.applyCond.Broadcast()
+	}
+	return resp
+}
+
+//applyLoop 按顺序将已提交的日志应用到状态机，并在日志过长时生成快照
+func (r *raftNode) applyLoop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		for !r.closed && !r.pendingSnapshot && r.lastApplied >= r.commitIndex {
+			r.applyCond.Wait()
+		}
+		if r.closed {
+			return
+		}
+		if r.pendingSnapshot {
+			r.pendingSnapshot = false
+			data, index := r.snapData, r.snapIndex()
+			r.mu.Unlock()
+			r.restore(data)
+			r.mu.Lock()
+			if r.lastApplied < index {
+				r.lastApplied = index
+			}
+			r.notifyApplied(index, RaftEntry{}, nil)
+			continue
+		}
+		index := r.lastApplied + 1
+		entry := r.log[index-r.snapIndex()]
+		r.mu.Unlock()
+		var result []byte
+		if entry.Type == raftEntryCommand {
+			result = r.apply(entry)
+		}
+		r.mu.Lock()
+		r.lastApplied = index
+		r.notifyApplied(index, entry, result)
+		if r.cfg.SnapshotThreshold > 0 && r.lastApplied-r.snapIndex() >= uint64(r.cfg.SnapshotThreshold) && !r.pendingSnapshot {
+			r.mu.Unlock()
+			data := r.snapshot()
+			r.mu.Lock()
+			r.compact(index, data)
+		}
+	}
+}
+
+//notifyApplied 通知等待到index为止的提案，任期不一致说明提案已被覆盖
+func (r *raftNode) notifyApplied(index uint64, entry RaftEntry, result []byte) {
+	for i, w := range r.waiters {
+		if i > index {
+			continue
+		}
+		if i == index && entry.Term == w.term && entry.Index == index {
+			w.ch <- raftApplied{result: result}
+		} else {
+			w.ch <- raftApplied{err: ErrRaftLeadershipLost}
+		}
+		delete(r.waiters, i)
+	}
+}
+
+//compact 以index处的状态机快照截断日志
+func (r *raftNode) compact(index uint64, data []byte) {
+	if index <= r.snapIndex() {
+		return
+	}
+	peers := r.configAt(index)
+	r.log = append([]RaftEntry{{Index: index, Term: r.termAt(index)}}, r.log[index-r.snapIndex()+1:]...)
+	r.snapData = data
+	r.snapPeers = peers
+}
+
+//propose 提交一条日志并等待应用，非leader时转发给leader
+func (r *raftNode) propose(ctx context.Context, typ int, data []byte) ([]byte, error) {
+	for {
+		result, err := r.tryPropose(ctx, typ, data)
+		if err != ErrRaftNoLeader {
+			return result, err
+		}
+		// 选举中，稍后重试
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.exit:
+			return nil, ErrRaftClosed
+		case <-time.After(r.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+func (r *raftNode) tryPropose(ctx context.Context, typ int, data []byte) ([]byte, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRaftClosed
+	}
+	if r.state == raftLeader {
+		r.mu.Unlock()
+		var index uint64
+		result, err := r.tryProposeIndex(ctx, typ, data, &index)
+		if err == ErrRaftNotLeader {
+			return nil, ErrRaftNoLeader
+		}
+		return result, err
+	}
+	leader := r.leader
+	r.mu.Unlock()
+	if leader == "" || leader == r.id {
+		return nil, ErrRaftNoLeader
+	}
+	resp, err := r.transport.Send(ctx, leader, &RaftMessage{
+		Type:    raftMsgPropose,
+		From:    r.id,
+		Entries: []RaftEntry{{Type: typ, Data: data}},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrRaftNoLeader
+	}
+	switch resp.Error {
+	case "":
+	case ErrRaftNotLeader.Error(), ErrRaftNoLeader.Error():
+		return nil, ErrRaftNoLeader
+	case ErrRaftLeadershipLost.Error():
+		return nil, ErrRaftLeadershipLost
+	default:
+		return nil, errors.New(resp.Error)
+	}
+	// 等待本地应用到该索引，保证随后在本节点的读取能看到写入
+	if err := r.waitApplied(ctx, resp.Index); err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+//handlePropose leader处理其他节点转发的提案
+func (r *raftNode) handlePropose(msg *RaftMessage) *RaftMessage {
+	r.mu.Lock()
+	isLeader := r.state == raftLeader
+	r.mu.Unlock()
+	if !isLeader || len(msg.Entries) != 1 {
+		return &RaftMessage{Error: ErrRaftNotLeader.Error()}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+	defer cancel()
+	var index uint64
+	result, err := r.tryProposeIndex(ctx, msg.Entries[0].Type, msg.Entries[0].Data, &index)
+	if err != nil {
+		return &RaftMessage{Error: err.Error()}
+	}
+	return &RaftMessage{Success: true, Index: index, Result: result}
+}
+
+//tryProposeIndex leader追加日志并等待应用，返回提交的索引
+func (r *raftNode) tryProposeIndex(ctx context.Context, typ int, data []byte, index *uint64) ([]byte, error) {
+	r.mu.Lock()
+	if r.state != raftLeader {
+		r.mu.Unlock()
+		return nil, ErrRaftNotLeader
+	}
+	if typ == raftEntryConfig && r.pendingConfig() {
+		r.mu.Unlock()
+		return nil, ErrRaftConfigChange
+	}
+	entry := r.appendEntry(typ, data)
+	*index = entry.Index
+	ch := make(chan raftApplied, 1)
+	r.waiters[entry.Index] = raftWaiter{term: entry.Term, ch: ch}
+	r.mu.Unlock()
+	select {
+	case applied := <-ch:
+		return applied.result, applied.err
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.waiters, entry.Index)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+//waitApplied 等待本地应用到index
+func (r *raftNode) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		r.mu.Lock()
+		applied, closed := r.lastApplied, r.closed
+		r.mu.Unlock()
+		if closed {
+			return ErrRaftClosed
+		}
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (r *raftNode) status() (leader string, isLeader bool, peers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader, r.state == raftLeader, append([]string(nil), r.peers...)
+}
+
+func encodePeers(peers []string) []byte {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(peers)
+	return buf.Bytes()
+}
+
+func decodePeers(data []byte) []string {
+	var peers []string
+	gob.NewDecoder(bytes.NewReader(data)).Decode(&peers)
+	return peers
+}
@@ -0,0 +1,533 @@
+package gomap
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// RaftConfig RaftMap节点配置
+	RaftConfig struct {
+		ID                string          // 节点标识，使用RaftTCPTransport时为节点地址
+		Peers             []string        // 初始成员，包含自身；加入已有集群的新节点留空，由leader调用AddNode加入
+		Transport         RaftTransport   // 传输层
+		ElectionTimeout   time.Duration   // 选举超时，实际在[1,2)倍之间随机，默认300ms
+		HeartbeatInterval time.Duration   // 心跳间隔，默认50ms
+		SnapshotThreshold int             // 快照后应用的日志超过该条数时生成快照，默认1024
+		Expiration        time.Duration   // Store的默认过期时间，<=0表示永不过期
+		GCInterval        time.Duration   // leader检查过期数据的周期，默认1s
+		Timeout           time.Duration   // Map接口方法等待提交的超时，默认5s
+		OnError           func(err error) // Map接口的写方法提交失败时回调，为空时忽略
+	}
+
+	// RaftMap 基于raft的强一致map。
+	// 写入经leader提交到多数节点后才返回，非leader节点自动转发给leader；读取使用本地已应用的状态。
+	// 过期由leader判断并以删除日志复制到各节点。
+	// 选举、leader切换等暂时状态下提交会在Timeout内重试，每个请求带有唯一标识，重试不会重复应用。
+	// Map接口的写方法无法返回错误，仍提交失败时写入视为未执行并通过OnError回调报告，需要处理错误时使用Set、Remove等方法。
+	// 自定义类型的值需要通过gob.Register注册。
+	RaftMap struct {
+		node       *raftNode
+		data       *TTLMap // 状态机，存储raftItem
+		expiration time.Duration
+		gcInterval time.Duration
+		timeout    time.Duration
+		onError    func(err error)
+		clientID   string            // 请求标识前缀
+		seq        uint64            // 请求序号
+		requests   map[string][]byte // 最近应用的请求及其结果，用于去重，随data.mu保护
+		order      []string          // requests的应用顺序，超出raftRequestWindow时淘汰最早的
+		exit       chan bool
+	}
+
+	// raftItem 状态机中的值，Version为最后写入该key的日志索引
+	raftItem struct {
+		Value   interface{}
+		Version uint64
+	}
+
+	raftCommand struct {
+		ID         string // 请求标识，重试时保持不变
+		Op         int
+		Key        string
+		Value      interface{}
+		Expiration int64    // 绝对过期时间，-1表示永不过期
+		Version    uint64   // CompareAndStore期望的版本，0表示key不存在
+		Now        int64    // 提案时间，应用时以此判断是否过期，保证各节点结果一致
+		Keys       []string // Expire
+		Versions   []uint64 // Expire
+	}
+
+	raftResult struct {
+		Value   interface{}
+		OK      bool
+		Entries []Entry
+	}
+
+	raftSnapshot struct {
+		Items    []raftSnapshotItem
+		Requests []raftRequest
+	}
+
+	raftRequest struct {
+		ID     string
+		Result []byte
+	}
+
+	raftSnapshotItem struct {
+		Key        string
+		Value      interface{}
+		Version    uint64
+		Expiration int64
+	}
+)
+
+const (
+	raftOpStore = iota + 1
+	raftOpLoadOrStore
+	raftOpCompareAndStore
+	raftOpDelete
+	raftOpExpire
+	raftOpClear
+)
+
+const (
+	raftExpireBatch   = 1024
+	raftRequestWindow = 4096 // 保留用于去重的请求数
+)
+
+func NewRaftMap(cfg RaftConfig) (*RaftMap, error) {
+	if cfg.ID == "" || cfg.Transport == nil {
+		return nil, errors.New("raft: ID and Transport are required")
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 300 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	if cfg.SnapshotThreshold == 0 {
+		cfg.SnapshotThreshold = 1024
+	}
+	if cfg.GCInterval <= 0 {
+		cfg.GCInterval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	m := &RaftMap{
+		data:       NewTTLMap(NoExpiration, 0, false),
+		expiration: cfg.Expiration,
+		gcInterval: cfg.GCInterval,
+		timeout:    cfg.Timeout,
+		onError:    cfg.OnError,
+		clientID:   newRunID(),
+		requests:   map[string][]byte{},
+		exit:       make(chan bool),
+	}
+	m.node = newRaftNode(cfg, m.apply, m.snapshot, m.restore)
+	if err := m.node.start(); err != nil {
+		m.data.Destroy()
+		return nil, err
+	}
+	go m.gcLoop()
+	return m, nil
+}
+
+//gcLoop leader定期将过期数据以删除日志提交
+func (m *RaftMap) gcLoop() {
+	ticker := time.NewTicker(m.gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if m.IsLeader() {
+				m.DeleteExpired()
+			}
+		case <-m.exit:
+			return
+		}
+	}
+}
+
+//DeleteExpired 提交本地已过期数据的删除，仅leader执行，返回提交的key数量
+func (m *RaftMap) DeleteExpired() int {
+	if !m.IsLeader() {
+		return 0
+	}
+	cmd := raftCommand{Op: raftOpExpire}
+	now := time.Now().UnixNano()
+	m.data.mu.RLock()
+	for key, item := range m.data.entryMap {
+		if item.expiration > 0 && now > item.expiration {
+			cmd.Keys = append(cmd.Keys, key)
+			cmd.Versions = append(cmd.Versions, item.Value.(raftItem).Version)
+			if len(cmd.Keys) >= raftExpireBatch {
+				break
+			}
+		}
+	}
+	m.data.mu.RUnlock()
+	if len(cmd.Keys) == 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	if _, err := m.propose(ctx, cmd); err != nil {
+		return 0
+	}
+	return len(cmd.Keys)
+}
+
+//apply 应用一条命令，只依赖命令内容与当前状态，各节点结果一致
+func (m *RaftMap) apply(entry RaftEntry) []byte {
+	var cmd raftCommand
+	if err := gob.NewDecoder(bytes.NewReader(entry.Data)).Decode(&cmd); err != nil {
+		return nil
+	}
+	d := m.data
+	d.mu.Lock()
+	defer d.watchers.wait()
+	defer d.mu.Unlock()
+	if d.entryMap == nil {
+		return nil
+	}
+	if cmd.ID != "" {
+		if result, ok := m.requests[cmd.ID]; ok {
+			// 重试的请求已应用过，直接返回原结果
+			return result
+		}
+	}
+	alive := func(key string) (ttlEntry, bool) {
+		item, ok := d.entryMap[key]
+		if !ok || (item.expiration > 0 && cmd.Now > item.expiration) {
+			return ttlEntry{}, false
+		}
+		return item, true
+	}
+	var result raftResult
+	switch cmd.Op {
+	case raftOpStore:
+		d.storeAt(cmd.Key, raftItem{Value: cmd.Value, Version: entry.Index}, cmd.Expiration)
+		result.OK = true
+	case raftOpLoadOrStore:
+		if item, ok := alive(cmd.Key); ok {
+			result.Value, result.OK = item.Value.(raftItem).Value, true
+		} else {
+			d.storeAt(cmd.Key, raftItem{Value: cmd.Value, Version: entry.Index}, cmd.Expiration)
+			result.Value = cmd.Value
+		}
+	case raftOpCompareAndStore:
+		var version uint64
+		if item, ok := alive(cmd.Key); ok {
+			version = item.Value.(raftItem).Version
+		}
+		if version == cmd.Version {
+			d.storeAt(cmd.Key, raftItem{Value: cmd.Value, Version: entry.Index}, cmd.Expiration)
+			result.OK = true
+		}
+	case raftOpDelete:
+		if item, ok := d.entryMap[cmd.Key]; ok {
+			d.remove(cmd.Key)
+			value := item.Value.(raftItem).Value
+			if item.expiration > 0 && cmd.Now > item.expiration {
+				d.watchers.notify(Event{Op: OpExpire, Key: cmd.Key, Old: value})
+			} else {
+				result.Value, result.OK = value, true
+				d.watchers.notify(Event{Op: OpDelete, Key: cmd.Key, Old: value})
+			}
+		}
+	case raftOpExpire:
+		for i, key := range cmd.Keys {
+			if item, ok := d.entryMap[key]; ok && item.Value.(raftItem).Version == cmd.Versions[i] {
+				d.remove(key)
+				d.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value.(raftItem).Value})
+			}
+		}
+	case raftOpClear:
+		for key := range d.entryMap {
+			if item, ok := alive(key); ok {
+				result.Entries = append(result.Entries, Entry{Key: key, Value: item.Value.(raftItem).Value})
+			}
+		}
+		d.entryMap = map[string]ttlEntry{}
+		d.retrack()
+		d.watchers.notify(Event{Op: OpClear})
+	}
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&result)
+	if cmd.ID != "" {
+		m.remember(cmd.ID, buf.Bytes())
+	}
+	return buf.Bytes()
+}
+
+//remember 记录已应用的请求，需持有data.mu；按应用顺序淘汰，各节点结果一致
+func (m *RaftMap) remember(id string, result []byte) {
+	m.requests[id] = result
+	m.order = append(m.order, id)
+	if len(m.order) > raftRequestWindow {
+		delete(m.requests, m.order[0])
+		m.order = append([]string(nil), m.order[1:]...)
+	}
+}
+
+//snapshot 编码当前状态机
+func (m *RaftMap) snapshot() []byte {
+	m.data.mu.RLock()
+	snap := raftSnapshot{Items: make([]raftSnapshotItem, 0, len(m.data.entryMap))}
+	for key, item := range m.data.entryMap {
+		v := item.Value.(raftItem)
+		snap.Items = append(snap.Items, raftSnapshotItem{Key: key, Value: v.Value, Version: v.Version, Expiration: item.expiration})
+	}
+	for _, id := range m.order {
+		snap.Requests = append(snap.Requests, raftRequest{ID: id, Result: m.requests[id]})
+	}
+	m.data.mu.RUnlock()
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&snap)
+	return buf.Bytes()
+}
+
+//restore 以快照替换状态机
+func (m *RaftMap) restore(data []byte) {
+	var snap raftSnapshot
+	if len(data) > 0 {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snap); err != nil {
+			return
+		}
+	}
+	entryMap := make(map[string]ttlEntry, len(snap.Items))
+	for _, item := range snap.Items {
+		entryMap[item.Key] = ttlEntry{
+			Entry:      Entry{Key: item.Key, Value: raftItem{Value: item.Value, Version: item.Version}},
+			expiration: item.Expiration,
+		}
+	}
+	requests := make(map[string][]byte, len(snap.Requests))
+	order := make([]string, 0, len(snap.Requests))
+	for _, req := range snap.Requests {
+		requests[req.ID] = req.Result
+		order = append(order, req.ID)
+	}
+	m.data.mu.Lock()
+	defer m.data.watchers.wait()
+	defer m.data.mu.Unlock()
+	if m.data.entryMap != nil {
+		m.data.entryMap = entryMap
+		m.data.retrack()
+		m.requests, m.order = requests, order
+		m.data.watchers.notify(Event{Op: OpClear})
+	}
+}
+
+//propose 提交命令并等待应用；leader切换导致结果未知时以相同的请求标识重试，已应用的请求不会重复执行
+func (m *RaftMap) propose(ctx context.Context, cmd raftCommand) (raftResult, error) {
+	var result raftResult
+	cmd.ID = m.clientID + ":" + strconv.FormatUint(atomic.AddUint64(&m.seq, 1), 10)
+	cmd.Now = time.Now().UnixNano()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&cmd); err != nil {
+		return result, err
+	}
+	for {
+		data, err := m.node.propose(ctx, raftEntryCommand, buf.Bytes())
+		if err == nil {
+			err = gob.NewDecoder(bytes.NewReader(data)).Decode(&result)
+			return result, err
+		}
+		if err != ErrRaftLeadershipLost {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-m.exit:
+			return result, ErrRaftClosed
+		case <-time.After(m.node.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+//submit Map接口方法使用，失败时通过onError报告并返回false
+func (m *RaftMap) submit(cmd raftCommand) (raftResult, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	result, err := m.propose(ctx, cmd)
+	if err != nil {
+		if m.onError != nil {
+			m.onError(err)
+		}
+		return result, false
+	}
+	return result, true
+}
+
+//Set 写入key-val并等待提交
+func (m *RaftMap) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	_, err := m.propose(ctx, raftCommand{Op: raftOpStore, Key: key, Value: value, Expiration: expireAt(ttl, m.expiration)})
+	return err
+}
+
+//Remove 删除key并等待提交，返回被删除的值
+func (m *RaftMap) Remove(ctx context.Context, key string) (interface{}, error) {
+	result, err := m.propose(ctx, raftCommand{Op: raftOpDelete, Key: key})
+	return result.Value, err
+}
+
+func (m *RaftMap) Store(key string, value interface{}) {
+	m.StoreWithTTL(key, value, DefaultExpiration)
+}
+
+//StoreWithTTL 存储key-val并指定过期时间
+func (m *RaftMap) StoreWithTTL(key string, value interface{}, ttl time.Duration) {
+	m.submit(raftCommand{Op: raftOpStore, Key: key, Value: value, Expiration: expireAt(ttl, m.expiration)})
+}
+
+func (m *RaftMap) Load(key string) (value interface{}, ok bool) {
+	if v, ok := m.data.Load(key); ok {
+		return v.(raftItem).Value, true
+	}
+	return nil, false
+}
+
+func (m *RaftMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	result, ok := m.submit(raftCommand{Op: raftOpLoadOrStore, Key: key, Value: value, Expiration: expireAt(DefaultExpiration, m.expiration)})
+	if !ok {
+		return value, false
+	}
+	return result.Value, result.OK
+}
+
+//StoreOrCompare 读取本地值计算后以版本号比较写入，版本变化时重试
+func (m *RaftMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	for {
+		cmd := raftCommand{Op: raftOpCompareAndStore, Key: key, Value: value, Expiration: expireAt(DefaultExpiration, m.expiration)}
+		if v, ok := m.data.Load(key); ok {
+			item := v.(raftItem)
+			cmd.Version = item.Version
+			if compare != nil {
+				cmd.Value = compare(item.Value, value)
+			}
+		}
+		if result, ok := m.submit(cmd); !ok || result.OK {
+			return
+		}
+	}
+}
+
+func (m *RaftMap) Delete(key string) interface{} {
+	result, _ := m.submit(raftCommand{Op: raftOpDelete, Key: key})
+	return result.Value
+}
+
+func (m *RaftMap) Clear() []Entry {
+	result, _ := m.submit(raftCommand{Op: raftOpClear})
+	return result.Entries
+}
+
+func (m *RaftMap) Range(f func(key interface{}, value interface{}) bool) {
+	m.data.Range(func(key, value interface{}) bool {
+		return f(key, value.(raftItem).Value)
+	})
+}
+
+//Destroy 停止节点并销毁本地数据，不会将本节点移出集群
+func (m *RaftMap) Destroy() {
+	m.node.stop()
+	m.data.Destroy()
+	close(m.exit)
+}
+
+func (m *RaftMap) Size() int {
+	return m.data.Size()
+}
+
+//MemoryUsage 估算状态机当前占用的内存字节数，包括请求去重记录，不包括raft日志与快照
+func (m *RaftMap) MemoryUsage() int64 {
+	size := m.data.MemoryUsage()
+	m.data.mu.RLock()
+	defer m.data.mu.RUnlock()
+	size += mapHeaderSize
+	for id, result := range m.requests {
+		size += raftRequestEntrySize + int64(len(id)+cap(result))
+	}
+	return size
+}
+
+//TTL 返回key剩余存活时间，永不过期时返回NoExpiration，key不存在时ok为false
+func (m *RaftMap) TTL(key string) (ttl time.Duration, ok bool) {
+	return m.data.TTL(key)
+}
+
+//AddNode 将节点加入集群，需等待上一次成员变更提交
+func (m *RaftMap) AddNode(ctx context.Context, id string) error {
+	return m.changeConfig(ctx, id, true)
+}
+
+//RemoveNode 将节点移出集群，移除leader时leader在提交后退位
+func (m *RaftMap) RemoveNode(ctx context.Context, id string) error {
+	return m.changeConfig(ctx, id, false)
+}
+
+func (m *RaftMap) changeConfig(ctx context.Context, id string, add bool) error {
+	for {
+		m.node.mu.Lock()
+		if m.node.closed {
+			m.node.mu.Unlock()
+			return ErrRaftClosed
+		}
+		current := m.node.peers
+		m.node.mu.Unlock()
+		var peers []string
+		exists := false
+		for _, peer := range current {
+			if peer == id {
+				exists = true
+				if add {
+					peers = append(peers, peer)
+				}
+			} else {
+				peers = append(peers, peer)
+			}
+		}
+		if exists == add {
+			return nil
+		}
+		if add {
+			peers = append(peers, id)
+		}
+		_, err := m.node.propose(ctx, raftEntryConfig, encodePeers(peers))
+		if err != ErrRaftConfigChange {
+			return err
+		}
+		// 等待上一次变更提交后重试
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.node.cfg.HeartbeatInterval):
+		}
+	}
+}
+
+//Leader 当前已知的leader，未知时为空
+func (m *RaftMap) Leader() string {
+	leader, _, _ := m.node.status()
+	return leader
+}
+
+func (m *RaftMap) IsLeader() bool {
+	_, isLeader, _ := m.node.status()
+	return isLeader
+}
+
+//Peers 当前集群成员
+func (m *RaftMap) Peers() []string {
+	_, _, peers := m.node.status()
+	return peers
+}
@@ -0,0 +1,324 @@
+package gomap
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRaftCluster(t *testing.T, network *RaftMemoryNetwork, ids []string, snapshot int) map[string]*RaftMap {
+	nodes := map[string]*RaftMap{}
+	for _, id := range ids {
+		m, err := NewRaftMap(RaftConfig{
+			ID:                id,
+			Peers:             ids,
+			Transport:         network.Transport(id),
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+			SnapshotThreshold: snapshot,
+			GCInterval:        20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[id] = m
+	}
+	return nodes
+}
+
+func destroyRaftCluster(nodes map[string]*RaftMap) {
+	for _, m := range nodes {
+		m.Destroy()
+	}
+}
+
+//waitRaft 等待条件成立
+func waitRaft(t *testing.T, msg string, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+//waitLeader 等待指定节点中选出唯一leader
+func waitLeader(t *testing.T, nodes map[string]*RaftMap, except string) string {
+	var leader string
+	waitRaft(t, "no leader elected", func() bool {
+		count := 0
+		for id, m := range nodes {
+			if id != except && m.IsLeader() {
+				leader = id
+				count++
+			}
+		}
+		return count == 1
+	})
+	return leader
+}
+
+func TestRaftMap_Replicate(t *testing.T) {
+	nodes := newRaftCluster(t, NewRaftMemoryNetwork(), []string{"a", "b", "c"}, 0)
+	defer destroyRaftCluster(nodes)
+	leader := waitLeader(t, nodes, "")
+
+	// 任意节点写入，写入节点立即可读
+	for id, m := range nodes {
+		m.Store(id, id)
+		if v, ok := m.Load(id); !ok || v != id {
+			t.Fatal("write not visible on", id)
+		}
+	}
+	if actual, loaded := nodes[leader].LoadOrStore("a", "x"); !loaded || actual != "a" {
+		t.Fatal("LoadOrStore", actual, loaded)
+	}
+	if v := nodes["b"].Delete("c"); v != "c" {
+		t.Fatal("Delete", v)
+	}
+	for id, m := range nodes {
+		waitRaft(t, "not replicated to "+id, func() bool {
+			_, deleted := m.Load("c")
+			return m.Size() == 2 && !deleted
+		})
+	}
+	// 状态机之外还包括请求去重记录
+	if m := nodes[leader]; m.MemoryUsage() <= m.data.MemoryUsage() {
+		t.Fatal("MemoryUsage", m.MemoryUsage(), m.data.MemoryUsage())
+	}
+	if entries := nodes["c"].Clear(); len(entries) != 2 {
+		t.Fatal("Clear", entries)
+	}
+}
+
+func TestRaftMap_StoreOrCompare(t *testing.T) {
+	nodes := newRaftCluster(t, NewRaftMemoryNetwork(), []string{"a", "b", "c"}, 0)
+	defer destroyRaftCluster(nodes)
+	waitLeader(t, nodes, "")
+
+	var wg sync.WaitGroup
+	for _, m := range nodes {
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(m *RaftMap) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					m.StoreOrCompare("n", 1, func(stored, input interface{}) interface{} {
+						return stored.(int) + input.(int)
+					})
+				}
+			}(m)
+		}
+	}
+	wg.Wait()
+	for id, m := range nodes {
+		waitRaft(t, "counter not converged on "+id, func() bool {
+			v, _ := m.Load("n")
+			return v == 120
+		})
+	}
+}
+
+func TestRaftMap_LeaderFailover(t *testing.T) {
+	network := NewRaftMemoryNetwork()
+	nodes := newRaftCluster(t, network, []string{"a", "b", "c"}, 0)
+	defer destroyRaftCluster(nodes)
+	old := waitLeader(t, nodes, "")
+	nodes[old].Store("before", 1)
+
+	network.Disconnect(old)
+	leader := waitLeader(t, nodes, old)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := nodes[leader].Set(ctx, "after", 2, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	// 与多数派断开的旧leader无法提交
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel2()
+	if err := nodes[old].Set(ctx2, "lost", 3, NoExpiration); err == nil {
+		t.Fatal("minority write committed")
+	}
+
+	network.Connect(old)
+	waitRaft(t, "old leader not caught up", func() bool {
+		_, after := nodes[old].Load("after")
+		_, lost := nodes[old].Load("lost")
+		return after && !lost && !nodes[old].IsLeader()
+	})
+}
+
+func TestRaftMap_NoQuorum(t *testing.T) {
+	network := NewRaftMemoryNetwork()
+	nodes := newRaftCluster(t, network, []string{"a", "b", "c"}, 0)
+	defer destroyRaftCluster(nodes)
+	leader := waitLeader(t, nodes, "")
+	var errs []error
+	m := nodes[leader]
+	m.timeout = 200 * time.Millisecond
+	m.onError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	// 失去多数派时Map接口的写方法不panic，写入视为未执行
+	network.Disconnect(leader)
+	m.Store("a", 1)
+	if actual, loaded := m.LoadOrStore("b", 2); loaded || actual != 2 {
+		t.Fatal("LoadOrStore", actual, loaded)
+	}
+	m.StoreOrCompare("c", 3, nil)
+	if v := m.Delete("a"); v != nil {
+		t.Fatal("Delete", v)
+	}
+	if len(errs) != 4 {
+		t.Fatal("errors not reported", errs)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("uncommitted write visible")
+	}
+}
+
+func TestRaftMap_Dedup(t *testing.T) {
+	nodes := newRaftCluster(t, NewRaftMemoryNetwork(), []string{"a", "b", "c"}, 2)
+	defer destroyRaftCluster(nodes)
+	leader := waitLeader(t, nodes, "")
+	follower := "a"
+	if follower == leader {
+		follower = "b"
+	}
+	m := nodes[follower]
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// 模拟转发的提案因网络错误重试：相同标识的请求只应用一次
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(&raftCommand{ID: "retry", Op: raftOpStore, Key: "k", Value: 1, Expiration: -1})
+	if _, err := m.node.propose(ctx, raftEntryCommand, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(ctx, "k", 2, NoExpiration); err != nil {
+		t.Fatal(err)
+	}
+	// 期间生成的快照也保留了已应用的请求
+	for i := 0; i < 5; i++ {
+		m.Set(ctx, strconv.Itoa(i), i, NoExpiration)
+	}
+	if _, err := m.node.propose(ctx, raftEntryCommand, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for id, node := range nodes {
+		if v, _ := node.Load("k"); v != 2 {
+			waitRaft(t, "retried request applied twice on "+id, func() bool {
+				v, _ := node.Load("k")
+				return v == 2
+			})
+		}
+	}
+}
+
+func TestRaftMap_SnapshotAndMembership(t *testing.T) {
+	network := NewRaftMemoryNetwork()
+	nodes := newRaftCluster(t, network, []string{"a", "b", "c"}, 16)
+	defer destroyRaftCluster(nodes)
+	leader := waitLeader(t, nodes, "")
+	for i := 0; i < 100; i++ {
+		nodes[leader].Store(strconv.Itoa(i), i)
+	}
+
+	// 新节点通过快照追上
+	d, err := NewRaftMap(RaftConfig{
+		ID:                "d",
+		Transport:         network.Transport("d"),
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: 16,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes["d"] = d
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := nodes["b"].AddNode(ctx, "d"); err != nil {
+		t.Fatal(err)
+	}
+	waitRaft(t, "new node not caught up", func() bool {
+		return d.Size() == 100 && len(d.Peers()) == 4
+	})
+	if d.node.snapIndex() == 0 {
+		t.Fatal("snapshot not installed")
+	}
+
+	// 移除leader后剩余节点选出新leader
+	if err := nodes[leader].RemoveNode(ctx, leader); err != nil {
+		t.Fatal(err)
+	}
+	next := waitLeader(t, nodes, leader)
+	if next == leader {
+		t.Fatal("removed leader still leading")
+	}
+	nodes[next].Store("x", 1)
+	waitRaft(t, "write after removal not replicated", func() bool {
+		_, ok := d.Load("x")
+		return ok && len(d.Peers()) == 3
+	})
+}
+
+func TestRaftMap_Expiration(t *testing.T) {
+	nodes := newRaftCluster(t, NewRaftMemoryNetwork(), []string{"a", "b", "c"}, 0)
+	defer destroyRaftCluster(nodes)
+	waitLeader(t, nodes, "")
+	nodes["a"].StoreWithTTL("k", 1, 200*time.Millisecond)
+	if ttl, ok := nodes["a"].TTL("k"); !ok || ttl <= 0 {
+		t.Fatal("TTL", ttl, ok)
+	}
+	nodes["a"].Store("keep", 1)
+	for id, m := range nodes {
+		waitRaft(t, "expired key not deleted on "+id, func() bool {
+			return m.Size() == 1
+		})
+	}
+}
+
+func TestRaftMap_TCP(t *testing.T) {
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ln.Addr().String())
+		ln.Close()
+	}
+	nodes := map[string]*RaftMap{}
+	defer destroyRaftCluster(nodes)
+	for _, id := range ids {
+		m, err := NewRaftMap(RaftConfig{
+			ID:                id,
+			Peers:             ids,
+			Transport:         NewRaftTCPTransport(id),
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[id] = m
+	}
+	waitLeader(t, nodes, "")
+	for i, id := range ids {
+		nodes[id].Store(strconv.Itoa(i), i)
+	}
+	for id, m := range nodes {
+		waitRaft(t, "not replicated to "+id, func() bool {
+			return m.Size() == 3
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package gomap
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// RaftMemoryNetwork 进程内的raft网络，用于测试，可模拟节点断开
+	RaftMemoryNetwork struct {
+		mu       sync.RWMutex
+		handlers map[string]RaftHandler
+		down     map[string]bool
+	}
+
+	memoryTransport struct {
+		network *RaftMemoryNetwork
+		id      string
+	}
+
+	// RaftTCPTransport 基于TCP与gob编码的raft传输，节点ID即为节点地址
+	RaftTCPTransport struct {
+		addr    string
+		mu      sync.Mutex
+		ln      net.Listener
+		conns   map[net.Conn]struct{}  // 接入的连接
+		idle    map[string][]*raftConn // 发往各节点的空闲连接
+		closed  bool
+		timeout time.Duration // 拨号超时
+	}
+
+	raftConn struct {
+		conn net.Conn
+		enc  *gob.Encoder
+		dec  *gob.Decoder
+	}
+)
+
+const raftMaxIdleConns = 4
+
+var (
+	ErrRaftUnreachable     = errors.New("ErrRaftUnreachable")
+	ErrRaftTransportClosed = errors.New("ErrRaftTransportClosed")
+)
+
+func NewRaftMemoryNetwork() *RaftMemoryNetwork {
+	return &RaftMemoryNetwork{
+		handlers: map[string]RaftHandler{},
+		down:     map[string]bool{},
+	}
+}
+
+//Transport 节点id在该网络上的传输
+func (n *RaftMemoryNetwork) Transport(id string) RaftTransport {
+	return &memoryTransport{network: n, id: id}
+}
+
+//Disconnect 断开节点，收发消息均失败
+func (n *RaftMemoryNetwork) Disconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down[id] = true
+}
+
+//Connect 恢复节点
+func (n *RaftMemoryNetwork) Connect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.down, id)
+}
+
+func (t *memoryTransport) Listen(handler RaftHandler) error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.handlers[t.id] = handler
+	return nil
+}
+
+//Send 消息经过gob编解码后投递，与真实网络一样不共享内存
+func (t *memoryTransport) Send(ctx context.Context, to string, msg *RaftMessage) (*RaftMessage, error) {
+	t.network.mu.RLock()
+	handler, ok := t.network.handlers[to]
+	down := t.network.down[t.id] || t.network.down[to]
+	t.network.mu.RUnlock()
+	if !ok || down {
+		return nil, ErrRaftUnreachable
+	}
+	req, err := copyRaftMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan *RaftMessage, 1)
+	go func() {
+		done <- handler(req)
+	}()
+	select {
+	case resp := <-done:
+		t.network.mu.RLock()
+		down = t.network.down[t.id] || t.network.down[to]
+		t.network.mu.RUnlock()
+		if down {
+			return nil, ErrRaftUnreachable
+		}
+		return copyRaftMessage(resp)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *memoryTransport) Close() error {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	delete(t.network.handlers, t.id)
+	return nil
+}
+
+func copyRaftMessage(msg *RaftMessage) (*RaftMessage, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(msg); err != nil {
+		return nil, err
+	}
+	var cp RaftMessage
+	err := gob.NewDecoder(&buf).Decode(&cp)
+	return &cp, err
+}
+
+//NewRaftTCPTransport addr为本节点监听地址
+func NewRaftTCPTransport(addr string) *RaftTCPTransport {
+	return &RaftTCPTransport{
+		addr:    addr,
+		conns:   map[net.Conn]struct{}{},
+		idle:    map[string][]*raftConn{},
+		timeout: time.Second,
+	}
+}
+
+func (t *RaftTCPTransport) Listen(handler RaftHandler) error {
+	ln, err := net.Listen("tcp", t.addr)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		ln.Close()
+		return ErrRaftTransportClosed
+	}
+	t.ln = ln
+	t.mu.Unlock()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go t.serve(conn, handler)
+		}
+	}()
+	return nil
+}
+
+//Addr 实际监听地址
+func (t *RaftTCPTransport) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ln == nil {
+		return nil
+	}
+	return t.ln.Addr()
+}
+
+//serve 在一个连接上依次处理请求
+func (t *RaftTCPTransport) serve(conn net.Conn, handler RaftHandler) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		conn.Close()
+		return
+	}
+	t.conns[conn] = struct{}{}
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+	dec, enc := gob.NewDecoder(conn), gob.NewEncoder(conn)
+	for {
+		var req RaftMessage
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(handler(&req)); err != nil {
+			return
+		}
+	}
+}
+
+func (t *RaftTCPTransport) Send(ctx context.Context, to string, msg *RaftMessage) (*RaftMessage, error) {
+	c, err := t.get(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Time{})
+	}
+	var resp RaftMessage
+	if err = c.enc.Encode(msg); err == nil {
+		err = c.dec.Decode(&resp)
+	}
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	t.put(to, c)
+	return &resp, nil
+}
+
+//get 取出空闲连接，没有时新建
+func (t *RaftTCPTransport) get(ctx context.Context, to string) (*raftConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrRaftTransportClosed
+	}
+	if conns := t.idle[to]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		t.idle[to] = conns[:len(conns)-1]
+		t.mu.Unlock()
+		return c, nil
+	}
+	t.mu.Unlock()
+	dialer := net.Dialer{Timeout: t.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", to)
+	if err != nil {
+		return nil, err
+	}
+	return &raftConn{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}, nil
+}
+
+//put 归还连接，超过空闲上限时关闭
+func (t *RaftTCPTransport) put(to string, c *raftConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.idle[to]) >= raftMaxIdleConns {
+		c.conn.Close()
+		return
+	}
+	t.idle[to] = append(t.idle[to], c)
+}
+
+func (t *RaftTCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	var err error
+	if t.ln != nil {
+		err = t.ln.Close()
+	}
+	for conn := range t.conns {
+		conn.Close()
+	}
+	for to, conns := range t.idle {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+		delete(t.idle, to)
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type (
+	// tokenBucket 令牌桶，以rate每秒的速度补充令牌，最多burst个
+	tokenBucket struct {
+		rate  float64
+		burst int
+	}
+
+	bucket struct {
+		tokens float64 // 预留时可以为负
+		last   time.Time
+	}
+
+	// fixedWindow 固定窗口，按window对齐的每个窗口内最多limit次
+	fixedWindow struct {
+		max    int
+		window time.Duration
+	}
+
+	window struct {
+		start time.Time // 预留时可以是之后的窗口
+		count int
+	}
+
+	// slidingLog 滑动窗口日志，记录每次通过的时刻，任意window时长内最多limit次
+	slidingLog struct {
+		max    int
+		window time.Duration
+	}
+
+	// gcra 通用信元速率算法，相当于平滑的滑动窗口计数：每period/limit恢复1个额度，最多突发limit个。
+	// 状态只有一个理论到达时间(TAT)
+	gcra struct {
+		max      int
+		period   time.Duration
+		interval time.Duration
+	}
+)
+
+//NewTokenBucket 令牌桶限流，每秒补充rate个令牌，最多积累burst个，rate与burst需大于0
+func NewTokenBucket(rate float64, burst int) *Limiter {
+	if !(rate > 0) || math.IsInf(rate, 1) || burst <= 0 {
+		panic(ErrInvalidLimit)
+	}
+	return newLimiter(&tokenBucket{rate: rate, burst: burst})
+}
+
+//NewFixedWindow 固定窗口限流，每个window内最多limit次，窗口按时间对齐，窗口交界处最多可能通过2*limit次；limit与window需大于0
+func NewFixedWindow(limit int, window time.Duration) *Limiter {
+	if limit <= 0 || window <= 0 {
+		panic(ErrInvalidLimit)
+	}
+	return newLimiter(&fixedWindow{max: limit, window: window})
+}
+
+//NewSlidingLog 滑动窗口日志限流，任意window时长内最多limit次，精确但每个key需要记录最多limit个时刻；limit与window需大于0
+func NewSlidingLog(limit int, window time.Duration) *Limiter {
+	if limit <= 0 || window <= 0 {
+		panic(ErrInvalidLimit)
+	}
+	return newLimiter(&slidingLog{max: limit, window: window})
+}
+
+//NewGCRA 滑动窗口计数限流，每period最多limit次且均匀恢复，每个key只记录一个时刻；limit需大于0，period/limit需至少1ns
+func NewGCRA(limit int, period time.Duration) *Limiter {
+	if limit <= 0 || period/time.Duration(limit) <= 0 {
+		panic(ErrInvalidLimit)
+	}
+	return newLimiter(&gcra{max: limit, period: period, interval: period / time.Duration(limit)})
+}
+
+//durationOf 秒数转换为时长，向上取整，超出范围时为InfDuration
+func durationOf(s float64) time.Duration {
+	d := math.Ceil(s * float64(time.Second))
+	if d >= float64(InfDuration) {
+		return InfDuration
+	}
+	return time.Duration(d)
+}
+
+//fill 补充令牌至now
+func (b *tokenBucket) fill(state interface{}, now time.Time) bucket {
+	s, ok := state.(bucket)
+	if !ok {
+		return bucket{tokens: float64(b.burst), last: now}
+	}
+	if now.After(s.last) {
+		s.tokens = math.Min(float64(b.burst), s.tokens+now.Sub(s.last).Seconds()*b.rate)
+		s.last = now
+	}
+	return s
+}
+
+func (b *tokenBucket) take(state interface{}, now time.Time, n int) (interface{}, time.Time) {
+	s := b.fill(state, now)
+	s.tokens -= float64(n)
+	if s.tokens >= 0 {
+		return s, now
+	}
+	return s, now.Add(durationOf(-s.tokens / b.rate))
+}
+
+func (b *tokenBucket) refund(state interface{}, now, at time.Time, n int) interface{} {
+	s := b.fill(state, now)
+	s.tokens = math.Min(float64(b.burst), s.tokens+float64(n))
+	return s
+}
+
+func (b *tokenBucket) status(state interface{}, now time.Time) (int, time.Time) {
+	s := b.fill(state, now)
+	remaining := int(math.Max(0, math.Floor(s.tokens)))
+	return remaining, now.Add(durationOf((float64(b.burst) - s.tokens) / b.rate))
+}
+
+func (b *tokenBucket) limit() int {
+	return b.burst
+}
+
+//current 当前窗口，之前窗口的状态视为不存在
+func (w *fixedWindow) current(state interface{}, now time.Time) window {
+	start := now.Truncate(w.window)
+	s, ok := state.(window)
+	if !ok || s.start.Before(start) {
+		return window{start: start}
+	}
+	return s
+}
+
+func (w *fixedWindow) take(state interface{}, now time.Time, n int) (interface{}, time.Time) {
+	s := w.current(state, now)
+	if s.count+n > w.max {
+		s = window{start: s.start.Add(w.window)}
+	}
+	s.count += n
+	return s, s.start
+}
+
+func (w *fixedWindow) refund(state interface{}, now, at time.Time, n int) interface{} {
+	s := w.current(state, now)
+	if s.start.Equal(at) {
+		s.count -= n
+		if s.count < 0 {
+			s.count = 0
+		}
+	}
+	return s
+}
+
+func (w *fixedWindow) status(state interface{}, now time.Time) (int, time.Time) {
+	s := w.current(state, now)
+	remaining := w.max - s.count
+	if s.start.After(now) || remaining < 0 {
+		remaining = 0
+	}
+	return remaining, s.start.Add(w.window)
+}
+
+func (w *fixedWindow) limit() int {
+	return w.max
+}
+
+//prune 复制并去掉已滑出窗口的时刻
+func (w *slidingLog) prune(state interface{}, now time.Time) []int64 {
+	log, _ := state.([]int64)
+	cut := now.Add(-w.window).UnixNano()
+	i := sort.Search(len(log), func(i int) bool { return log[i] > cut })
+	return append([]int64(nil), log[i:]...)
+}
+
+func (w *slidingLog) take(state interface{}, now time.Time, n int) (interface{}, time.Time) {
+	log := w.prune(state, now)
+	at := now.UnixNano()
+	// 需要等到第k早的记录滑出窗口
+	if k := len(log) + n - w.max; k > 0 {
+		if t := log[k-1] + int64(w.window); t > at {
+			at = t
+		}
+	}
+	i := sort.Search(len(log), func(i int) bool { return log[i] > at })
+	next := make([]int64, 0, len(log)+n)
+	next = append(next, log[:i]...)
+	for j := 0; j < n; j++ {
+		next = append(next, at)
+	}
+	next = append(next, log[i:]...)
+	return next, time.Unix(0, at)
+}
+
+func (w *slidingLog) refund(state interface{}, now, at time.Time, n int) interface{} {
+	log := w.prune(state, now)
+	t := at.UnixNano()
+	next := log[:0]
+	for _, v := range log {
+		if v == t && n > 0 {
+			n--
+			continue
+		}
+		next = append(next, v)
+	}
+	return next
+}
+
+func (w *slidingLog) status(state interface{}, now time.Time) (int, time.Time) {
+	log := w.prune(state, now)
+	remaining := w.max - len(log)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if len(log) == 0 {
+		return remaining, now
+	}
+	return remaining, time.Unix(0, log[len(log)-1]).Add(w.window)
+}
+
+func (w *slidingLog) limit() int {
+	return w.max
+}
+
+//tat 理论到达时间，不早于now
+func (g *gcra) tat(state interface{}, now time.Time) time.Time {
+	t, ok := state.(time.Time)
+	if !ok || t.Before(now) {
+		return now
+	}
+	return t
+}
+
+func (g *gcra) take(state interface{}, now time.Time, n int) (interface{}, time.Time) {
+	next := g.tat(state, now).Add(time.Duration(n) * g.interval)
+	return next, next.Add(-g.period)
+}
+
+func (g *gcra) refund(state interface{}, now, at time.Time, n int) interface{} {
+	t := g.tat(state, now).Add(-time.Duration(n) * g.interval)
+	if t.Before(now) {
+		t = now
+	}
+	return t
+}
+
+func (g *gcra) status(state interface{}, now time.Time) (int, time.Time) {
+	t := g.tat(state, now)
+	remaining := int((g.period - t.Sub(now)) / g.interval)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, t
+}
+
+func (g *gcra) limit() int {
+	return g.max
+}
@@ -0,0 +1,234 @@
+//Package ratelimit 提供按key限流的令牌桶、固定窗口、滑动窗口日志与GCRA（滑动窗口计数）限流器。
+// 每个key的状态存储在gomap.TTLMap中，额度完全恢复后状态自动过期，空闲key不占用内存。
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type (
+	// Result 一次限流判断的结果，可用于设置HTTP响应头
+	Result struct {
+		Allowed    bool          // 是否立即通过
+		Limit      int           // 额度上限
+		Remaining  int           // 剩余额度
+		ResetAfter time.Duration // 额度完全恢复所需时间
+		RetryAfter time.Duration // 未通过时距离可以通过的时间，n超过额度上限时为InfDuration
+	}
+
+	// Limiter 按key限流，所有操作在状态map的锁内原子完成
+	Limiter struct {
+		m   *gomap.TTLMap
+		alg algorithm
+		now func() time.Time
+	}
+
+	// Reservation 预留的额度，需等待Delay后再执行操作，不再需要时可以Cancel归还
+	Reservation struct {
+		l   *Limiter
+		key string
+		n   int
+		ok  bool
+		at  time.Time // 额度可用的时刻
+	}
+
+	// algorithm 限流算法，state为key当前状态，不存在时为nil。状态只在map锁内读写
+	algorithm interface {
+		// take 消耗n个额度后的状态，以及额度可用的时刻，at不晚于now时可以立即通过
+		take(state interface{}, now time.Time, n int) (next interface{}, at time.Time)
+		// refund 归还之前预留在at时刻的n个额度
+		refund(state interface{}, now, at time.Time, n int) interface{}
+		// status 剩余额度以及额度完全恢复的时刻，完全恢复后的状态与不存在时相同
+		status(state interface{}, now time.Time) (remaining int, reset time.Time)
+		limit() int
+	}
+)
+
+//InfDuration 永远无法满足时的等待时间
+const InfDuration = time.Duration(math.MaxInt64)
+
+const gcInterval = time.Minute
+
+var (
+	ErrExceedsLimit = errors.New("ratelimit: n exceeds limit")
+	ErrInvalidLimit = errors.New("ratelimit: invalid limit") // 构造限流器的参数无效，或n为负数
+)
+
+func newLimiter(alg algorithm) *Limiter {
+	return &Limiter{
+		m:   gomap.NewTTLMap(gomap.NoExpiration, gcInterval, false),
+		alg: alg,
+		now: time.Now,
+	}
+}
+
+//Allow 消耗1个额度，额度不足时返回false且不消耗
+func (l *Limiter) Allow(key string) bool {
+	return l.AllowN(key, 1).Allowed
+}
+
+//AllowN 消耗n个额度，额度不足时不消耗；n为负数时panic
+func (l *Limiter) AllowN(key string, n int) Result {
+	r, _ := l.take(key, n, false)
+	return r
+}
+
+//Status 查询剩余额度，不消耗
+func (l *Limiter) Status(key string) Result {
+	r, _ := l.take(key, 0, false)
+	return r
+}
+
+//Reserve 预留1个额度
+func (l *Limiter) Reserve(key string) *Reservation {
+	return l.ReserveN(key, 1)
+}
+
+//ReserveN 预留n个额度，额度不足时预支之后的额度，n超过额度上限时预留失败；n为负数时panic
+func (l *Limiter) ReserveN(key string, n int) *Reservation {
+	r, at := l.take(key, n, true)
+	return &Reservation{l: l, key: key, n: n, ok: r.RetryAfter != InfDuration, at: at}
+}
+
+//Wait 阻塞直到获得1个额度
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+//WaitN 阻塞直到获得n个额度；ctx结束或在ctx截止前无法获得时归还预留并返回错误
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := l.ReserveN(key, n)
+	if !r.OK() {
+		return ErrExceedsLimit
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(r.at) {
+		r.Cancel()
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+//take 在map锁内消耗n个额度，reserve为true时额度不足也消耗，返回结果与额度可用的时刻
+func (l *Limiter) take(key string, n int, reserve bool) (r Result, at time.Time) {
+	if n < 0 {
+		panic(ErrInvalidLimit)
+	}
+	now := l.now()
+	r.Limit = l.alg.limit()
+	l.m.Update(key, func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		state, store := stored, false
+		switch {
+		case n > r.Limit:
+			r.RetryAfter = InfDuration
+		case n > 0:
+			next, a := l.alg.take(state, now, n)
+			at, r.Allowed = a, !a.After(now)
+			if r.Allowed || reserve {
+				state, store = next, true
+			}
+			if !r.Allowed {
+				r.RetryAfter = a.Sub(now)
+			}
+		}
+		remaining, reset := l.alg.status(state, now)
+		r.Remaining, r.ResetAfter = remaining, reset.Sub(now)
+		if n == 0 {
+			r.Allowed = remaining > 0
+		}
+		return state, expiration(r.ResetAfter), store
+	})
+	if r.ResetAfter < 0 {
+		r.ResetAfter = 0
+	}
+	return r, at
+}
+
+//expiration 状态的存活时间，需大于0，永远无法恢复时不过期
+func expiration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return time.Nanosecond
+	}
+	if d == InfDuration {
+		return gomap.NoExpiration
+	}
+	return d
+}
+
+//Destroy 销毁限流器
+func (l *Limiter) Destroy() {
+	l.m.Destroy()
+}
+
+//OK 是否预留成功
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+//Delay 距离额度可用还需等待的时间，预留失败时为InfDuration
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	if d := r.at.Sub(r.l.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+//Cancel 归还尚未到可用时刻的预留额度，已可用或已归还的预留不会再归还
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.ok = false
+	now := r.l.now()
+	if !r.at.After(now) {
+		return
+	}
+	alg := r.l.alg
+	r.l.m.Update(r.key, func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if !loaded {
+			return nil, 0, false
+		}
+		next := alg.refund(stored, now, r.at, r.n)
+		_, reset := alg.status(next, now)
+		return next, expiration(reset.Sub(now)), true
+	})
+}
+
+//Header 设置X-RateLimit-Limit、X-RateLimit-Remaining、X-RateLimit-Reset（秒），未通过时设置Retry-After（秒）
+func (r Result) Header(h http.Header) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(seconds(r.ResetAfter), 10))
+	if !r.Allowed && r.RetryAfter != InfDuration {
+		h.Set("Retry-After", strconv.FormatInt(seconds(r.RetryAfter), 10))
+	}
+}
+
+//seconds 向上取整的秒数
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
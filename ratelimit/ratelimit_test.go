@@ -0,0 +1,236 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+//clock 可手动推进的时钟
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) add(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func withClock(l *Limiter) (*Limiter, *clock) {
+	c := &clock{t: time.Unix(1700000000, 0)}
+	l.now = c.now
+	return l, c
+}
+
+//allowed 连续调用Allow直到失败，返回通过的次数
+func allowed(l *Limiter, key string) int {
+	n := 0
+	for l.Allow(key) {
+		n++
+	}
+	return n
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, c := withClock(NewTokenBucket(10, 5))
+	defer l.Destroy()
+	if n := allowed(l, "a"); n != 5 {
+		t.Fatal("burst", n)
+	}
+	r := l.AllowN("a", 1)
+	if r.Allowed || r.Remaining != 0 || r.RetryAfter != 100*time.Millisecond || r.ResetAfter != 500*time.Millisecond {
+		t.Fatal("unexpected result", r)
+	}
+	c.add(300 * time.Millisecond)
+	if n := allowed(l, "a"); n != 3 {
+		t.Fatal("refill", n)
+	}
+	if n := allowed(l, "b"); n != 5 {
+		t.Fatal("keys should be independent", n)
+	}
+	if r := l.AllowN("a", 6); r.Allowed || r.RetryAfter != InfDuration {
+		t.Fatal("n exceeds burst", r)
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	l, c := withClock(NewFixedWindow(3, time.Second))
+	defer l.Destroy()
+	c.add(500 * time.Millisecond)
+	if n := allowed(l, "a"); n != 3 {
+		t.Fatal("limit", n)
+	}
+	r := l.Status("a")
+	if r.Allowed || r.Remaining != 0 || r.ResetAfter != 500*time.Millisecond {
+		t.Fatal("unexpected status", r)
+	}
+	c.add(500 * time.Millisecond)
+	if n := allowed(l, "a"); n != 3 {
+		t.Fatal("next window", n)
+	}
+}
+
+func TestSlidingLog(t *testing.T) {
+	l, c := withClock(NewSlidingLog(3, time.Second))
+	defer l.Destroy()
+	for i := 0; i < 3; i++ {
+		if !l.Allow("a") {
+			t.Fatal("should allow", i)
+		}
+		c.add(400 * time.Millisecond)
+	}
+	// 时刻1.2s，窗口(0.2s,1.2s]内有2次
+	if r := l.AllowN("a", 1); !r.Allowed || r.Remaining != 0 {
+		t.Fatal("first entry should slide out", r)
+	}
+	r := l.AllowN("a", 1)
+	if r.Allowed || r.RetryAfter != 200*time.Millisecond {
+		t.Fatal("unexpected result", r)
+	}
+	c.add(200 * time.Millisecond)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("one entry should slide out")
+	}
+}
+
+func TestGCRA(t *testing.T) {
+	l, c := withClock(NewGCRA(10, time.Second))
+	defer l.Destroy()
+	if n := allowed(l, "a"); n != 10 {
+		t.Fatal("burst", n)
+	}
+	r := l.AllowN("a", 1)
+	if r.Allowed || r.RetryAfter != 100*time.Millisecond || r.ResetAfter != time.Second {
+		t.Fatal("unexpected result", r)
+	}
+	c.add(250 * time.Millisecond)
+	if r := l.Status("a"); r.Remaining != 2 {
+		t.Fatal("remaining", r)
+	}
+	if n := allowed(l, "a"); n != 2 {
+		t.Fatal("refill", n)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	for name, l := range map[string]*Limiter{
+		"TokenBucket": NewTokenBucket(10, 1),
+		"FixedWindow": NewFixedWindow(1, 100*time.Millisecond),
+		"SlidingLog":  NewSlidingLog(1, 100*time.Millisecond),
+		"GCRA":        NewGCRA(1, 100*time.Millisecond),
+	} {
+		l, c := withClock(l)
+		c.t = c.t.Truncate(time.Second)
+		l.Allow("a")
+		r1 := l.Reserve("a")
+		r2 := l.Reserve("a")
+		if !r1.OK() || r1.Delay() != 100*time.Millisecond || r2.Delay() != 200*time.Millisecond {
+			t.Fatal(name, "unexpected delay", r1.Delay(), r2.Delay())
+		}
+		if r := l.ReserveN("a", 2); r.OK() || r.Delay() != InfDuration {
+			t.Fatal(name, "n exceeds limit")
+		}
+		// 归还最后的预留后，下一个预留复用该额度
+		r2.Cancel()
+		if r := l.Reserve("a"); r.Delay() != 200*time.Millisecond {
+			t.Fatal(name, "Cancel should refund", r.Delay())
+		}
+		c.add(100 * time.Millisecond)
+		if r1.Delay() != 0 {
+			t.Fatal(name, "reservation should be ready")
+		}
+		l.Destroy()
+	}
+}
+
+func TestWait(t *testing.T) {
+	l := NewGCRA(100, time.Second)
+	defer l.Destroy()
+	for i := 0; i < 100; i++ {
+		l.Allow("a")
+	}
+	start := time.Now()
+	if err := l.Wait(context.Background(), "a"); err != nil || time.Since(start) < 5*time.Millisecond {
+		t.Fatal("Wait", err, time.Since(start))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	l.ReserveN("a", 50)
+	if err := l.Wait(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatal("Wait should fail before deadline", err)
+	}
+	if err := l.WaitN(context.Background(), "a", 101); err != ErrExceedsLimit {
+		t.Fatal("WaitN", err)
+	}
+}
+
+func TestIdleKeyExpires(t *testing.T) {
+	l := NewTokenBucket(1000, 10)
+	defer l.Destroy()
+	l.Allow("a")
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := l.m.Peek("a"); ok {
+		t.Fatal("refilled state should expire")
+	}
+}
+
+func TestInvalidLimit(t *testing.T) {
+	for name, f := range map[string]func() *Limiter{
+		"rate 0":        func() *Limiter { return NewTokenBucket(0, 1) },
+		"rate NaN":      func() *Limiter { return NewTokenBucket(math.NaN(), 1) },
+		"burst 0":       func() *Limiter { return NewTokenBucket(1, 0) },
+		"window 0":      func() *Limiter { return NewFixedWindow(1, 0) },
+		"log window -1": func() *Limiter { return NewSlidingLog(1, -1) },
+		"gcra limit 0":  func() *Limiter { return NewGCRA(0, time.Second) },
+		"gcra interval": func() *Limiter { return NewGCRA(10, 5) },
+		"allow -1":      func() *Limiter { NewFixedWindow(1, time.Second).AllowN("a", -1); return nil },
+		"reserve -1":    func() *Limiter { NewGCRA(1, time.Second).ReserveN("a", -1); return nil },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrInvalidLimit {
+					t.Fatal(name, "should panic", r)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestTokenBucket_SlowRate(t *testing.T) {
+	l, c := withClock(NewTokenBucket(1e-12, 1))
+	defer l.Destroy()
+	if !l.Allow("a") {
+		t.Fatal("burst")
+	}
+	// 等待时间超出Duration范围时饱和为InfDuration而不是溢出为负数
+	if r := l.AllowN("a", 1); r.Allowed || r.RetryAfter != InfDuration || r.ResetAfter != InfDuration {
+		t.Fatal("overflowed wait", r)
+	}
+	if r := l.ReserveN("a", 1); r.OK() || r.Delay() != InfDuration {
+		t.Fatal("unsatisfiable reservation", r.Delay())
+	}
+	c.add(time.Hour)
+	if l.Allow("a") {
+		t.Fatal("refilled too fast")
+	}
+}
+
+func TestResult_Header(t *testing.T) {
+	h := http.Header{}
+	Result{Limit: 10, Remaining: 0, ResetAfter: 1500 * time.Millisecond, RetryAfter: 100 * time.Millisecond}.Header(h)
+	if h.Get("X-RateLimit-Limit") != "10" || h.Get("X-RateLimit-Remaining") != "0" || h.Get("X-RateLimit-Reset") != "2" || h.Get("Retry-After") != "1" {
+		t.Fatal("unexpected header", h)
+	}
+}
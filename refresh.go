@@ -0,0 +1,98 @@
+package gomap
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// RefreshFunc 重新加载key的值
+	RefreshFunc func(key string) (value interface{}, err error)
+
+	// RefreshConfig 软过期与提前刷新配置
+	RefreshConfig struct {
+		SoftTTL      time.Duration               // 写入超过该时间后数据视为陈旧，Load仍返回旧值并触发异步刷新，<=0时不启用
+		RefreshAhead time.Duration               // Load时剩余存活时间小于该值则触发异步刷新，<=0时不启用
+		Loader       RefreshFunc                 // 刷新使用的加载函数
+		OnError      func(key string, err error) // 加载失败时回调，旧值保留至硬过期
+	}
+
+	// refresher map上的刷新器，同一key同一时间只有一次刷新
+	refresher struct {
+		softTTL time.Duration
+		ahead   time.Duration
+		loader  RefreshFunc
+		onError func(key string, err error)
+		mu      sync.Mutex
+		running map[string]bool // 正在刷新的key，值为刷新期间是否被写入
+	}
+)
+
+func newRefresher(cfg RefreshConfig) *refresher {
+	if cfg.Loader == nil || (cfg.SoftTTL <= 0 && cfg.RefreshAhead <= 0) {
+		return nil
+	}
+	return &refresher{
+		softTTL: cfg.SoftTTL,
+		ahead:   cfg.RefreshAhead,
+		loader:  cfg.Loader,
+		onError: cfg.OnError,
+		running: map[string]bool{},
+	}
+}
+
+//staleAt 新写入数据的软过期时间戳，未启用时为0
+func (r *refresher) staleAt() int64 {
+	if r == nil || r.softTTL <= 0 {
+		return 0
+	}
+	return time.Now().Add(r.softTTL).UnixNano()
+}
+
+//written key被写入时调用，需持有map锁；刷新期间被写入的key丢弃加载结果，避免旧数据覆盖新写入
+func (r *refresher) written(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[key]; ok {
+		r.running[key] = true
+	}
+}
+
+//check 数据已陈旧或即将过期时异步刷新，store在持有map锁时调用fresh判断加载结果是否仍可写入
+func (r *refresher) check(key string, item *ttlEntry, store func(key string, value interface{}, fresh func() bool)) {
+	if r == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	stale := item.staleAt > 0 && now > item.staleAt
+	ahead := r.ahead > 0 && item.expiration > 0 && item.expiration-now < int64(r.ahead)
+	if !stale && !ahead {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[key]; ok {
+		return
+	}
+	r.running[key] = false
+	go func() {
+		value, err := r.loader(key)
+		if err != nil {
+			if r.onError != nil {
+				r.onError(key, err)
+			}
+		} else {
+			store(key, value, func() bool {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				return !r.running[key]
+			})
+		}
+		r.mu.Lock()
+		delete(r.running, key)
+		r.mu.Unlock()
+	}()
+}
@@ -0,0 +1,167 @@
+package gomap
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLMap_SoftTTL(t *testing.T) {
+	m := NewTTLMap(200*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	var loads int32
+	release := make(chan bool)
+	m.SetRefresh(RefreshConfig{
+		SoftTTL: 30 * time.Millisecond,
+		Loader: func(key string) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return "fresh", nil
+		},
+	})
+	m.Store("k", "stale")
+	if v, _ := m.Load("k"); v != "stale" || atomic.LoadInt32(&loads) != 0 {
+		t.Fatal("refreshed before soft ttl", v)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// 软过期后返回旧值，并发读取只触发一次刷新
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Load("k"); !ok || v != "stale" {
+			t.Fatal("stale value not served", v, ok)
+		}
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatal("refresh count", n)
+	}
+	if v, _ := m.Load("k"); v != "fresh" {
+		t.Fatal("not refreshed", v)
+	}
+	// 硬过期后数据不再返回
+	time.Sleep(250 * time.Millisecond)
+	if _, ok := m.Load("k"); ok {
+		t.Fatal("not expired")
+	}
+}
+
+func TestLinkedTTLMap_SoftTTL(t *testing.T) {
+	m := NewLinkedTTLMap(200*time.Millisecond, 10*time.Millisecond, false)
+	defer m.Destroy()
+	var loads int32
+	release := make(chan bool)
+	m.SetRefresh(RefreshConfig{
+		SoftTTL: 30 * time.Millisecond,
+		Loader: func(key string) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			<-release
+			return "fresh", nil
+		},
+	})
+	m.Store("k", "stale")
+	if v, _ := m.Load("k"); v != "stale" || atomic.LoadInt32(&loads) != 0 {
+		t.Fatal("refreshed before soft ttl", v)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// 软过期后返回旧值，并发读取只触发一次刷新
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Load("k"); !ok || v != "stale" {
+			t.Fatal("stale value not served", v, ok)
+		}
+	}
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatal("refresh count", n)
+	}
+	if v, _ := m.Load("k"); v != "fresh" {
+		t.Fatal("not refreshed", v)
+	}
+	// 硬过期后数据不再返回
+	time.Sleep(250 * time.Millisecond)
+	if _, ok := m.Load("k"); ok {
+		t.Fatal("not expired")
+	}
+}
+
+func TestTTLMap_RefreshStale(t *testing.T) {
+	m := NewTTLMap(time.Second, 10*time.Millisecond, false)
+	defer m.Destroy()
+	loading := make(chan bool)
+	release := make(chan bool)
+	m.SetRefresh(RefreshConfig{
+		SoftTTL: 20 * time.Millisecond,
+		Loader: func(key string) (interface{}, error) {
+			loading <- true
+			<-release
+			return "loaded", nil
+		},
+	})
+	m.Store("k", "old")
+	time.Sleep(30 * time.Millisecond)
+	m.Load("k")
+	<-loading
+	// 刷新期间写入的新值不被加载结果覆盖
+	m.Store("k", "new")
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := m.Load("k"); v != "new" {
+		t.Fatal("newer value overwritten by refresh", v)
+	}
+}
+
+func TestLinkedTTLMap_RefreshStale(t *testing.T) {
+	m := NewLinkedTTLMap(time.Second, 10*time.Millisecond, false)
+	defer m.Destroy()
+	loading := make(chan bool)
+	release := make(chan bool)
+	m.SetRefresh(RefreshConfig{
+		SoftTTL: 20 * time.Millisecond,
+		Loader: func(key string) (interface{}, error) {
+			loading <- true
+			<-release
+			return "loaded", nil
+		},
+	})
+	m.Store("k", "old")
+	time.Sleep(30 * time.Millisecond)
+	m.Load("k")
+	<-loading
+	// 刷新期间写入的新值不被加载结果覆盖
+	m.Store("k", "new")
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := m.Load("k"); v != "new" {
+		t.Fatal("newer value overwritten by refresh", v)
+	}
+}
+
+func TestTTLMap_RefreshAhead(t *testing.T) {
+	m := NewTTLMap(100*time.Millisecond, 10*time.Millisecond, true)
+	defer m.Destroy()
+	var failed int32
+	m.SetRefresh(RefreshConfig{
+		RefreshAhead: 50 * time.Millisecond,
+		Loader: func(key string) (interface{}, error) {
+			if atomic.AddInt32(&failed, 1) == 1 {
+				return nil, errors.New("unavailable")
+			}
+			return 2, nil
+		},
+		OnError: func(key string, err error) {},
+	})
+	m.Store("k", 1)
+	m.Load("k")
+	time.Sleep(60 * time.Millisecond)
+	// 第一次刷新失败保留旧值，续租后再次接近过期时重试
+	if v, _ := m.Load("k"); v != 1 {
+		t.Fatal("value lost on refresh error", v)
+	}
+	time.Sleep(60 * time.Millisecond)
+	m.Load("k")
+	time.Sleep(20 * time.Millisecond)
+	if v, _ := m.Load("k"); v != 2 {
+		t.Fatal("not refreshed ahead", v)
+	}
+}
@@ -0,0 +1,525 @@
+package gomap
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// 主从复制：主节点订阅map的变更并写入有界的复制日志，副本连接后先接收全量快照，
+// 之后按偏移增量同步；断线重连时若日志中仍保留副本所需的偏移则只补发缺失部分。
+// 值通过encoding/gob传输，自定义类型需要先调用gob.Register注册。
+// 续租事件按key合并，每隔replicationRenewDelay以当前值与过期时间写入日志，避免renewOnLoad的map每次读取都产生复制流量；
+// 订阅缓冲溢出时不阻塞写入方，而是更换runID并断开所有副本，由副本重新全量同步。
+
+type (
+	Primary struct {
+		m         ExpirableMap              // 数据源
+		runID     string                    // 本次运行标识，副本据此判断偏移是否可续传，事件丢失后更换
+		backlog   int                       // 复制日志保留的记录数
+		mu        sync.Mutex                // 锁
+		cond      *sync.Cond                // 日志追加通知
+		log       []replicationRecord       // 复制日志
+		first     int64                     // log[0]的偏移
+		next      int64                     // 下一条记录的偏移
+		renewed   map[string]struct{}       // 待写入日志的续租key
+		closed    bool                      // 是否已关闭
+		cancel    context.CancelFunc        // 取消变更订阅
+		listeners map[net.Listener]struct{} // 监听器
+		conns     map[net.Conn]struct{}     // 副本连接
+		wg        sync.WaitGroup            // 连接处理协程
+	}
+
+	Replica struct {
+		m         ExpirableMap // 本地数据，可直接读取
+		addr      string       // 主节点地址
+		mu        sync.Mutex   // 锁
+		runID     string       // 主节点运行标识
+		offset    int64        // 已应用的偏移
+		conn      net.Conn     // 当前连接
+		closed    bool         // 是否已关闭
+		exit      chan bool    // 退出标志
+		done      chan bool    // 同步协程已退出
+		fullSyncs int          // 全量同步次数
+	}
+
+	replicationRecord struct {
+		Offset   int64
+		Op       Op
+		Key      string
+		Value    interface{}
+		ExpireAt int64 // 过期时间戳，0表示永不过期
+	}
+
+	replicationHello struct {
+		RunID  string
+		Offset int64
+	}
+
+	replicationMessage struct {
+		Type   int
+		RunID  string
+		Offset int64
+		Record replicationRecord
+	}
+)
+
+const (
+	msgFullSync    = iota + 1 // 开始全量同步，Offset为快照对应的偏移
+	msgSnapshot               // 快照中的一条数据
+	msgSnapshotEnd            // 快照结束
+	msgContinue               // 增量续传，Offset为续传起点
+	msgRecord                 // 复制日志记录
+)
+
+const (
+	defaultBacklog        = 1 << 16
+	replicationTimeout    = 10 * time.Second
+	replicaMinBackoff     = 50 * time.Millisecond
+	replicaMaxBackoff     = 5 * time.Second
+	replicationWatchBuf   = 8192
+	replicationRenewDelay = 100 * time.Millisecond
+)
+
+var ErrReplicationClosed = errors.New("ErrReplicationClosed")
+
+//NewPrimary 创建主节点，backlog为复制日志保留的记录数，<=0时使用默认值
+func NewPrimary(m ExpirableMap, backlog int) *Primary {
+	if backlog <= 0 {
+		backlog = defaultBacklog
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Primary{
+		m:         m,
+		runID:     newRunID(),
+		backlog:   backlog,
+		first:     1,
+		next:      1,
+		renewed:   map[string]struct{}{},
+		cancel:    cancel,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.record(ctx, p.watch(ctx))
+	return p
+}
+
+func newRunID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//watch 订阅map的变更，缓冲满时关闭订阅而不是阻塞写入方
+func (p *Primary) watch(ctx context.Context) <-chan Event {
+	return p.m.Watch(ctx, WatchFilter{}, WatchBuffer(replicationWatchBuf), WatchOverflow(OverflowClose))
+}
+
+//record 将变更事件写入复制日志，续租事件合并后定期写入
+func (p *Primary) record(ctx context.Context, events <-chan Event) {
+	defer func() {
+		// map已销毁时停止记录
+		if err := recover(); err != nil && fmt.Sprint(err) != ErrMapDestroyed {
+			panic(err)
+		}
+	}()
+	ticker := time.NewTicker(replicationRenewDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Err != nil {
+				// 缓冲溢出导致事件丢失，重新订阅后要求所有副本全量同步
+				events = p.watch(ctx)
+				p.resync()
+				continue
+			}
+			p.mu.Lock()
+			switch ev.Op {
+			case OpRenew:
+				p.renewed[ev.Key] = struct{}{}
+				p.mu.Unlock()
+				continue
+			case OpClear:
+				p.renewed = map[string]struct{}{}
+			default:
+				delete(p.renewed, ev.Key)
+			}
+			rec := replicationRecord{Op: ev.Op, Key: ev.Key}
+			if ev.Op == OpStore {
+				rec.Value = ev.New
+				if !ev.ExpireAt.IsZero() {
+					rec.ExpireAt = ev.ExpireAt.UnixNano()
+				}
+			}
+			p.append(rec)
+			p.mu.Unlock()
+		case <-ticker.C:
+			p.flushRenewed()
+		}
+	}
+}
+
+//flushRenewed 将合并的续租以key当前的值与过期时间写入日志，读取不会再次续租
+func (p *Primary) flushRenewed() {
+	p.mu.Lock()
+	keys := p.renewed
+	p.renewed = map[string]struct{}{}
+	p.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	var records []replicationRecord
+	for key := range keys {
+		value, ok := p.m.Peek(key)
+		if !ok {
+			continue
+		}
+		expireAt, ok := p.m.ExpiresAt(key)
+		if !ok {
+			continue
+		}
+		rec := replicationRecord{Op: OpRenew, Key: key, Value: value}
+		if !expireAt.IsZero() {
+			rec.ExpireAt = expireAt.UnixNano()
+		}
+		records = append(records, rec)
+	}
+	// 读取后key若又有变更，对应事件会在之后写入日志，副本最终仍与主节点一致
+	p.mu.Lock()
+	for _, rec := range records {
+		p.append(rec)
+	}
+	p.mu.Unlock()
+}
+
+//append 追加一条记录，需持有锁
+func (p *Primary) append(rec replicationRecord) {
+	rec.Offset = p.next
+	p.next++
+	p.log = append(p.log, rec)
+	if len(p.log) > p.backlog {
+		n := len(p.log) - p.backlog
+		p.log = append([]replicationRecord(nil), p.log[n:]...)
+		p.first += int64(n)
+	}
+	p.cond.Broadcast()
+}
+
+//resync 丢弃复制日志并更换runID，已连接的副本断开后重新全量同步
+func (p *Primary) resync() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runID = newRunID()
+	p.log = nil
+	p.first = p.next
+	p.renewed = map[string]struct{}{}
+	p.cond.Broadcast()
+}
+
+//Offset 最新记录的偏移
+func (p *Primary) Offset() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next - 1
+}
+
+func (p *Primary) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+//Serve 在ln上接受副本连接，直到ln出错或主节点关闭
+func (p *Primary) Serve(ln net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		ln.Close()
+		return ErrReplicationClosed
+	}
+	p.listeners[ln] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, ln)
+		p.mu.Unlock()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrReplicationClosed
+			}
+			return err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return ErrReplicationClosed
+		}
+		p.conns[conn] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go p.serveReplica(conn)
+	}
+}
+
+//Close 停止复制并断开所有副本，不会销毁map
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrReplicationClosed
+	}
+	p.closed = true
+	p.cancel()
+	for ln := range p.listeners {
+		ln.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+func (p *Primary) serveReplica(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		p.wg.Done()
+	}()
+	var hello replicationHello
+	conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	if err := gob.NewDecoder(conn).Decode(&hello); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	wr := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(wr)
+	send := func(msg replicationMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+		return enc.Encode(msg)
+	}
+
+	p.mu.Lock()
+	runID, offset := p.runID, hello.Offset
+	partial := hello.RunID == runID && offset+1 >= p.first && offset < p.next
+	if !partial {
+		// 先确定偏移再生成快照，快照之后的日志重放是幂等的覆盖写，最终与主节点一致
+		offset = p.next - 1
+	}
+	p.mu.Unlock()
+	if partial {
+		if send(replicationMessage{Type: msgContinue, RunID: runID, Offset: offset}) != nil {
+			return
+		}
+	} else if p.sendSnapshot(send, runID, offset) != nil {
+		return
+	}
+	if wr.Flush() != nil {
+		return
+	}
+
+	for {
+		p.mu.Lock()
+		for offset+1 >= p.next && !p.closed && runID == p.runID {
+			p.cond.Wait()
+		}
+		if p.closed || runID != p.runID || offset+1 < p.first {
+			// 副本落后超出复制日志范围或主节点丢失了事件，断开后由副本重新全量同步
+			p.mu.Unlock()
+			return
+		}
+		batch := append([]replicationRecord(nil), p.log[offset+1-p.first:]...)
+		p.mu.Unlock()
+		for _, rec := range batch {
+			if send(replicationMessage{Type: msgRecord, Record: rec}) != nil {
+				return
+			}
+		}
+		if wr.Flush() != nil {
+			return
+		}
+		offset = batch[len(batch)-1].Offset
+	}
+}
+
+//sendSnapshot 发送全量快照
+func (p *Primary) sendSnapshot(send func(replicationMessage) error, runID string, offset int64) error {
+	if err := send(replicationMessage{Type: msgFullSync, RunID: runID, Offset: offset}); err != nil {
+		return err
+	}
+	// 一次遍历同时读取值与过期时间，不为renewOnLoad的map续租
+	var records []replicationRecord
+	p.m.PeekRange(func(key string, value interface{}, expiresAt time.Time) bool {
+		rec := replicationRecord{Op: OpStore, Key: key, Value: value}
+		if !expiresAt.IsZero() {
+			rec.ExpireAt = expiresAt.UnixNano()
+		}
+		records = append(records, rec)
+		return true
+	})
+	for _, rec := range records {
+		if err := send(replicationMessage{Type: msgSnapshot, Record: rec}); err != nil {
+			return err
+		}
+	}
+	return send(replicationMessage{Type: msgSnapshotEnd, Offset: offset})
+}
+
+//NewReplica 创建副本并开始从addr同步数据到m，m应使用NoExpiration作为默认过期时间
+func NewReplica(addr string, m ExpirableMap) *Replica {
+	r := &Replica{
+		m:    m,
+		addr: addr,
+		exit: make(chan bool),
+		done: make(chan bool),
+	}
+	go r.run()
+	return r
+}
+
+//Offset 已应用的偏移
+func (r *Replica) Offset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offset
+}
+
+//Close 停止同步，本地数据保留
+func (r *Replica) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return ErrReplicationClosed
+	}
+	r.closed = true
+	close(r.exit)
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.mu.Unlock()
+	<-r.done
+	return nil
+}
+
+//run 同步循环，断线后按指数退避重连
+func (r *Replica) run() {
+	defer close(r.done)
+	backoff := replicaMinBackoff
+	for {
+		if r.sync() {
+			backoff = replicaMinBackoff
+		} else if backoff *= 2; backoff > replicaMaxBackoff {
+			backoff = replicaMaxBackoff
+		}
+		select {
+		case <-r.exit:
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+//sync 建立一次连接并持续应用复制流，返回是否成功完成握手
+func (r *Replica) sync() (connected bool) {
+	conn, err := net.DialTimeout("tcp", r.addr, replicationTimeout)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.Close()
+		return false
+	}
+	r.conn = conn
+	hello := replicationHello{RunID: r.runID, Offset: r.offset}
+	r.mu.Unlock()
+	defer func() {
+		conn.Close()
+		r.mu.Lock()
+		r.conn = nil
+		r.mu.Unlock()
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+	if err := gob.NewEncoder(conn).Encode(hello); err != nil {
+		return false
+	}
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	var syncRunID string
+	for {
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			return connected
+		}
+		connected = true
+		switch msg.Type {
+		case msgFullSync:
+			// 快照未接收完前断线时需要重新全量同步
+			syncRunID = msg.RunID
+			r.mu.Lock()
+			r.runID = ""
+			r.fullSyncs++
+			r.mu.Unlock()
+			r.m.Clear()
+		case msgSnapshot:
+			r.apply(msg.Record)
+		case msgSnapshotEnd:
+			r.mu.Lock()
+			r.runID, r.offset = syncRunID, msg.Offset
+			r.mu.Unlock()
+		case msgContinue:
+			r.mu.Lock()
+			r.runID, r.offset = msg.RunID, msg.Offset
+			r.mu.Unlock()
+		case msgRecord:
+			r.apply(msg.Record)
+			r.mu.Lock()
+			r.offset = msg.Record.Offset
+			r.mu.Unlock()
+		}
+	}
+}
+
+//apply 将一条记录应用到本地map
+func (r *Replica) apply(rec replicationRecord) {
+	switch rec.Op {
+	case OpStore, OpRenew:
+		if rec.ExpireAt == 0 {
+			r.m.StoreWithTTL(rec.Key, rec.Value, NoExpiration)
+		} else if ttl := time.Until(time.Unix(0, rec.ExpireAt)); ttl > 0 {
+			r.m.StoreWithTTL(rec.Key, rec.Value, ttl)
+		} else {
+			r.m.Delete(rec.Key)
+		}
+	case OpDelete, OpExpire, OpEvict:
+		r.m.Delete(rec.Key)
+	case OpClear:
+		r.m.Clear()
+	}
+}
@@ -0,0 +1,217 @@
+package gomap
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func startPrimary(t *testing.T, m ExpirableMap, backlog int) (*Primary, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPrimary(m, backlog)
+	go p.Serve(ln)
+	return p, ln.Addr().String()
+}
+
+//waitSynced 等待副本追上主节点
+func waitSynced(t *testing.T, p *Primary, r *Replica) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		// 等待变更事件进入复制日志
+		time.Sleep(10 * time.Millisecond)
+		p.mu.Lock()
+		pending := len(p.renewed)
+		p.mu.Unlock()
+		if pending == 0 && r.Offset() == p.Offset() {
+			return
+		}
+	}
+	t.Fatal("replica not synced", r.Offset(), p.Offset())
+}
+
+func TestReplication_FullAndIncremental(t *testing.T) {
+	m := NewTTLMap(-1, 100*time.Millisecond, false)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	m.StoreWithTTL("ttl", "v", time.Minute)
+	p, addr := startPrimary(t, m, 0)
+	defer p.Close()
+
+	local := NewTTLMap(NoExpiration, 100*time.Millisecond, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+	if local.Size() != 101 {
+		t.Fatal("snapshot not applied", local.Size())
+	}
+	if ttl, ok := local.TTL("ttl"); !ok || ttl <= 50*time.Second {
+		t.Fatal("expiration not replicated", ttl)
+	}
+
+	m.Store("new", 1)
+	m.Delete("0")
+	m.StoreWithTTL("short", 1, 50*time.Millisecond)
+	m.Persist("ttl")
+	waitSynced(t, p, r)
+	if v, _ := local.Load("new"); v != 1 {
+		t.Fatal("store not replicated")
+	}
+	if _, ok := local.Load("0"); ok {
+		t.Fatal("delete not replicated")
+	}
+	if ttl, _ := local.TTL("ttl"); ttl != NoExpiration {
+		t.Fatal("persist not replicated", ttl)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := local.Load("short"); ok {
+		t.Fatal("short should expire on replica")
+	}
+	m.Clear()
+	waitSynced(t, p, r)
+	if local.Size() != 0 {
+		t.Fatal("clear not replicated", local.Size())
+	}
+}
+
+func TestReplication_Resume(t *testing.T) {
+	m := NewLinkedTTLMap(-1, -1, false)
+	m.Store("1", 1)
+	p, addr := startPrimary(t, m, 0)
+	defer p.Close()
+	local := NewLinkedTTLMap(NoExpiration, -1, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+
+	// 模拟断线，期间写入的数据通过增量续传补齐
+	r.mu.Lock()
+	r.conn.Close()
+	r.mu.Unlock()
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	waitSynced(t, p, r)
+	r.mu.Lock()
+	fullSyncs := r.fullSyncs
+	r.mu.Unlock()
+	if fullSyncs != 1 {
+		t.Fatal("should resume from offset", fullSyncs)
+	}
+	if local.Size() != 10 {
+		t.Fatal("records lost", local.Size())
+	}
+}
+
+func TestReplication_BacklogOverflow(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	p, addr := startPrimary(t, m, 5)
+	defer p.Close()
+	local := NewTTLMap(NoExpiration, -1, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+
+	r.mu.Lock()
+	r.conn.Close()
+	r.mu.Unlock()
+	for i := 0; i < 20; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	waitSynced(t, p, r)
+	r.mu.Lock()
+	fullSyncs := r.fullSyncs
+	r.mu.Unlock()
+	if fullSyncs < 2 {
+		t.Fatal("should fall back to full sync", fullSyncs)
+	}
+	if local.Size() != 20 {
+		t.Fatal("data lost", local.Size())
+	}
+}
+
+func TestReplication_RenewCoalesced(t *testing.T) {
+	m := NewTTLMap(200*time.Millisecond, 50*time.Millisecond, true)
+	m.Store("a", 1)
+	p, addr := startPrimary(t, m, 0)
+	defer p.Close()
+	local := NewTTLMap(NoExpiration, 50*time.Millisecond, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+
+	// 持续读取使主节点不断续租，复制日志中只有合并后的少量续租记录
+	offset := p.Offset()
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		for i := 0; i < 100; i++ {
+			m.Load("a")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitSynced(t, p, r)
+	if n := p.Offset() - offset; n > 10 {
+		t.Fatal("renewals not coalesced", n)
+	}
+	if _, ok := local.Load("a"); !ok {
+		t.Fatal("renewal not replicated")
+	}
+}
+
+func TestReplication_Resync(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	m.Store("a", 1)
+	p, addr := startPrimary(t, m, 0)
+	defer p.Close()
+	local := NewTTLMap(NoExpiration, -1, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+
+	// 订阅溢出丢失事件后，副本断开并重新全量同步
+	p.resync()
+	m.Store("b", 2)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		fullSyncs := r.fullSyncs
+		r.mu.Unlock()
+		if fullSyncs == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitSynced(t, p, r)
+	r.mu.Lock()
+	fullSyncs := r.fullSyncs
+	r.mu.Unlock()
+	if fullSyncs != 2 || local.Size() != 2 {
+		t.Fatal("should resync after losing events", fullSyncs, local.Size())
+	}
+}
+
+func TestReplication_SnapshotNoRenew(t *testing.T) {
+	m := NewTTLMap(time.Minute, -1, true)
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	at, _ := m.ExpiresAt("0")
+	p, addr := startPrimary(t, m, 0)
+	defer p.Close()
+	offset := p.Offset()
+	local := NewTTLMap(NoExpiration, -1, false)
+	r := NewReplica(addr, local)
+	defer r.Close()
+	waitSynced(t, p, r)
+	// 全量同步不应为主节点的数据续租，也不产生续租记录
+	if now, _ := m.ExpiresAt("0"); !now.Equal(at) || p.Offset() != offset {
+		t.Fatal("snapshot renewed entries", at, now, p.Offset()-offset)
+	}
+	if got, _ := local.ExpiresAt("0"); got.Sub(at) > time.Millisecond || at.Sub(got) > time.Millisecond {
+		t.Fatal("expiration not replicated", at, got)
+	}
+}
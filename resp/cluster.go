@@ -0,0 +1,323 @@
+package resp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type (
+	// ClusterTransport 通过RESP协议访问运行Server的各节点，实现gomap.ClusterTransport，节点名即节点地址。
+	// 值以bulk string传输，读取得到string，写入非字符串的值时按fmt格式化。
+	// Update基于GET与SET IFEQ/NX的CAS重试保证原子性；Clear先遍历再FLUSHDB，期间的并发写入可能不在返回结果中。
+	ClusterTransport struct {
+		mu      sync.Mutex
+		idle    map[string][]*clientConn // 各节点的空闲连接
+		timeout time.Duration            // 单次请求超时
+		closed  bool
+	}
+
+	clientConn struct {
+		conn net.Conn
+		r    *reader
+		w    *writer
+	}
+
+	// replyError 服务端返回的错误，连接仍可复用
+	replyError string
+)
+
+const (
+	defaultClientTimeout = 5 * time.Second
+	maxIdleConns         = 8   // 每个节点保留的空闲连接数
+	clusterScanCount     = 100 // Range时每次SCAN的数量
+)
+
+var ErrTransportClosed = errors.New("resp: transport closed")
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+//NewClusterTransport timeout为单次请求超时，<=0时为5s
+func NewClusterTransport(timeout time.Duration) *ClusterTransport {
+	if timeout <= 0 {
+		timeout = defaultClientTimeout
+	}
+	return &ClusterTransport{
+		idle:    map[string][]*clientConn{},
+		timeout: timeout,
+	}
+}
+
+//Close 关闭所有空闲连接，之后的请求返回ErrTransportClosed
+func (t *ClusterTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.closed = true
+	for _, conns := range t.idle {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}
+	t.idle = nil
+	return nil
+}
+
+//conn 取出空闲连接或新建连接
+func (t *ClusterTransport) conn(peer string) (*clientConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrTransportClosed
+	}
+	if conns := t.idle[peer]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		t.idle[peer] = conns[:len(conns)-1]
+		t.mu.Unlock()
+		return c, nil
+	}
+	t.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", peer, t.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &clientConn{conn: conn, r: newReader(conn), w: newWriter(conn)}, nil
+}
+
+//release 归还连接，超出空闲上限或已关闭时关闭连接
+func (t *ClusterTransport) release(peer string, c *clientConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.idle[peer]) >= maxIdleConns {
+		c.conn.Close()
+		return
+	}
+	t.idle[peer] = append(t.idle[peer], c)
+}
+
+//do 发送一条命令并读取回复，网络错误时关闭连接
+func (t *ClusterTransport) do(peer string, args ...string) (interface{}, error) {
+	c, err := t.conn(peer)
+	if err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(t.timeout))
+	c.w.array(len(args))
+	for _, arg := range args {
+		c.w.bulk([]byte(arg))
+	}
+	var reply interface{}
+	if err = c.w.flush(); err == nil {
+		reply, err = c.r.readReply()
+	}
+	if _, ok := err.(replyError); err != nil && !ok {
+		c.conn.Close()
+		return nil, err
+	}
+	t.release(peer, c)
+	return reply, err
+}
+
+//readReply 读取一条RESP2回复，bulk string返回string，null返回nil
+func (r *reader) readReply() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, replyError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > r.maxBulk {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf, err := r.readBulk(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxArrayLen {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, minPrealloc(n))
+		for i := 0; i < n; i++ {
+			item, err := r.readReply()
+			if _, ok := err.(replyError); err != nil && !ok {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, errProtocol
+}
+
+//ttlArgs 将ttl转为SET的参数，DefaultExpiration不带参数，由节点使用默认过期时间
+func ttlArgs(ttl time.Duration) []string {
+	switch {
+	case ttl == gomap.KeepTTL:
+		return []string{"KEEPTTL"}
+	case ttl == gomap.NoExpiration:
+		return []string{"PERSIST"}
+	case ttl > 0:
+		ms := int64(ttl / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		return []string{"PX", strconv.FormatInt(ms, 10)}
+	}
+	return nil
+}
+
+//set 执行SET，cond为NX或IFEQ等条件参数，返回是否写入
+func (t *ClusterTransport) set(peer, key, value string, ttl time.Duration, cond ...string) (bool, error) {
+	args := append([]string{"SET", key, value}, ttlArgs(ttl)...)
+	reply, err := t.do(peer, append(args, cond...)...)
+	if err != nil || reply == nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (t *ClusterTransport) Load(peer, key string) (interface{}, bool, error) {
+	reply, err := t.do(peer, "GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	return reply, true, nil
+}
+
+func (t *ClusterTransport) Store(peer, key string, value interface{}, ttl time.Duration) error {
+	_, err := t.set(peer, key, string(toBytes(value)), ttl)
+	return err
+}
+
+//Update 读取当前值计算后以SET IFEQ（key不存在时SET NX）写入，ExpireNow以DELEX IFEQ删除，值被并发修改时重试
+func (t *ClusterTransport) Update(peer, key string, f gomap.UpdateFunc) (interface{}, bool, error) {
+	for {
+		current, loaded, err := t.Load(peer, key)
+		if err != nil {
+			return nil, false, err
+		}
+		value, ttl, store := f(current, loaded)
+		if !store {
+			return current, false, nil
+		}
+		if ttl == gomap.ExpireNow {
+			if !loaded {
+				return nil, true, nil
+			}
+			reply, err := t.do(peer, "DELEX", key, "IFEQ", current.(string))
+			if err != nil {
+				return nil, false, err
+			}
+			if reply == int64(1) {
+				return nil, true, nil
+			}
+			continue
+		}
+		if ttl == gomap.KeepTTL && !loaded {
+			// 与本地Update一致，key不存在时KeepTTL按默认过期时间写入
+			ttl = gomap.DefaultExpiration
+		}
+		cond := []string{"NX"}
+		if loaded {
+			cond = []string{"IFEQ", current.(string)}
+		}
+		data := string(toBytes(value))
+		stored, err := t.set(peer, key, data, ttl, cond...)
+		if err != nil {
+			return nil, false, err
+		}
+		if stored {
+			return data, true, nil
+		}
+	}
+}
+
+func (t *ClusterTransport) Delete(peer, key string) (interface{}, error) {
+	return t.do(peer, "GETDEL", key)
+}
+
+//Range 以SCAN遍历节点上的key并逐个读取，遍历期间删除的key会被跳过
+func (t *ClusterTransport) Range(peer string, f func(key string, value interface{}) bool) error {
+	cursor := "0"
+	for {
+		reply, err := t.do(peer, "SCAN", cursor, "COUNT", strconv.Itoa(clusterScanCount))
+		if err != nil {
+			return err
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return errProtocol
+		}
+		keys, _ := items[1].([]interface{})
+		for _, key := range keys {
+			k, _ := key.(string)
+			value, ok, err := t.Load(peer, k)
+			if err != nil {
+				return err
+			}
+			if ok && !f(k, value) {
+				return nil
+			}
+		}
+		if cursor, _ = items[0].(string); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+func (t *ClusterTransport) Clear(peer string) ([]gomap.Entry, error) {
+	var entries []gomap.Entry
+	if err := t.Range(peer, func(key string, value interface{}) bool {
+		entries = append(entries, gomap.Entry{Key: key, Value: value})
+		return true
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := t.do(peer, "FLUSHDB"); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (t *ClusterTransport) Size(peer string) (int, error) {
+	reply, err := t.do(peer, "DBSIZE")
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errProtocol
+	}
+	return int(n), nil
+}
@@ -0,0 +1,161 @@
+package resp
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+func startServer(t *testing.T) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(gomap.NewTTLMap(-1, 100*time.Millisecond, false))
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func TestClusterTransport(t *testing.T) {
+	s1, addr1 := startServer(t)
+	defer s1.Close()
+	s2, addr2 := startServer(t)
+	defer s2.Close()
+	transport := NewClusterTransport(time.Second)
+	defer transport.Close()
+	c := gomap.NewCluster(gomap.ClusterConfig{
+		Transport: transport,
+		Peers:     []string{addr1, addr2},
+		OnError: func(peer string, err error) {
+			t.Fatal(peer, err)
+		},
+	})
+	defer c.Destroy()
+
+	for i := 0; i < 50; i++ {
+		c.Store("key:"+strconv.Itoa(i), i)
+	}
+	// key按一致性哈希分布到两个节点
+	if n1, n2 := s1.m.Size(), s2.m.Size(); n1 == 0 || n2 == 0 || n1+n2 != 50 || c.Size() != 50 {
+		t.Fatal("keys not distributed", n1, n2)
+	}
+	if v, ok := c.Load("key:1"); !ok || v != "1" {
+		t.Fatal("Load", v, ok)
+	}
+	if actual, loaded := c.LoadOrStore("key:1", "x"); !loaded || actual != "1" {
+		t.Fatal("LoadOrStore loaded", actual, loaded)
+	}
+	if actual, loaded := c.LoadOrStore("new", "x"); loaded || actual != "x" {
+		t.Fatal("LoadOrStore stored", actual, loaded)
+	}
+	c.StoreOrCompare("key:2", 10, func(stored interface{}, input interface{}) interface{} {
+		n, _ := strconv.Atoi(stored.(string))
+		return n + input.(int)
+	})
+	if v, _ := c.Load("key:2"); v != "12" {
+		t.Fatal("StoreOrCompare", v)
+	}
+	if v := c.Delete("key:3"); v != "3" {
+		t.Fatal("Delete", v)
+	}
+	if _, ok := c.Load("key:3"); ok {
+		t.Fatal("deleted key loaded")
+	}
+
+	c.StoreWithTTL("ttl", "v", 50*time.Millisecond)
+	c.StoreWithTTL("persist", "v", gomap.NoExpiration)
+	peer := c.Owner("persist")
+	if ttl, ok := map[string]*Server{addr1: s1, addr2: s2}[peer].m.TTL("persist"); !ok || ttl != gomap.NoExpiration {
+		t.Fatal("NoExpiration", ttl)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := c.Load("ttl"); ok {
+		t.Fatal("ttl not applied")
+	}
+
+	var keys []string
+	c.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	if len(keys) != 51 {
+		t.Fatal("Range", len(keys))
+	}
+	entries := c.Clear()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	if len(entries) != 51 || c.Size() != 0 {
+		t.Fatal("Clear", len(entries), c.Size())
+	}
+}
+
+func TestClusterTransport_Update(t *testing.T) {
+	s, addr := startServer(t)
+	defer s.Close()
+	transport := NewClusterTransport(time.Second)
+	defer transport.Close()
+
+	// 并发自增，基于IFEQ的CAS保证不丢失更新
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				if _, _, err := transport.Update(addr, "n", func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+					n := 0
+					if loaded {
+						n, _ = strconv.Atoi(stored.(string))
+					}
+					return n + 1, gomap.DefaultExpiration, true
+				}); err != nil {
+					t.Error(err)
+				}
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if v, _, _ := transport.Load(addr, "n"); v != "200" {
+		t.Fatal("lost update", v)
+	}
+
+	// ExpireNow删除key，KeepTTL在key不存在时使用默认过期时间，NoExpiration原子写入
+	if v, stored, err := transport.Update(addr, "n", func(interface{}, bool) (interface{}, time.Duration, bool) {
+		return nil, gomap.ExpireNow, true
+	}); err != nil || !stored || v != nil {
+		t.Fatal("ExpireNow", v, stored, err)
+	}
+	if _, loaded, _ := transport.Load(addr, "n"); loaded {
+		t.Fatal("ExpireNow kept key")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := NewServer(gomap.NewTTLMap(time.Minute, 100*time.Millisecond, false))
+	defer s2.Close()
+	go s2.Serve(ln)
+	addr2 := ln.Addr().String()
+	transport.Update(addr2, "k", func(interface{}, bool) (interface{}, time.Duration, bool) {
+		return 1, gomap.KeepTTL, true
+	})
+	if ttl, ok := s2.m.TTL("k"); !ok || ttl <= 0 {
+		t.Fatal("KeepTTL on missing key", ttl, ok)
+	}
+	transport.Update(addr2, "k", func(interface{}, bool) (interface{}, time.Duration, bool) {
+		return 2, gomap.NoExpiration, true
+	})
+	if ttl, ok := s2.m.TTL("k"); !ok || ttl != gomap.NoExpiration {
+		t.Fatal("NoExpiration", ttl, ok)
+	}
+	transport.Close()
+	if _, _, err := transport.Load(addr, "n"); err != ErrTransportClosed {
+		t.Fatal("closed transport", err)
+	}
+}
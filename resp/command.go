@@ -0,0 +1,436 @@
+package resp
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type commandFunc func(w *writer, args [][]byte)
+
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+)
+
+func errArgs(name string) string {
+	return "ERR wrong number of arguments for '" + name + "' command"
+}
+
+func (s *Server) commandTable() map[string]commandFunc {
+	return map[string]commandFunc{
+		"PING":     s.ping,
+		"ECHO":     s.echo,
+		"HELLO":    s.hello,
+		"SELECT":   s.selectDB,
+		"COMMAND":  s.command,
+		"CLIENT":   s.client,
+		"GET":      s.get,
+		"GETDEL":   s.getdel,
+		"DELEX":    s.delex,
+		"SET":      s.set,
+		"DEL":      s.del,
+		"EXISTS":   s.exists,
+		"TTL":      s.ttl,
+		"PTTL":     s.pttl,
+		"EXPIRE":   s.expire,
+		"PEXPIRE":  s.pexpire,
+		"PERSIST":  s.persist,
+		"KEYS":     s.keys,
+		"SCAN":     s.scan,
+		"DBSIZE":   s.dbsize,
+		"FLUSHDB":  s.flushdb,
+		"FLUSHALL": s.flushdb,
+	}
+}
+
+//toBytes 将map中的值转为bulk string，非字符串的值由Go代码写入时按fmt格式化
+func toBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+func parseInt(b []byte) (int64, bool) {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	return n, err == nil
+}
+
+func (s *Server) ping(w *writer, args [][]byte) {
+	switch len(args) {
+	case 0:
+		w.simple("PONG")
+	case 1:
+		w.bulk(args[0])
+	default:
+		w.error(errArgs("ping"))
+	}
+}
+
+func (s *Server) echo(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("echo"))
+		return
+	}
+	w.bulk(args[0])
+}
+
+//hello 协议协商，HELLO [protover]
+func (s *Server) hello(w *writer, args [][]byte) {
+	proto := w.proto
+	if len(args) > 0 {
+		v, ok := parseInt(args[0])
+		if !ok {
+			w.error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v != 2 && v != 3 {
+			w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = int(v)
+	}
+	w.proto = proto
+	w.mapHeader(3)
+	w.bulk([]byte("server"))
+	w.bulk([]byte("gomap"))
+	w.bulk([]byte("proto"))
+	w.int(int64(proto))
+	w.bulk([]byte("mode"))
+	w.bulk([]byte("standalone"))
+}
+
+func (s *Server) selectDB(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("select"))
+		return
+	}
+	if string(args[0]) != "0" {
+		w.error("ERR DB index is out of range")
+		return
+	}
+	w.simple("OK")
+}
+
+//command 客户端启动时会查询命令信息，这里返回空列表
+func (s *Server) command(w *writer, args [][]byte) {
+	w.array(0)
+}
+
+func (s *Server) client(w *writer, args [][]byte) {
+	w.simple("OK")
+}
+
+func (s *Server) get(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("get"))
+		return
+	}
+	if value, ok := s.m.Load(string(args[0])); ok {
+		w.bulk(toBytes(value))
+	} else {
+		w.null()
+	}
+}
+
+//set SET key value [EX seconds|PX milliseconds|KEEPTTL|PERSIST] [NX|XX|IFEQ value]，PERSIST为扩展参数，写入永不过期的值
+func (s *Server) set(w *writer, args [][]byte) {
+	if len(args) < 2 {
+		w.error(errArgs("set"))
+		return
+	}
+	key, value := string(args[0]), string(args[1])
+	ttl := gomap.DefaultExpiration
+	var nx, xx, keep, persist bool
+	var ifeq []byte
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keep = true
+		case "PERSIST":
+			persist = true
+		case "IFEQ":
+			// 仅当当前值等于给定值时写入，用于客户端的CAS
+			if ifeq != nil || i+1 >= len(args) {
+				w.error(errSyntax)
+				return
+			}
+			ifeq = args[i+1]
+			i++
+		case "EX", "PX":
+			if ttl != gomap.DefaultExpiration || i+1 >= len(args) {
+				w.error(errSyntax)
+				return
+			}
+			n, ok := parseInt(args[i+1])
+			if !ok || n <= 0 || n > math.MaxInt64/int64(time.Second) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			if strings.ToUpper(string(args[i])) == "EX" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		default:
+			w.error(errSyntax)
+			return
+		}
+	}
+	if (nx && xx) || (ifeq != nil && (nx || xx)) || (keep && persist) || ((keep || persist) && ttl != gomap.DefaultExpiration) {
+		w.error(errSyntax)
+		return
+	}
+	if keep {
+		ttl = gomap.KeepTTL
+	} else if persist {
+		ttl = gomap.NoExpiration
+	}
+	_, stored := s.m.Update(key, func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if (nx && loaded) || (xx && !loaded) {
+			return nil, 0, false
+		}
+		if ifeq != nil && (!loaded || string(toBytes(stored)) != string(ifeq)) {
+			return nil, 0, false
+		}
+		return value, ttl, true
+	})
+	if stored {
+		w.simple("OK")
+	} else {
+		w.null()
+	}
+}
+
+func (s *Server) getdel(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("getdel"))
+		return
+	}
+	if value := s.m.Delete(string(args[0])); value != nil {
+		w.bulk(toBytes(value))
+	} else {
+		w.null()
+	}
+}
+
+//delex DELEX key [IFEQ value]，仅当当前值等于给定值时删除，用于客户端的CAS
+func (s *Server) delex(w *writer, args [][]byte) {
+	var ifeq []byte
+	switch {
+	case len(args) == 1:
+	case len(args) == 3 && strings.ToUpper(string(args[1])) == "IFEQ":
+		ifeq = args[2]
+	case len(args) == 0 || len(args) == 2:
+		w.error(errArgs("delex"))
+		return
+	default:
+		w.error(errSyntax)
+		return
+	}
+	var deleted bool
+	s.m.Update(string(args[0]), func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if !loaded || (ifeq != nil && string(toBytes(stored)) != string(ifeq)) {
+			return nil, 0, false
+		}
+		deleted = true
+		return nil, gomap.ExpireNow, true
+	})
+	if deleted {
+		w.int(1)
+	} else {
+		w.int(0)
+	}
+}
+
+func (s *Server) del(w *writer, args [][]byte) {
+	if len(args) == 0 {
+		w.error(errArgs("del"))
+		return
+	}
+	var n int64
+	for _, key := range args {
+		if s.m.Delete(string(key)) != nil {
+			n++
+		}
+	}
+	w.int(n)
+}
+
+func (s *Server) exists(w *writer, args [][]byte) {
+	if len(args) == 0 {
+		w.error(errArgs("exists"))
+		return
+	}
+	var n int64
+	for _, key := range args {
+		if _, ok := s.m.TTL(string(key)); ok {
+			n++
+		}
+	}
+	w.int(n)
+}
+
+//writeTTL 按redis约定返回剩余时间，-2表示key不存在，-1表示永不过期
+func (s *Server) writeTTL(w *writer, args [][]byte, name string, unit time.Duration) {
+	if len(args) != 1 {
+		w.error(errArgs(name))
+		return
+	}
+	ttl, ok := s.m.TTL(string(args[0]))
+	switch {
+	case !ok:
+		w.int(-2)
+	case ttl == gomap.NoExpiration:
+		w.int(-1)
+	default:
+		w.int(int64((ttl + unit - 1) / unit))
+	}
+}
+
+func (s *Server) ttl(w *writer, args [][]byte) {
+	s.writeTTL(w, args, "ttl", time.Second)
+}
+
+func (s *Server) pttl(w *writer, args [][]byte) {
+	s.writeTTL(w, args, "pttl", time.Millisecond)
+}
+
+func (s *Server) writeExpire(w *writer, args [][]byte, name string, unit time.Duration) {
+	if len(args) != 2 {
+		w.error(errArgs(name))
+		return
+	}
+	n, ok := parseInt(args[1])
+	// n*unit溢出时会变成符号相反的ttl
+	if !ok || n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		w.error(errNotInteger)
+		return
+	}
+	if s.m.Expire(string(args[0]), time.Duration(n)*unit) {
+		w.int(1)
+	} else {
+		w.int(0)
+	}
+}
+
+func (s *Server) expire(w *writer, args [][]byte) {
+	s.writeExpire(w, args, "expire", time.Second)
+}
+
+func (s *Server) pexpire(w *writer, args [][]byte) {
+	s.writeExpire(w, args, "pexpire", time.Millisecond)
+}
+
+func (s *Server) persist(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("persist"))
+		return
+	}
+	if s.m.Persist(string(args[0])) {
+		w.int(1)
+	} else {
+		w.int(0)
+	}
+}
+
+//sortedKeys 返回所有匹配pattern的key，按字典序排列
+func (s *Server) sortedKeys(pattern string) []string {
+	var keys []string
+	// 列出key不应为renewOnLoad的map续租
+	s.m.PeekRange(func(key string, value interface{}, expiresAt time.Time) bool {
+		if pattern == "*" || matchGlob(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) keys(w *writer, args [][]byte) {
+	if len(args) != 1 {
+		w.error(errArgs("keys"))
+		return
+	}
+	keys := s.sortedKeys(string(args[0]))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk([]byte(key))
+	}
+}
+
+//scan SCAN cursor [MATCH pattern] [COUNT count]，游标为有序key列表中的偏移
+func (s *Server) scan(w *writer, args [][]byte) {
+	if len(args) == 0 {
+		w.error(errArgs("scan"))
+		return
+	}
+	cursor, ok := parseInt(args[0])
+	if !ok || cursor < 0 {
+		w.error("ERR invalid cursor")
+		return
+	}
+	pattern, count := "*", int64(10)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.error(errSyntax)
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, ok = parseInt(args[i+1]); !ok || count < 1 {
+				w.error(errSyntax)
+				return
+			}
+		default:
+			w.error(errSyntax)
+			return
+		}
+	}
+	keys := s.sortedKeys(pattern)
+	if cursor > int64(len(keys)) {
+		cursor = int64(len(keys))
+	}
+	// 先按剩余数量收紧count，避免cursor+count溢出
+	if rest := int64(len(keys)) - cursor; count > rest {
+		count = rest
+	}
+	end := cursor + count
+	next := end
+	if end >= int64(len(keys)) {
+		next = 0
+	}
+	w.array(2)
+	w.bulk([]byte(strconv.FormatInt(next, 10)))
+	w.array(int(end - cursor))
+	for _, key := range keys[cursor:end] {
+		w.bulk([]byte(key))
+	}
+}
+
+func (s *Server) dbsize(w *writer, args [][]byte) {
+	w.int(int64(s.m.Size()))
+}
+
+func (s *Server) flushdb(w *writer, args [][]byte) {
+	s.m.Clear()
+	w.simple("OK")
+}
@@ -0,0 +1,189 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxBulkLen = 16 << 20 // 单个bulk string默认最大长度
+	maxArrayLen       = 1 << 20  // 单条命令最大参数个数
+	maxPrealloc       = 64       // 解析命令时预分配的最大参数个数
+	maxLineLen        = 64 << 10 // 单行最大长度，与Redis对inline命令的限制相同
+	bulkChunk         = 64 << 10 // 读取bulk string时每次增长的长度
+)
+
+var errProtocol = errors.New("ERR Protocol error")
+
+type (
+	reader struct {
+		rd      *bufio.Reader
+		maxBulk int // 单个bulk string最大长度
+	}
+
+	writer struct {
+		wr    *bufio.Writer
+		proto int // 协议版本，2或3
+	}
+)
+
+func newReader(r io.Reader) *reader {
+	return &reader{rd: bufio.NewReader(r), maxBulk: defaultMaxBulkLen}
+}
+
+//readLine 读取一行，去掉结尾的\r\n；超过maxLineLen时返回协议错误
+func (r *reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLen {
+			return "", errProtocol
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+//readCommand 读取一条命令，支持数组格式与inline格式
+func (r *reader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		var args [][]byte
+		for _, field := range strings.Fields(line) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxArrayLen {
+		return nil, errProtocol
+	}
+	if n <= 0 {
+		// *-1为空数组，与空行一样忽略
+		return nil, nil
+	}
+	// 参数个数来自客户端，预分配不超过maxPrealloc，其余按需增长
+	args := make([][]byte, 0, minPrealloc(n))
+	for i := 0; i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > r.maxBulk {
+			return nil, errProtocol
+		}
+		buf, err := r.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, buf)
+	}
+	return args, nil
+}
+
+//readBulk 读取size字节的bulk string及结尾的\r\n。size来自对端，按实际收到的数据分块增长，不按声明的长度一次分配
+func (r *reader) readBulk(size int) ([]byte, error) {
+	var buf []byte
+	for len(buf) < size+2 {
+		n := size + 2 - len(buf)
+		if n > bulkChunk {
+			n = bulkChunk
+		}
+		buf = append(buf, make([]byte, n)...)
+		if _, err := io.ReadFull(r.rd, buf[len(buf)-n:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf[:size], nil
+}
+
+//minPrealloc 按对端给出的长度预分配时不超过maxPrealloc
+func minPrealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+//buffered 是否还有未处理的管道命令
+func (r *reader) buffered() bool {
+	return r.rd.Buffered() > 0
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{wr: bufio.NewWriter(w), proto: 2}
+}
+
+func (w *writer) simple(s string) {
+	w.wr.WriteByte('+')
+	w.wr.WriteString(s)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *writer) error(s string) {
+	w.wr.WriteByte('-')
+	w.wr.WriteString(s)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *writer) int(n int64) {
+	w.wr.WriteByte(':')
+	w.wr.WriteString(strconv.FormatInt(n, 10))
+	w.wr.WriteString("\r\n")
+}
+
+func (w *writer) bulk(b []byte) {
+	w.wr.WriteByte('$')
+	w.wr.WriteString(strconv.Itoa(len(b)))
+	w.wr.WriteString("\r\n")
+	w.wr.Write(b)
+	w.wr.WriteString("\r\n")
+}
+
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.wr.WriteString("_\r\n")
+	} else {
+		w.wr.WriteString("$-1\r\n")
+	}
+}
+
+func (w *writer) array(n int) {
+	w.wr.WriteByte('*')
+	w.wr.WriteString(strconv.Itoa(n))
+	w.wr.WriteString("\r\n")
+}
+
+//mapHeader RESP3下为map类型，RESP2下退化为2n长度的数组
+func (w *writer) mapHeader(n int) {
+	if w.proto == 3 {
+		w.wr.WriteByte('%')
+		w.wr.WriteString(strconv.Itoa(n))
+		w.wr.WriteString("\r\n")
+	} else {
+		w.array(2 * n)
+	}
+}
+
+func (w *writer) flush() error {
+	return w.wr.Flush()
+}
@@ -0,0 +1,154 @@
+//Package resp 以Redis协议(RESP2/RESP3)对外提供gomap.TTLMap的访问，
+// 便于在本地开发与集成测试中直接使用现有的Redis客户端。
+package resp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/cheivin/gomap"
+)
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+type Server struct {
+	m         *gomap.TTLMap             // 数据
+	mu        sync.Mutex                // 锁
+	listeners map[net.Listener]struct{} // 监听器
+	conns     map[net.Conn]struct{}     // 活跃连接
+	closed    bool                      // 是否已关闭
+	wg        sync.WaitGroup            // 连接处理协程
+	commands  map[string]commandFunc    // 命令表
+	maxBulk   int                       // 单个参数最大长度
+}
+
+//NewServer 创建服务，m需设置gcInterval才能及时清理按key设置的过期数据
+func NewServer(m *gomap.TTLMap) *Server {
+	s := &Server{
+		m:         m,
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+		maxBulk:   defaultMaxBulkLen,
+	}
+	s.commands = s.commandTable()
+	return s
+}
+
+//SetMaxBulkLen 设置单个参数的最大长度，默认16MB，超过时回复协议错误并断开连接；对之后建立的连接生效
+func (s *Server) SetMaxBulkLen(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBulk = n
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+//Serve 在ln上接受连接，直到ln出错或服务关闭
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+//Close 关闭所有监听器与连接，等待连接处理结束
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	r := newReader(conn)
+	s.mu.Lock()
+	r.maxBulk = s.maxBulk
+	s.mu.Unlock()
+	w := newWriter(conn)
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			if err == errProtocol {
+				w.error(err.Error())
+				w.flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		if name == "QUIT" {
+			w.simple("OK")
+			w.flush()
+			return
+		}
+		if cmd, ok := s.commands[name]; ok {
+			cmd(w, args[1:])
+		} else {
+			w.error("ERR unknown command '" + string(args[0]) + "'")
+		}
+		if !r.buffered() {
+			if err := w.flush(); err != nil {
+				return
+			}
+		}
+	}
+}
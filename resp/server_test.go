@@ -0,0 +1,291 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cheivin/gomap"
+)
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+func newTestServer(t *testing.T) (*Server, *testClient) {
+	return newTestServerWith(t, gomap.NewTTLMap(-1, 100*time.Millisecond, false))
+}
+
+func newTestServerWith(t *testing.T, m *gomap.TTLMap) (*Server, *testClient) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(m)
+	go s.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, &testClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+}
+
+//do 发送命令并读取完整的一条回复，以原始文本返回
+func (c *testClient) do(args ...string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := c.conn.Write([]byte(sb.String())); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.readReply()
+}
+
+func (c *testClient) readReply() string {
+	line, err := c.rd.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return line
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			c.t.Fatal(err)
+		}
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			items = append(items, c.readReply())
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return line
+}
+
+func (c *testClient) expect(want string, args ...string) {
+	if got := c.do(args...); got != want {
+		c.t.Fatalf("%v: want %q, got %q", args, want, got)
+	}
+}
+
+func TestServer_Basic(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("+PONG", "PING")
+	c.expect("$-1", "GET", "a")
+	c.expect("+OK", "SET", "a", "1")
+	c.expect("1", "GET", "a")
+	c.expect(":1", "EXISTS", "a")
+	c.expect(":-1", "TTL", "a")
+	c.expect(":-2", "TTL", "b")
+	c.expect(":1", "DEL", "a", "b")
+	c.expect(":0", "EXISTS", "a")
+}
+
+func TestServer_SetOptions(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("$-1", "SET", "a", "1", "XX")
+	c.expect("+OK", "SET", "a", "1", "NX")
+	c.expect("$-1", "SET", "a", "2", "NX")
+	c.expect("+OK", "SET", "a", "3", "XX", "EX", "10")
+	c.expect("3", "GET", "a")
+	c.expect(":10", "TTL", "a")
+	c.expect("+OK", "SET", "b", "1", "PX", "100")
+	time.Sleep(200 * time.Millisecond)
+	c.expect("$-1", "GET", "b")
+	c.expect("-ERR syntax error", "SET", "a", "1", "NX", "XX")
+}
+
+func TestServer_SetConditions(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect("+OK", "SET", "a", "1", "PX", "100000")
+	c.expect("$-1", "SET", "a", "2", "IFEQ", "0")
+	c.expect("+OK", "SET", "a", "2", "IFEQ", "1", "KEEPTTL")
+	if got := c.do("PTTL", "a"); got == ":-1" {
+		t.Fatal("KEEPTTL lost expiration", got)
+	}
+	c.expect("$-1", "SET", "b", "1", "IFEQ", "1")
+	c.expect("-ERR syntax error", "SET", "a", "3", "IFEQ", "2", "NX")
+	c.expect("-ERR syntax error", "SET", "a", "3", "KEEPTTL", "PX", "10")
+	c.expect("-ERR syntax error", "SET", "a", "3", "PERSIST", "PX", "10")
+	c.expect("+OK", "SET", "a", "3", "IFEQ", "2", "PERSIST")
+	c.expect(":-1", "PTTL", "a")
+	c.expect("+OK", "SET", "a", "2")
+	c.expect("2", "GETDEL", "a")
+	c.expect("$-1", "GETDEL", "a")
+}
+
+func TestServer_Delex(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect(":0", "DELEX", "a", "IFEQ", "1")
+	c.do("SET", "a", "1")
+	c.expect(":0", "DELEX", "a", "IFEQ", "2")
+	c.expect("1", "GET", "a")
+	c.expect(":1", "DELEX", "a", "IFEQ", "1")
+	c.expect(":0", "EXISTS", "a")
+	c.do("SET", "a", "1")
+	c.expect(":1", "DELEX", "a")
+	c.expect("-ERR syntax error", "DELEX", "a", "IFNE", "1")
+}
+
+func TestServer_Expire(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	c.expect(":0", "EXPIRE", "a", "10")
+	c.do("SET", "a", "1")
+	c.expect(":1", "EXPIRE", "a", "10")
+	c.expect(":10", "TTL", "a")
+	c.expect(":1", "PERSIST", "a")
+	c.expect(":0", "PERSIST", "a")
+	c.expect(":-1", "PTTL", "a")
+	c.expect("-ERR value is not an integer or out of range", "EXPIRE", "a", "-9223372036854775807")
+	c.expect(":-1", "PTTL", "a")
+	c.expect(":1", "EXPIRE", "a", "0")
+	c.expect(":0", "EXISTS", "a")
+}
+
+func TestServer_Keys(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	for i := 0; i < 15; i++ {
+		c.do("SET", "key:"+strconv.Itoa(i), "v")
+	}
+	c.do("SET", "other", "v")
+	c.expect(":16", "DBSIZE")
+	c.expect("[key:1 key:10 key:11 key:12 key:13 key:14]", "KEYS", "key:1*")
+	c.expect("[10 [key:0 key:1 key:10 key:11 key:12 key:13 key:14 key:2 key:3 key:4]]", "SCAN", "0", "MATCH", "key:*")
+	c.expect("[0 [key:5 key:6 key:7 key:8 key:9]]", "SCAN", "10", "MATCH", "key:*")
+	c.expect("[0 [key:0 key:1 key:10 key:11 key:12 key:13 key:14 key:2 key:3 key:4 key:5 key:6 key:7 key:8 key:9]]", "SCAN", "0", "MATCH", "key:*", "COUNT", "9223372036854775807")
+	c.expect("[0 []]", "SCAN", "9223372036854775807", "COUNT", "9223372036854775807")
+	c.expect("-ERR syntax error", "SCAN", "0", "COUNT", "0")
+	c.expect("-ERR syntax error", "SCAN", "0", "COUNT", "-1")
+	c.expect("+OK", "FLUSHDB")
+	c.expect(":0", "DBSIZE")
+}
+
+func TestServer_LineTooLong(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	// 没有换行的超长inline命令返回协议错误，而不是无限读取
+	if _, err := c.conn.Write([]byte(strings.Repeat("a", 2*maxLineLen))); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.readReply(); !strings.HasPrefix(reply, "-ERR Protocol error") {
+		t.Fatal("unexpected reply", reply)
+	}
+}
+
+func TestServer_BulkTooLong(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(gomap.NewTTLMap(-1, -1, false))
+	s.SetMaxBulkLen(10)
+	go s.Serve(ln)
+	defer s.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn, rd: bufio.NewReader(conn)}
+	c.expect("+OK", "SET", "a", strings.Repeat("v", 10))
+	c.expect("-ERR Protocol error", "SET", "a", strings.Repeat("v", 11))
+}
+
+func TestReader_Bulk(t *testing.T) {
+	// 跨越多个分块的bulk string完整读出
+	value := strings.Repeat("v", 3*bulkChunk+1)
+	r := newReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"))
+	args, err := r.readCommand()
+	if err != nil || len(args) != 2 || string(args[1]) != value {
+		t.Fatal("bulk not read", len(args), err)
+	}
+	// 声明的长度大于实际发送的数据时返回错误
+	r = newReader(strings.NewReader("*1\r\n$16000000\r\nab"))
+	if _, err := r.readCommand(); err != io.ErrUnexpectedEOF {
+		t.Fatal("truncated bulk", err)
+	}
+}
+
+func TestServer_KeysNoRenew(t *testing.T) {
+	m := gomap.NewTTLMap(time.Minute, -1, true)
+	s, c := newTestServerWith(t, m)
+	defer s.Close()
+	c.expect("+OK", "SET", "a", "1")
+	at, _ := m.ExpiresAt("a")
+	time.Sleep(time.Millisecond)
+	c.expect("[a]", "KEYS", "*")
+	c.expect("[0 [a]]", "SCAN", "0")
+	if now, _ := m.ExpiresAt("a"); !now.Equal(at) {
+		t.Fatal("KEYS renewed key", at, now)
+	}
+}
+
+func TestServer_ArrayLength(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	// 空数组被忽略，连接继续可用
+	if _, err := c.conn.Write([]byte("*-1\r\n*0\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.expect("+PONG", "PING")
+	// 负数长度为协议错误，服务端回复错误后关闭连接而不是panic
+	if _, err := c.conn.Write([]byte("*-5\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.readReply(); !strings.HasPrefix(reply, "-") {
+		t.Fatal("want protocol error, got", reply)
+	}
+}
+
+func TestServer_Hello(t *testing.T) {
+	s, c := newTestServer(t)
+	defer s.Close()
+	t.Log(c.do("HELLO", "3"))
+	c.expect("_", "GET", "a")
+	c.expect("-NOPROTO unsupported protocol version", "HELLO", "4")
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "abc", true},
+		{"a?c", "abc", true},
+		{"a[bc]d", "acd", true},
+		{"a[^bc]d", "acd", false},
+		{"a[a-c]d", "abd", true},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"h*llo", "heeello", true},
+		{"h*llo", "hello world", false},
+	}
+	for _, c := range cases {
+		if matchGlob(c.pattern, c.s) != c.match {
+			t.Fatal(c.pattern, c.s)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package gomap
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+type (
+	// Weigher 计算单个key-val的权重，用于按容量淘汰
+	Weigher func(key string, value interface{}) int64
+
+	// MemoryMeter 能估算自身内存占用的map
+	MemoryMeter interface {
+		MemoryUsage() int64
+	}
+
+	// Sizer 基于反射估算值占用的内存
+	Sizer struct {
+		mu    sync.RWMutex
+		types map[reflect.Type]*sizerType // 按类型缓存的布局信息
+	}
+
+	// sizerType 类型的布局信息
+	sizerType struct {
+		flat   bool  // 不含任何指针，直接使用Type.Size()
+		fields []int // 结构体中含指针的字段下标，遍历时跳过其余字段
+	}
+)
+
+const (
+	mapHeaderSize    = 48 // runtime.hmap大致大小
+	mapEntryOverhead = 16 // 每个map元素在桶中的额外开销(tophash、overflow等均摊)
+)
+
+//DefaultSizer 各map的MemoryUsage默认使用的估算器
+var DefaultSizer = NewSizer()
+
+func NewSizer() *Sizer {
+	return &Sizer{
+		types: map[reflect.Type]*sizerType{},
+	}
+}
+
+//Sizeof 估算value占用的字节数，包括value本身以及其引用的字符串、切片、map、指针等
+func (s *Sizer) Sizeof(value interface{}) int64 {
+	if value == nil {
+		return 0
+	}
+	v := reflect.ValueOf(value)
+	visited := map[uintptr]struct{}{}
+	return int64(v.Type().Size()) + s.indirect(v, visited)
+}
+
+//Weigh 以key长度与value估算大小之和作为权重，可直接作为Weigher使用
+func (s *Sizer) Weigh(key string, value interface{}) int64 {
+	return int64(len(key)) + s.Sizeof(value)
+}
+
+//typeOf 返回类型的布局信息，结果按类型缓存
+func (s *Sizer) typeOf(t reflect.Type) *sizerType {
+	s.mu.RLock()
+	info, ok := s.types[t]
+	s.mu.RUnlock()
+	if ok {
+		return info
+	}
+	info = &sizerType{}
+	switch t.Kind() {
+	case reflect.Array:
+		info.flat = t.Len() == 0 || s.flat(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !s.flat(t.Field(i).Type) {
+				info.fields = append(info.fields, i)
+			}
+		}
+		info.flat = len(info.fields) == 0
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface,
+		reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		info.flat = false
+	default:
+		info.flat = true
+	}
+	s.mu.Lock()
+	s.types[t] = info
+	s.mu.Unlock()
+	return info
+}
+
+//flat 判断类型是否不含任何指针
+func (s *Sizer) flat(t reflect.Type) bool {
+	return s.typeOf(t).flat
+}
+
+//visit 记录已访问的地址，重复访问返回false，用于检测环与共享引用
+func visit(visited map[uintptr]struct{}, p uintptr) bool {
+	if p == 0 {
+		return false
+	}
+	if _, ok := visited[p]; ok {
+		return false
+	}
+	visited[p] = struct{}{}
+	return true
+}
+
+//indirect 计算v引用的、不在v自身内存中的字节数
+func (s *Sizer) indirect(v reflect.Value, visited map[uintptr]struct{}) int64 {
+	t := v.Type()
+	info := s.typeOf(t)
+	if info.flat {
+		return 0
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() || !visit(visited, v.Pointer()) {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(t.Elem().Size())
+		if !s.flat(t.Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += s.indirect(v.Index(i), visited)
+			}
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += s.indirect(v.Index(i), visited)
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for _, i := range info.fields {
+			size += s.indirect(v.Field(i), visited)
+		}
+		return size
+	case reflect.Ptr:
+		if v.IsNil() || !visit(visited, v.Pointer()) {
+			return 0
+		}
+		return int64(t.Elem().Size()) + s.indirect(v.Elem(), visited)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		return int64(elem.Type().Size()) + s.indirect(elem, visited)
+	case reflect.Map:
+		if v.IsNil() || !visit(visited, v.Pointer()) {
+			return 0
+		}
+		size := int64(mapHeaderSize)
+		entrySize := int64(t.Key().Size()+t.Elem().Size()) + mapEntryOverhead
+		iter := v.MapRange()
+		for iter.Next() {
+			size += entrySize + s.indirect(iter.Key(), visited) + s.indirect(iter.Value(), visited)
+		}
+		return size
+	}
+	// chan、func、unsafe.Pointer不追踪
+	return 0
+}
+
+// 各map中单个元素的固定开销
+var (
+	ttlEntrySize         = int64(unsafe.Sizeof(ttlEntry{})) + mapEntryOverhead
+	linkedEntrySize      = int64(unsafe.Sizeof(linkedEntry{})+unsafe.Sizeof(&linkedEntry{})) + mapEntryOverhead
+	linkedTTLEntrySize   = int64(unsafe.Sizeof(linkedTTLEntry{})+unsafe.Sizeof(ttlEntry{})+unsafe.Sizeof(&linkedTTLEntry{})) + mapEntryOverhead
+	keySetEntrySize      = int64(unsafe.Sizeof("")) + mapEntryOverhead
+	spillLocEntrySize    = int64(unsafe.Sizeof("")+unsafe.Sizeof(spillLoc{})) + mapEntryOverhead
+	tieredWriteEntrySize = int64(unsafe.Sizeof("")+unsafe.Sizeof(tieredWrite{})) + mapEntryOverhead
+	raftRequestEntrySize = int64(unsafe.Sizeof("")+unsafe.Sizeof([]byte(nil))) + mapEntryOverhead
+)
@@ -0,0 +1,146 @@
+package gomap
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type sizerNode struct {
+	Name string
+	Next *sizerNode
+}
+
+func TestSizer_Sizeof(t *testing.T) {
+	s := NewSizer()
+	if size := s.Sizeof(int64(1)); size != 8 {
+		t.Fatal("int64 size", size)
+	}
+	if size := s.Sizeof("hello"); size != 16+5 {
+		t.Fatal("string size", size)
+	}
+	if size := s.Sizeof(make([]byte, 10, 32)); size != 24+32 {
+		t.Fatal("slice size", size)
+	}
+	// map头8，hmap 48，3个元素各(16+16+16)，key共3字节，值为int 8、string 16+3、[]int 24+24
+	if size := s.Sizeof(map[string]interface{}{"a": 1, "b": "str", "c": []int{1, 2, 3}}); size != 8+48+3*48+3+8+19+48 {
+		t.Fatal("map size", size)
+	}
+	if size := s.Sizeof(struct {
+		A int
+		B string
+	}{1, "abc"}); size != 24+3 {
+		t.Fatal("struct size", size)
+	}
+}
+
+func TestSizer_Cycle(t *testing.T) {
+	s := NewSizer()
+	a := &sizerNode{Name: "a"}
+	b := &sizerNode{Name: "b", Next: a}
+	a.Next = b
+	// 指针8，两个节点各24加名字1字节，回到a时不再重复计算
+	if size := s.Sizeof(a); size != 8+2*(24+1) {
+		t.Fatal("cyclic value size", size)
+	}
+}
+
+func TestSizer_Weigh(t *testing.T) {
+	var w Weigher = DefaultSizer.Weigh
+	if weight := w("key", "value"); weight != 3+16+5 {
+		t.Fatal("weight", weight)
+	}
+}
+
+func TestMap_MemoryUsage(t *testing.T) {
+	m1 := NewTTLMap(-1, -1, false)
+	m2 := NewLinkedMap()
+	m3 := NewLinkedTTLMap(-1, -1, false)
+	for i := 0; i < 100; i++ {
+		m1.Store(strconv.Itoa(i), strconv.Itoa(i))
+		m2.Store(strconv.Itoa(i), strconv.Itoa(i))
+		m3.Store(strconv.Itoa(i), strconv.Itoa(i))
+	}
+	// key与value共190*2字节，value各含16字节的字符串头
+	data := int64(mapHeaderSize + 2*190 + 100*16)
+	if size := m1.MemoryUsage(); size != data+100*ttlEntrySize {
+		t.Fatal("TTLMap usage", size)
+	}
+	if size := m2.MemoryUsage(); size != data+100*linkedEntrySize {
+		t.Fatal("LinkedMap usage", size)
+	}
+	if size := m3.MemoryUsage(); size != data+100*linkedTTLEntrySize {
+		t.Fatal("LinkedTTLMap usage", size)
+	}
+}
+
+func TestSizer_TypeCache(t *testing.T) {
+	s := NewSizer()
+	type mixed struct {
+		A int64
+		B string
+		C [4]int32
+		D *int
+	}
+	if size := s.Sizeof(mixed{B: "ab", D: new(int)}); size != 8+16+16+8+2+8 {
+		t.Fatal("struct size", size)
+	}
+	// 只记录含指针的字段
+	info := s.typeOf(reflect.TypeOf(mixed{}))
+	if info.flat || len(info.fields) != 2 || info.fields[0] != 1 || info.fields[1] != 3 {
+		t.Fatal("type info", info.flat, info.fields)
+	}
+	if !s.typeOf(reflect.TypeOf([4]int32{})).flat {
+		t.Fatal("flat array")
+	}
+}
+
+func TestMap_MemoryUsageWrappers(t *testing.T) {
+	counters := NewCounterMap(-1, -1, false)
+	defer counters.Destroy()
+	crdt := NewCRDTMap("a", -1, time.Minute, -1)
+	defer crdt.Destroy()
+	tiered := NewTieredMap(TieredConfig{Backend: &flakyBackend{data: map[string]interface{}{}}, L1Expiration: -1, WriteMode: WriteBehind, FlushInterval: time.Hour})
+	defer tiered.Destroy()
+	behind := NewWriteBehindMap(NewTTLMap(-1, -1, false), WriteBehindConfig{Sink: SinkFunc(func([]Write) error { return nil }), FlushInterval: time.Hour})
+	defer behind.Destroy()
+	spill, err := NewSpillMap(SpillConfig{Dir: t.TempDir(), Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.Destroy()
+	near := NewCluster(ClusterConfig{Transport: NewClusterLocalTransport(), NearCacheTTL: time.Minute})
+	defer near.Destroy()
+	meters := []MemoryMeter{counters, crdt, tiered, behind, spill, near}
+	empty := make([]int64, len(meters))
+	for i, m := range meters {
+		empty[i] = m.MemoryUsage()
+	}
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i)
+		counters.Incr(key)
+		crdt.Store(key, key)
+		tiered.Store(key, key)
+		behind.Store(key, key)
+		spill.Store(key, key)
+		near.cacheWithTTL(key, key, time.Minute)
+	}
+	for i, m := range meters {
+		if size := m.MemoryUsage(); size <= empty[i] {
+			t.Fatalf("%T usage not grown: %d -> %d", m, empty[i], size)
+		}
+	}
+	// 待写入的修改写出后不再计算
+	before := tiered.MemoryUsage()
+	tiered.Flush()
+	if size := tiered.MemoryUsage(); size >= before {
+		t.Fatal("flushed writes still counted", before, size)
+	}
+	if size := tiered.MemoryUsage(); size != tiered.l1.MemoryUsage() {
+		t.Fatal("TieredMap usage", size, tiered.l1.MemoryUsage())
+	}
+	if size, want := spill.MemoryUsage(), spill.mem.MemoryUsage()+mapHeaderSize+9*(spillLocEntrySize+1); size != want {
+		t.Fatal("SpillMap usage", size, want)
+	}
+}
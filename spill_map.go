@@ -0,0 +1,657 @@
+package gomap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// SpillConfig SpillMap配置
+	SpillConfig struct {
+		Dir             string                      // 溢出文件目录，段文件写在其中新建的子目录内，不影响目录中的其他文件
+		Capacity        int64                       // 内存容量，超出时从最早写入的数据开始溢出到磁盘
+		Weigher         Weigher                     // 权重计算，为空时Capacity为最大数量
+		SegmentSize     int64                       // 单个段文件大小上限，默认64MB
+		CompactInterval time.Duration               // 压缩周期，默认1分钟
+		CompactRatio    float64                     // 段内无效数据占比达到该值时压缩，默认0.5
+		OnError         func(key string, err error) // 读写磁盘失败时回调，失败的溢出数据会丢失
+	}
+
+	// SpillMap 内存部分为有容量限制的LinkedMap，被淘汰的数据追加写入磁盘段文件，
+	// 由内存索引定位；Load在内存未命中时读取磁盘并将数据移回内存。
+	// 值通过gob编码，自定义类型需要先gob.Register。磁盘数据只作为内存的延伸，不在重启后恢复。
+	SpillMap struct {
+		mem      *LinkedMap
+		disk     *spillStore
+		mu       sync.Mutex             // 内存与磁盘间的转移与写入互斥
+		spillMu  sync.RWMutex           // 保护spilling与queue
+		spilling map[string]interface{} // 已从内存淘汰、尚未写入磁盘的数据，写入期间仍可读取
+		queue    []Entry                // 待写入磁盘的淘汰数据，按淘汰顺序
+		ratio    float64
+		onError  func(key string, err error)
+		exit     chan bool
+		done     chan bool
+	}
+
+	spillStore struct {
+		dir       string // 本store独占的子目录
+		segSize   int64
+		mu        sync.RWMutex
+		compactMu sync.Mutex            // 压缩互斥
+		index     map[string]spillLoc   // key所在位置
+		segments  map[int]*spillSegment // 全部段文件
+		active    *spillSegment         // 当前追加写入的段
+		nextID    int
+		gen       int // reset与close时递增，使进行中的压缩结果作废
+	}
+
+	spillSegment struct {
+		id   int
+		file *os.File
+		size int64 // 已写入字节数
+		live int64 // 仍被索引引用的字节数
+	}
+
+	spillLoc struct {
+		seg    *spillSegment
+		offset int64 // 记录起始位置，包括长度头
+		size   int64 // 记录总长度，包括长度头
+	}
+
+	spillRecord struct {
+		Key   string
+		Value interface{}
+	}
+)
+
+const (
+	spillHeaderSize = 4
+	spillSuffix     = ".seg"
+	spillDirPrefix  = "gomap-spill-"
+)
+
+func NewSpillMap(cfg SpillConfig) (*SpillMap, error) {
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 64 << 20
+	}
+	if cfg.CompactInterval <= 0 {
+		cfg.CompactInterval = time.Minute
+	}
+	if cfg.CompactRatio <= 0 || cfg.CompactRatio > 1 {
+		cfg.CompactRatio = 0.5
+	}
+	disk, err := openSpillStore(cfg.Dir, cfg.SegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	m := &SpillMap{
+		disk:     disk,
+		spilling: map[string]interface{}{},
+		ratio:    cfg.CompactRatio,
+		onError:  cfg.OnError,
+		exit:     make(chan bool),
+		done:     make(chan bool),
+	}
+	m.mem = NewBoundedLinkedMap(cfg.Capacity, cfg.Weigher, m.spill)
+	go m.compactLoop(cfg.CompactInterval)
+	return m, nil
+}
+
+func (m *SpillMap) report(key string, err error) {
+	if m.onError != nil {
+		m.onError(key, err)
+	}
+}
+
+//spill 内存淘汰回调，在LinkedMap的锁内调用，只记录淘汰的数据，由unlock在锁外写入磁盘
+func (m *SpillMap) spill(e Entry) {
+	m.spillMu.Lock()
+	m.spilling[e.Key] = e.Value
+	m.queue = append(m.queue, e)
+	m.spillMu.Unlock()
+}
+
+//unlock 将持有m.mu期间淘汰的数据编码写入磁盘后释放m.mu，写入完成前读取仍能从spilling得到
+func (m *SpillMap) unlock() {
+	m.spillMu.RLock()
+	queue := m.queue
+	m.spillMu.RUnlock()
+	for _, e := range queue {
+		if err := m.disk.put(e.Key, e.Value); err != nil {
+			m.report(e.Key, err)
+		}
+	}
+	if len(queue) > 0 {
+		m.spillMu.Lock()
+		m.queue = nil
+		m.spilling = map[string]interface{}{}
+		m.spillMu.Unlock()
+	}
+	m.mu.Unlock()
+}
+
+//loadSpilling 读取正在写入磁盘的数据
+func (m *SpillMap) loadSpilling(key string) (interface{}, bool) {
+	m.spillMu.RLock()
+	defer m.spillMu.RUnlock()
+	value, ok := m.spilling[key]
+	return value, ok
+}
+
+//promote 将磁盘中的数据移回内存，需持有m.mu并以unlock释放
+func (m *SpillMap) promote(key string) (interface{}, bool) {
+	value, ok, err := m.disk.take(key)
+	if err != nil {
+		m.report(key, err)
+		return nil, false
+	}
+	if ok {
+		m.mem.Store(key, value)
+	}
+	return value, ok
+}
+
+func (m *SpillMap) compactLoop(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.disk.compact(m.ratio); err != nil {
+				m.report("", err)
+			}
+		case <-m.exit:
+			return
+		}
+	}
+}
+
+func (m *SpillMap) Store(key string, value interface{}) {
+	m.mu.Lock()
+	defer m.unlock()
+	// 先删除磁盘上的旧值，新值可能在写入时即被淘汰到磁盘
+	m.disk.delete(key)
+	m.mem.Store(key, value)
+}
+
+func (m *SpillMap) Load(key string) (value interface{}, ok bool) {
+	if value, ok := m.mem.Load(key); ok {
+		return value, true
+	}
+	if value, ok := m.loadSpilling(key); ok {
+		return value, true
+	}
+	m.mu.Lock()
+	defer m.unlock()
+	if value, ok := m.mem.Load(key); ok {
+		return value, true
+	}
+	return m.promote(key)
+}
+
+func (m *SpillMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	m.mu.Lock()
+	defer m.unlock()
+	if actual, ok := m.mem.Load(key); ok {
+		return actual, true
+	}
+	if actual, ok := m.promote(key); ok {
+		return actual, true
+	}
+	m.mem.Store(key, value)
+	return value, false
+}
+
+func (m *SpillMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	m.mu.Lock()
+	defer m.unlock()
+	if _, ok := m.mem.Load(key); !ok {
+		m.promote(key)
+	}
+	m.mem.StoreOrCompare(key, value, compare)
+}
+
+func (m *SpillMap) Delete(key string) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value, ok := m.mem.Load(key); ok {
+		m.mem.Delete(key)
+		return value
+	}
+	value, _, err := m.disk.take(key)
+	if err != nil {
+		m.report(key, err)
+	}
+	return value
+}
+
+//Clear 清空内存与磁盘，返回全部数据，读取失败的磁盘数据不包括在内
+func (m *SpillMap) Clear() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.mem.Clear()
+	for _, key := range m.disk.keys() {
+		value, ok, err := m.disk.get(key)
+		if err != nil {
+			m.report(key, err)
+		} else if ok {
+			entries = append(entries, Entry{Key: key, Value: value})
+		}
+	}
+	if err := m.disk.reset(); err != nil {
+		m.report("", err)
+	}
+	return entries
+}
+
+//Range 先遍历内存再遍历磁盘，遍历期间发生的转移可能使数据被重复访问或遗漏
+func (m *SpillMap) Range(f func(key interface{}, value interface{}) bool) {
+	next := true
+	m.mem.Range(func(key, value interface{}) bool {
+		next = f(key, value)
+		return next
+	})
+	if !next {
+		return
+	}
+	for _, key := range m.disk.keys() {
+		value, ok, err := m.disk.get(key)
+		if err != nil {
+			m.report(key, err)
+			continue
+		}
+		if ok && !f(key, value) {
+			return
+		}
+	}
+}
+
+//Destroy 停止压缩，销毁内存数据并删除段文件及其子目录
+func (m *SpillMap) Destroy() {
+	select {
+	case <-m.exit:
+		panic(errors.New(ErrMapDestroyed))
+	default:
+	}
+	close(m.exit)
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mem.Destroy()
+	if err := m.disk.close(); err != nil {
+		m.report("", err)
+	}
+}
+
+func (m *SpillMap) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mem.Size() + m.disk.len()
+}
+
+//MemoryUsage 估算内存部分与磁盘索引占用的内存字节数，磁盘上的数据见DiskUsage
+func (m *SpillMap) MemoryUsage() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mem.MemoryUsage() + m.disk.memoryUsage()
+}
+
+//Spilled 磁盘中的数据数量
+func (m *SpillMap) Spilled() int {
+	return m.disk.len()
+}
+
+//DiskUsage 段文件占用的字节数，包括尚未压缩的无效数据
+func (m *SpillMap) DiskUsage() int64 {
+	return m.disk.usage()
+}
+
+//Compact 立即压缩无效数据占比达到CompactRatio的段文件
+func (m *SpillMap) Compact() error {
+	return m.disk.compact(m.ratio)
+}
+
+//openSpillStore 在dir中新建本store独占的子目录，只读写其中的段文件
+func openSpillStore(dir string, segSize int64) (*spillStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	own, err := ioutil.TempDir(dir, spillDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	s := &spillStore{
+		dir:     own,
+		segSize: segSize,
+	}
+	if err := s.reset(); err != nil {
+		os.RemoveAll(own)
+		return nil, err
+	}
+	return s, nil
+}
+
+//createSegment 创建新的段文件，需持有写锁
+func (s *spillStore) createSegment() (*spillSegment, error) {
+	name := filepath.Join(s.dir, fmt.Sprintf("%08d%s", s.nextID, spillSuffix))
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seg := &spillSegment{id: s.nextID, file: f}
+	s.nextID++
+	return seg, nil
+}
+
+//rotate 创建新的段文件作为当前写入段，需持有写锁
+func (s *spillStore) rotate() error {
+	seg, err := s.createSegment()
+	if err != nil {
+		return err
+	}
+	s.segments[seg.id] = seg
+	s.active = seg
+	return nil
+}
+
+//append 追加一条已编码的记录，需持有写锁
+func (s *spillStore) append(key string, record []byte) error {
+	if s.active.size > 0 && s.active.size+int64(len(record)) > s.segSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	seg := s.active
+	if _, err := seg.file.WriteAt(record, seg.size); err != nil {
+		return err
+	}
+	s.unindex(key)
+	s.index[key] = spillLoc{seg: seg, offset: seg.size, size: int64(len(record))}
+	seg.size += int64(len(record))
+	seg.live += int64(len(record))
+	return nil
+}
+
+//unindex 移除key的索引，需持有写锁
+func (s *spillStore) unindex(key string) (spillLoc, bool) {
+	loc, ok := s.index[key]
+	if ok {
+		delete(s.index, key)
+		loc.seg.live -= loc.size
+	}
+	return loc, ok
+}
+
+//read 读取记录，需持有锁；压缩时读取的非活动段不再写入，可以不持锁
+func (s *spillStore) read(loc spillLoc) ([]byte, error) {
+	record := make([]byte, loc.size)
+	if _, err := loc.seg.file.ReadAt(record, loc.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return record, nil
+}
+
+func encodeSpillRecord(key string, value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, spillHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(&spillRecord{Key: key, Value: value}); err != nil {
+		return nil, err
+	}
+	record := buf.Bytes()
+	binary.BigEndian.PutUint32(record, uint32(len(record)-spillHeaderSize))
+	return record, nil
+}
+
+func decodeSpillRecord(key string, record []byte) (interface{}, error) {
+	if len(record) < spillHeaderSize || int(binary.BigEndian.Uint32(record)) != len(record)-spillHeaderSize {
+		return nil, fmt.Errorf("spill record of %q corrupted", key)
+	}
+	var r spillRecord
+	if err := gob.NewDecoder(bytes.NewReader(record[spillHeaderSize:])).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.Key != key {
+		return nil, fmt.Errorf("spill record of %q corrupted", key)
+	}
+	return r.Value, nil
+}
+
+func (s *spillStore) put(key string, value interface{}) error {
+	record, err := encodeSpillRecord(key, value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		return errors.New(ErrMapDestroyed)
+	}
+	return s.append(key, record)
+}
+
+func (s *spillStore) get(key string) (interface{}, bool, error) {
+	s.mu.RLock()
+	loc, ok := s.index[key]
+	if !ok {
+		s.mu.RUnlock()
+		return nil, false, nil
+	}
+	record, err := s.read(loc)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := decodeSpillRecord(key, record)
+	return value, err == nil, err
+}
+
+//take 读取并移除key
+func (s *spillStore) take(key string) (interface{}, bool, error) {
+	s.mu.Lock()
+	loc, ok := s.unindex(key)
+	if !ok {
+		s.mu.Unlock()
+		return nil, false, nil
+	}
+	record, err := s.read(loc)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := decodeSpillRecord(key, record)
+	return value, err == nil, err
+}
+
+func (s *spillStore) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.unindex(key)
+	return ok
+}
+
+func (s *spillStore) keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *spillStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+//memoryUsage 估算索引占用的内存字节数
+func (s *spillStore) memoryUsage() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	size := int64(mapHeaderSize)
+	for key := range s.index {
+		size += spillLocEntrySize + int64(len(key))
+	}
+	return size
+}
+
+func (s *spillStore) usage() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var size int64
+	for _, seg := range s.segments {
+		size += seg.size
+	}
+	return size
+}
+
+//compact 将无效数据占比达到ratio的非活动段中的有效记录复制到新的段，并删除原文件。
+//非活动段不再写入，复制在锁外进行，完成后持锁将仍指向原位置的索引切换到新段
+func (s *spillStore) compact(ratio float64) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.mu.Lock()
+	if s.active == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	gen := s.gen
+	old := map[*spillSegment]bool{}
+	for _, seg := range s.segments {
+		if seg != s.active && float64(seg.size-seg.live) >= ratio*float64(seg.size) {
+			old[seg] = true
+		}
+	}
+	if len(old) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	type move struct {
+		key      string
+		from, to spillLoc
+	}
+	var moves []move
+	for key, loc := range s.index {
+		if old[loc.seg] {
+			moves = append(moves, move{key: key, from: loc})
+		}
+	}
+	var out *spillSegment
+	if len(moves) > 0 {
+		seg, err := s.createSegment()
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		out = seg
+	}
+	s.mu.Unlock()
+
+	// 按原位置顺序复制，读取连续
+	sort.Slice(moves, func(i, j int) bool {
+		a, b := moves[i].from, moves[j].from
+		return a.seg.id < b.seg.id || (a.seg.id == b.seg.id && a.offset < b.offset)
+	})
+	for i := range moves {
+		record, err := s.read(moves[i].from)
+		if err == nil {
+			_, err = out.file.WriteAt(record, out.size)
+		}
+		if err != nil {
+			out.file.Close()
+			os.Remove(out.file.Name())
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			if s.gen != gen {
+				// 原段已被清空或关闭时删除
+				return nil
+			}
+			return err
+		}
+		moves[i].to = spillLoc{seg: out, offset: out.size, size: moves[i].from.size}
+		out.size += moves[i].from.size
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen != gen {
+		// 复制期间store被清空或关闭
+		if out != nil {
+			out.file.Close()
+			os.Remove(out.file.Name())
+		}
+		return nil
+	}
+	for _, mv := range moves {
+		// 复制期间被删除或重新写入的key保持不变
+		if s.index[mv.key] == mv.from {
+			s.index[mv.key] = mv.to
+			mv.from.seg.live -= mv.to.size
+			out.live += mv.to.size
+		}
+	}
+	if out != nil {
+		s.segments[out.id] = out
+	}
+	// 原段不会再被写入，切换后已没有索引引用
+	var err error
+	for seg := range old {
+		delete(s.segments, seg.id)
+		seg.file.Close()
+		if e := os.Remove(seg.file.Name()); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+//reset 删除全部段文件并重新开始写入
+func (s *spillStore) reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	if err := s.removeAll(); err != nil {
+		return err
+	}
+	s.index = map[string]spillLoc{}
+	s.segments = map[int]*spillSegment{}
+	return s.rotate()
+}
+
+//close 删除全部段文件以及本store的子目录
+func (s *spillStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	err := s.removeAll()
+	s.index = map[string]spillLoc{}
+	s.segments = map[int]*spillSegment{}
+	s.active = nil
+	if e := os.Remove(s.dir); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+//removeAll 关闭并删除本store创建的全部段文件，需持有写锁
+func (s *spillStore) removeAll() error {
+	for _, seg := range s.segments {
+		seg.file.Close()
+		if err := os.Remove(seg.file.Name()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package gomap
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpillMap_SpillAndPromote(t *testing.T) {
+	m, err := NewSpillMap(SpillConfig{Dir: t.TempDir(), Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	if m.Size() != 100 || m.Spilled() != 90 {
+		t.Fatal("spill", m.Size(), m.Spilled())
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i {
+			t.Fatal("Load", i, v, ok)
+		}
+	}
+	if m.Size() != 100 {
+		t.Fatal("promote lost data", m.Size())
+	}
+	// 溢出后的写入与删除以最新值为准
+	m.Store("0", "new")
+	if v, _ := m.Load("0"); v != "new" {
+		t.Fatal("overwrite", v)
+	}
+	if v := m.Delete("50"); v != 50 {
+		t.Fatal("Delete", v)
+	}
+	if _, ok := m.Load("50"); ok {
+		t.Fatal("deleted key loaded")
+	}
+	if actual, loaded := m.LoadOrStore("1", -1); !loaded || actual != 1 {
+		t.Fatal("LoadOrStore", actual, loaded)
+	}
+	n := 0
+	m.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	if n != 99 {
+		t.Fatal("Range", n)
+	}
+	if entries := m.Clear(); len(entries) != 99 || m.Size() != 0 || m.DiskUsage() != 0 {
+		t.Fatal("Clear", len(entries), m.Size(), m.DiskUsage())
+	}
+}
+
+func TestSpillMap_Compact(t *testing.T) {
+	m, err := NewSpillMap(SpillConfig{Dir: t.TempDir(), Capacity: 1, SegmentSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+	for i := 0; i < 200; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	before := m.DiskUsage()
+	for i := 0; i < 190; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	if err := m.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if m.DiskUsage() >= before/2 {
+		t.Fatal("not compacted", before, m.DiskUsage())
+	}
+	for i := 190; i < 200; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i {
+			t.Fatal("data lost after compaction", i, v, ok)
+		}
+	}
+}
+
+func TestSpillMap_Concurrent(t *testing.T) {
+	m, err := NewSpillMap(SpillConfig{Dir: t.TempDir(), Capacity: 8, SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := strconv.Itoa(g*1000 + i%20)
+				m.StoreOrCompare(key, 1, func(stored, input interface{}) interface{} {
+					return stored.(int) + input.(int)
+				})
+				if i%50 == 0 {
+					m.Compact()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for g := 0; g < 4; g++ {
+		for i := 0; i < 20; i++ {
+			if v, _ := m.Load(strconv.Itoa(g*1000 + i)); v != 10 {
+				t.Fatal("lost update", g, i, v)
+			}
+		}
+	}
+}
+
+//slowGob 编码时阻塞，直到测试放行
+type slowGob struct {
+	N int
+}
+
+var slowGobStarted, slowGobRelease = make(chan bool), make(chan bool)
+
+func (v slowGob) GobEncode() ([]byte, error) {
+	slowGobStarted <- true
+	<-slowGobRelease
+	return []byte(strconv.Itoa(v.N)), nil
+}
+
+func (v *slowGob) GobDecode(data []byte) (err error) {
+	v.N, err = strconv.Atoi(string(data))
+	return err
+}
+
+func TestSpillMap_SpillOutsideLock(t *testing.T) {
+	gob.Register(slowGob{})
+	m, err := NewSpillMap(SpillConfig{Dir: t.TempDir(), Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Destroy()
+	m.Store("a", slowGob{1})
+	go m.Store("b", 2)
+	<-slowGobStarted
+	// 编码写入磁盘期间内存不被锁定，被淘汰的数据仍可读取
+	loaded := make(chan bool)
+	go func() {
+		b, _ := m.Load("b")
+		a, _ := m.Load("a")
+		loaded <- b == 2 && a == slowGob{1}
+	}()
+	var ok, blocked bool
+	select {
+	case ok = <-loaded:
+	case <-time.After(time.Second):
+		blocked = true
+	}
+	slowGobRelease <- true
+	if blocked {
+		t.Fatal("Load blocked by spill")
+	}
+	if !ok {
+		t.Fatal("spilling entry not readable")
+	}
+	for m.Spilled() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if v, ok := m.Load("a"); !ok || v != (slowGob{1}) {
+		t.Fatal("spilled entry", v, ok)
+	}
+}
+
+func TestSpillMap_SharedDir(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other"+spillSuffix)
+	if err := ioutil.WriteFile(other, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewSpillMap(SpillConfig{Dir: dir, Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSpillMap(SpillConfig{Dir: dir, Capacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		a.Store(strconv.Itoa(i), i)
+		b.Store(strconv.Itoa(i), i)
+	}
+	a.Clear()
+	a.Destroy()
+	// 同一目录中的其他文件与其他SpillMap的段文件不受影响
+	if v, ok := b.Load("0"); !ok || v != 0 {
+		t.Fatal("other SpillMap lost data", v, ok)
+	}
+	b.Destroy()
+	if _, err := os.Stat(other); err != nil {
+		t.Fatal("unrelated file removed", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, spillDirPrefix+"*")); len(names) != 0 {
+		t.Fatal("spill directories left", names)
+	}
+}
@@ -0,0 +1,414 @@
+package gomap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Backend 二级存储
+	Backend interface {
+		Get(key string) (value interface{}, ok bool, err error)
+		Set(key string, value interface{}, ttl time.Duration) error // ttl<=0表示永不过期
+		Delete(key string) error
+	}
+
+	// BatchBackend 支持批量操作的二级存储，write-behind刷新时优先使用
+	BatchBackend interface {
+		Backend
+		SetMulti(entries []Entry, ttl time.Duration) error
+		DeleteMulti(keys []string) error
+	}
+
+	// BackendNotifier 二级存储中的key被删除或过期时通知，TieredMap据此使一级缓存失效
+	BackendNotifier interface {
+		OnDelete(f func(key string)) (cancel func())
+	}
+
+	// WriteMode 写入二级存储的方式
+	WriteMode int
+
+	// TieredConfig TieredMap配置
+	TieredConfig struct {
+		Backend       Backend
+		L1Expiration  time.Duration               // 一级缓存过期时间
+		L2TTL         time.Duration               // 写入二级存储的ttl，<=0表示永不过期
+		ReadThrough   bool                        // 一级缓存未命中时读取二级存储并回填
+		WriteMode     WriteMode                   // 写入方式
+		FlushInterval time.Duration               // write-behind刷新周期，默认1s
+		BatchSize     int                         // write-behind待写入数量达到该值时立即刷新，默认100
+		OnError       func(key string, err error) // 访问二级存储失败时回调，批量写入失败时key为空
+	}
+
+	// TieredMap 以TTLMap为一级缓存、Backend为二级存储的map。
+	// Range、Size只针对一级缓存；Clear清空一级缓存并从二级存储删除其中的key。
+	TieredMap struct {
+		keyLocks    [tieredKeyLocks]tieredKeyLock // 按key分段串行化一级缓存与二级存储的修改，放在首位保证gen按64位对齐
+		l1          *TTLMap
+		backend     Backend
+		l2TTL       time.Duration
+		readThrough bool
+		mode        WriteMode
+		onError     func(key string, err error)
+		cancel      func() // 取消二级存储删除通知
+
+		mu        sync.Mutex
+		dirty     map[string]tieredWrite // write-behind待写入，同一key只保留最后一次
+		flushed   map[string]tieredWrite // 正在写入二级存储的修改，写入完成前读取仍以此为准
+		flushing  sync.Mutex             // 同一时间只有一次刷新
+		batchSize int
+		flushCh   chan bool
+		exit      chan bool
+		done      chan bool
+	}
+
+	// tieredKeyLock 分段锁，gen在每次修改时递增，回填一级缓存前据此判断读取二级存储期间key是否被修改
+	tieredKeyLock struct {
+		gen uint64
+		sync.Mutex
+	}
+
+	tieredWrite struct {
+		value   interface{}
+		deleted bool
+	}
+)
+
+const (
+	WriteThrough WriteMode = iota // 同步写入二级存储
+	WriteBehind                   // 异步批量写入二级存储
+	WriteNone                     // 只写一级缓存，二级存储只读
+)
+
+const tieredKeyLocks = 64
+
+func NewTieredMap(cfg TieredConfig) *TieredMap {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	m := &TieredMap{
+		l1:          NewTTLMap(cfg.L1Expiration, 0, false),
+		backend:     cfg.Backend,
+		l2TTL:       cfg.L2TTL,
+		readThrough: cfg.ReadThrough,
+		mode:        cfg.WriteMode,
+		onError:     cfg.OnError,
+		dirty:       map[string]tieredWrite{},
+		batchSize:   cfg.BatchSize,
+		flushCh:     make(chan bool, 1),
+		exit:        make(chan bool),
+		done:        make(chan bool),
+	}
+	if n, ok := cfg.Backend.(BackendNotifier); ok {
+		m.cancel = n.OnDelete(m.invalidate)
+	}
+	if m.mode == WriteBehind {
+		go m.flushLoop(cfg.FlushInterval)
+	} else {
+		close(m.done)
+	}
+	return m
+}
+
+func (m *TieredMap) keyLock(key string) *tieredKeyLock {
+	return &m.keyLocks[hashKey(key)%tieredKeyLocks]
+}
+
+//lock 锁定key所在分段并标记修改，一级缓存与二级存储的修改在锁内完成，保证两者顺序一致
+func (m *TieredMap) lock(key string) *tieredKeyLock {
+	l := m.keyLock(key)
+	l.Lock()
+	atomic.AddUint64(&l.gen, 1)
+	return l
+}
+
+//invalidate 二级存储删除key时使一级缓存失效，尚未写入的本地修改不受影响。
+//二级存储可能在写入时同步回调，因此不加分段锁，只标记修改使正在进行的回填放弃
+func (m *TieredMap) invalidate(key string) {
+	if _, pending := m.pending(key); !pending {
+		atomic.AddUint64(&m.keyLock(key).gen, 1)
+		m.l1.Delete(key)
+	}
+}
+
+func (m *TieredMap) report(key string, err error) {
+	if m.onError != nil {
+		m.onError(key, err)
+	}
+}
+
+//write 按写入方式将修改同步到二级存储
+func (m *TieredMap) write(key string, value interface{}, deleted bool) {
+	switch m.mode {
+	case WriteThrough:
+		var err error
+		if deleted {
+			err = m.backend.Delete(key)
+		} else {
+			err = m.backend.Set(key, value, m.l2TTL)
+		}
+		if err != nil {
+			m.report(key, err)
+		}
+	case WriteBehind:
+		m.mu.Lock()
+		m.dirty[key] = tieredWrite{value: value, deleted: deleted}
+		full := len(m.dirty) >= m.batchSize
+		m.mu.Unlock()
+		if full {
+			select {
+			case m.flushCh <- true:
+			default:
+			}
+		}
+	}
+}
+
+func (m *TieredMap) flushLoop(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.flushCh:
+		case <-m.exit:
+			m.Flush()
+			return
+		}
+		m.Flush()
+	}
+}
+
+//Flush 将write-behind待写入的修改写入二级存储，失败的修改保留到下次刷新
+func (m *TieredMap) Flush() error {
+	m.flushing.Lock()
+	defer m.flushing.Unlock()
+	m.mu.Lock()
+	pending := m.dirty
+	m.dirty = map[string]tieredWrite{}
+	m.flushed = pending
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	var sets []Entry
+	var deletes []string
+	for key, w := range pending {
+		if w.deleted {
+			deletes = append(deletes, key)
+		} else {
+			sets = append(sets, Entry{Key: key, Value: w.value})
+		}
+	}
+	failed := map[string]tieredWrite{}
+	var lastErr error
+	if bb, ok := m.backend.(BatchBackend); ok {
+		if len(sets) > 0 {
+			if err := bb.SetMulti(sets, m.l2TTL); err != nil {
+				lastErr = err
+				m.report("", err)
+				for _, e := range sets {
+					failed[e.Key] = pending[e.Key]
+				}
+			}
+		}
+		if len(deletes) > 0 {
+			if err := bb.DeleteMulti(deletes); err != nil {
+				lastErr = err
+				m.report("", err)
+				for _, key := range deletes {
+					failed[key] = pending[key]
+				}
+			}
+		}
+	} else {
+		for _, e := range sets {
+			if err := m.backend.Set(e.Key, e.Value, m.l2TTL); err != nil {
+				lastErr = err
+				m.report(e.Key, err)
+				failed[e.Key] = pending[e.Key]
+			}
+		}
+		for _, key := range deletes {
+			if err := m.backend.Delete(key); err != nil {
+				lastErr = err
+				m.report(key, err)
+				failed[key] = pending[key]
+			}
+		}
+	}
+	m.mu.Lock()
+	for key, w := range failed {
+		// 刷新期间有新的修改时以新修改为准
+		if _, ok := m.dirty[key]; !ok {
+			m.dirty[key] = w
+		}
+	}
+	m.flushed = nil
+	m.mu.Unlock()
+	return lastErr
+}
+
+//pending 尚未写入二级存储的修改，待写入的优先于正在写入的
+func (m *TieredMap) pending(key string) (tieredWrite, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w, ok := m.dirty[key]; ok {
+		return w, true
+	}
+	w, ok := m.flushed[key]
+	return w, ok
+}
+
+//Pending write-behind待写入的key数量
+func (m *TieredMap) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.dirty)
+}
+
+//loadL2 读取二级存储，write-behind尚未写入的修改优先
+func (m *TieredMap) loadL2(key string) (interface{}, bool) {
+	if w, pending := m.pending(key); pending {
+		return w.value, !w.deleted
+	}
+	value, ok, err := m.backend.Get(key)
+	if err != nil {
+		m.report(key, err)
+		return nil, false
+	}
+	return value, ok
+}
+
+func (m *TieredMap) Store(key string, value interface{}) {
+	l := m.lock(key)
+	defer l.Unlock()
+	m.l1.Store(key, value)
+	m.write(key, value, false)
+}
+
+//Load 一级缓存未命中时读取二级存储回填，读取期间key被修改则丢弃读到的值重新读取，避免回填已删除或过时的值
+func (m *TieredMap) Load(key string) (value interface{}, ok bool) {
+	for {
+		if value, ok := m.l1.Load(key); ok {
+			return value, true
+		}
+		if !m.readThrough {
+			return nil, false
+		}
+		l := m.keyLock(key)
+		gen := atomic.LoadUint64(&l.gen)
+		value, ok = m.loadL2(key)
+		if !ok {
+			return nil, false
+		}
+		l.Lock()
+		if atomic.LoadUint64(&l.gen) == gen {
+			value, _ = m.l1.LoadOrStore(key, value)
+			l.Unlock()
+			return value, true
+		}
+		l.Unlock()
+	}
+}
+
+func (m *TieredMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	if actual, ok := m.Load(key); ok {
+		return actual, true
+	}
+	l := m.lock(key)
+	defer l.Unlock()
+	if actual, loaded = m.l1.LoadOrStore(key, value); !loaded {
+		m.write(key, value, false)
+	}
+	return actual, loaded
+}
+
+//StoreOrCompare 一级缓存未命中时先按ReadThrough回填，再在一级缓存上比较并存储
+func (m *TieredMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	m.Load(key)
+	l := m.lock(key)
+	defer l.Unlock()
+	actual, _ := m.l1.Update(key, func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		if loaded && compare != nil {
+			return compare(stored, value), DefaultExpiration, true
+		}
+		return value, DefaultExpiration, true
+	})
+	m.write(key, actual, false)
+}
+
+func (m *TieredMap) Delete(key string) interface{} {
+	l := m.lock(key)
+	defer l.Unlock()
+	value := m.l1.Delete(key)
+	if value == nil && m.readThrough {
+		value, _ = m.loadL2(key)
+	}
+	m.write(key, nil, true)
+	return value
+}
+
+func (m *TieredMap) Clear() []Entry {
+	// 锁定全部分段，清空完成前不会有写入或回填
+	for i := range m.keyLocks {
+		m.keyLocks[i].Lock()
+		atomic.AddUint64(&m.keyLocks[i].gen, 1)
+	}
+	defer func() {
+		for i := range m.keyLocks {
+			m.keyLocks[i].Unlock()
+		}
+	}()
+	entries := m.l1.Clear()
+	for _, e := range entries {
+		m.write(e.Key, nil, true)
+	}
+	return entries
+}
+
+func (m *TieredMap) Range(f func(key interface{}, value interface{}) bool) {
+	m.l1.Range(f)
+}
+
+//Destroy 刷新待写入的修改后销毁一级缓存
+func (m *TieredMap) Destroy() {
+	select {
+	case <-m.exit:
+		panic(errors.New(ErrMapDestroyed))
+	default:
+	}
+	close(m.exit)
+	<-m.done
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.l1.Destroy()
+}
+
+//MemoryUsage 估算一级缓存与write-behind待写入修改占用的内存字节数
+func (m *TieredMap) MemoryUsage() int64 {
+	size := m.l1.MemoryUsage()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, writes := range []map[string]tieredWrite{m.dirty, m.flushed} {
+		if len(writes) == 0 {
+			continue
+		}
+		size += mapHeaderSize
+		for key, w := range writes {
+			size += tieredWriteEntrySize + int64(len(key)) + DefaultSizer.Sizeof(w.value)
+		}
+	}
+	return size
+}
+
+func (m *TieredMap) Size() int {
+	return m.l1.Size()
+}
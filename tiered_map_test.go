@@ -0,0 +1,275 @@
+package gomap
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTieredMap_ReadWriteThrough(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1 := NewTieredMap(TieredConfig{Backend: backend, L1Expiration: NoExpiration, ReadThrough: true})
+	m2 := NewTieredMap(TieredConfig{Backend: backend, L1Expiration: NoExpiration, ReadThrough: true})
+	defer m1.Destroy()
+	defer m2.Destroy()
+
+	m1.Store("k", "v")
+	if v, ok, _ := backend.Get("k"); !ok || v != "v" {
+		t.Fatal("not written through", v, ok)
+	}
+	if v, ok := m2.Load("k"); !ok || v != "v" {
+		t.Fatal("not read through", v, ok)
+	}
+	if m2.Size() != 1 {
+		t.Fatal("L1 not filled")
+	}
+	// 二级存储删除时其他实例的一级缓存失效
+	if v := m1.Delete("k"); v != "v" {
+		t.Fatal("Delete", v)
+	}
+	if _, ok := m2.Load("k"); ok {
+		t.Fatal("L1 not invalidated")
+	}
+	m1.StoreOrCompare("n", 1, nil)
+	m2.StoreOrCompare("n", 1, func(stored, input interface{}) interface{} {
+		return stored.(int) + input.(int)
+	})
+	if v, _, _ := backend.Get("n"); v != 2 {
+		t.Fatal("StoreOrCompare", v)
+	}
+}
+
+func TestTieredMap_L2TTL(t *testing.T) {
+	backend, err := NewFileBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewTieredMap(TieredConfig{Backend: backend, L1Expiration: 20 * time.Millisecond, L2TTL: 50 * time.Millisecond, ReadThrough: true})
+	defer m.Destroy()
+	m.Store("k", 1)
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := m.Load("k"); !ok {
+		t.Fatal("L2 expired too early")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, ok, _ := backend.Get("k"); ok {
+		t.Fatal("L2 not expired")
+	}
+}
+
+type flakyBackend struct {
+	mu   sync.Mutex
+	data map[string]interface{}
+	fail bool
+	sets int
+}
+
+func (b *flakyBackend) Get(key string) (interface{}, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.data[key]
+	return v, ok, nil
+}
+
+func (b *flakyBackend) Set(key string, value interface{}, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail {
+		return errors.New("unavailable")
+	}
+	b.sets++
+	b.data[key] = value
+	return nil
+}
+
+func (b *flakyBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.fail {
+		return errors.New("unavailable")
+	}
+	delete(b.data, key)
+	return nil
+}
+
+func TestTieredMap_WriteBehind(t *testing.T) {
+	backend := &flakyBackend{data: map[string]interface{}{}, fail: true}
+	var errs int
+	m := NewTieredMap(TieredConfig{
+		Backend:       backend,
+		L1Expiration:  NoExpiration,
+		WriteMode:     WriteBehind,
+		FlushInterval: time.Hour,
+		OnError: func(key string, err error) {
+			errs++
+		},
+	})
+	for i := 0; i < 10; i++ {
+		m.Store("k", i)
+	}
+	m.Store("gone", 1)
+	m.Delete("gone")
+	if m.Pending() != 2 {
+		t.Fatal("writes not coalesced", m.Pending())
+	}
+	if err := m.Flush(); err == nil || errs == 0 || m.Pending() != 2 {
+		t.Fatal("failed writes not kept", err, m.Pending())
+	}
+	backend.mu.Lock()
+	backend.fail = false
+	backend.mu.Unlock()
+	m.Destroy()
+	if backend.sets != 1 || backend.data["k"] != 9 {
+		t.Fatal("not flushed on Destroy", backend.sets, backend.data)
+	}
+	if _, ok := backend.data["gone"]; ok {
+		t.Fatal("delete not flushed")
+	}
+}
+
+//slowBackend 写入时阻塞，直到测试放行
+type slowBackend struct {
+	flakyBackend
+	started chan bool
+	release chan bool
+}
+
+func (b *slowBackend) Set(key string, value interface{}, ttl time.Duration) error {
+	b.started <- true
+	<-b.release
+	return b.flakyBackend.Set(key, value, ttl)
+}
+
+func TestTieredMap_FlushInFlight(t *testing.T) {
+	backend := &slowBackend{
+		flakyBackend: flakyBackend{data: map[string]interface{}{}},
+		started:      make(chan bool),
+		release:      make(chan bool),
+	}
+	m := NewTieredMap(TieredConfig{
+		Backend:       backend,
+		L1Expiration:  NoExpiration,
+		ReadThrough:   true,
+		WriteMode:     WriteBehind,
+		FlushInterval: time.Hour,
+	})
+	m.Store("k", 1)
+	first := make(chan error)
+	go func() {
+		first <- m.Flush()
+	}()
+	<-backend.started
+	// 写入二级存储完成前一级缓存被淘汰，读取仍能得到正在写入的值
+	m.l1.Delete("k")
+	if v, ok := m.Load("k"); !ok || v != 1 {
+		t.Fatal("in-flight write not visible", v, ok)
+	}
+	// 同一时间只有一次刷新
+	m.Store("k", 2)
+	second := make(chan error)
+	go func() {
+		second <- m.Flush()
+	}()
+	select {
+	case <-second:
+		t.Fatal("concurrent flush")
+	case <-time.After(20 * time.Millisecond):
+	}
+	backend.release <- true
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	<-backend.started
+	backend.release <- true
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if backend.data["k"] != 2 {
+		t.Fatal("flush order", backend.data)
+	}
+	m.Destroy()
+}
+
+//slowGetBackend 第一次读取后阻塞，直到测试放行
+type slowGetBackend struct {
+	flakyBackend
+	blocked int32
+	started chan bool
+	release chan bool
+}
+
+func (b *slowGetBackend) Get(key string) (interface{}, bool, error) {
+	v, ok, err := b.flakyBackend.Get(key)
+	if atomic.CompareAndSwapInt32(&b.blocked, 0, 1) {
+		b.started <- true
+		<-b.release
+	}
+	return v, ok, err
+}
+
+func TestTieredMap_ReadThroughRace(t *testing.T) {
+	backend := &slowGetBackend{
+		flakyBackend: flakyBackend{data: map[string]interface{}{"k": "a"}},
+		started:      make(chan bool),
+		release:      make(chan bool),
+	}
+	m := NewTieredMap(TieredConfig{Backend: backend, L1Expiration: NoExpiration, ReadThrough: true})
+	defer m.Destroy()
+	type result struct {
+		value interface{}
+		ok    bool
+	}
+	load := func() chan result {
+		ch := make(chan result)
+		go func() {
+			v, ok := m.Load("k")
+			ch <- result{v, ok}
+		}()
+		<-backend.started
+		return ch
+	}
+	// 读取二级存储期间key被删除，不能回填已删除的值
+	ch := load()
+	m.Delete("k")
+	backend.release <- true
+	if r := <-ch; r.ok {
+		t.Fatal("deleted value loaded", r.value)
+	}
+	if v, ok := m.l1.Load("k"); ok {
+		t.Fatal("deleted value back-filled", v)
+	}
+	// 读取期间key被写入，回填不能覆盖新值
+	backend.flakyBackend.Set("k", "a", 0)
+	atomic.StoreInt32(&backend.blocked, 0)
+	ch = load()
+	m.Store("k", "b")
+	backend.release <- true
+	if r := <-ch; r.value != "b" {
+		t.Fatal("stale value loaded", r.value)
+	}
+	if v, _ := m.l1.Load("k"); v != "b" {
+		t.Fatal("stale value back-filled", v)
+	}
+
+	// 并发写入同一key，一级缓存与二级存储最终一致
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.Store("n", i*1000+j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	l1, _ := m.l1.Load("n")
+	if l2, _, _ := backend.Get("n"); l1 != l2 {
+		t.Fatal("L1 and L2 diverged", l1, l2)
+	}
+}
@@ -1,7 +1,9 @@
 package gomap
 
 import (
+	"context"
 	"errors"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -14,14 +16,40 @@ type (
 		gcInterval  time.Duration       // 清理周期
 		expiration  time.Duration       // 过期时间
 		renewOnLoad bool                // 读取时续租时间
+		watchers    watchers            // 订阅者
+		refresher   *refresher          // 软过期刷新
+		gcRunning   bool                // 清理协程已启动
+		policy      ExpirationPolicy    // 默认过期策略
+		accessKeys  bool                // 存在单独使用SlidingOnAccess策略的数据
+		gcBatch     int                 // 清理时每批最多删除的数量
+		gcConfig    *GCConfig           // 增量清理配置，nil时每次删除全部过期数据
+		gcStats     gcRecorder          // 增量清理统计
+		gcSlots     []string            // 所有key，供清理时分批扫描与随机抽样
+		gcCursor    int                 // 分批扫描的下一个位置
 	}
 
 	ttlEntry struct {
 		Entry
 		expiration int64
+		staleAt    int64             // 软过期时间戳，0表示不启用
+		created    int64             // 首次写入时间戳
+		policy     *ExpirationPolicy // 单独指定的过期策略，nil时使用map的策略
+		slot       int               // 在gcSlots中的位置
 	}
+
+	// UpdateFunc 根据key当前值计算新值与过期时间，store返回false时不做修改
+	UpdateFunc func(stored interface{}, loaded bool) (value interface{}, ttl time.Duration, store bool)
+)
+
+const (
+	DefaultExpiration time.Duration = 0  // 使用map默认过期时间
+	NoExpiration      time.Duration = -1 // 永不过期
+	KeepTTL           time.Duration = -2 // 保留原有过期时间，仅用于Update
+	ExpireNow         time.Duration = -3 // 立即过期，即删除key，仅用于Update
 )
 
+const defaultGCBatch = 1000
+
 func NewTTLMap(expiration, gcInterval time.Duration, renewOnLoad bool) *TTLMap {
 	m := &TTLMap{
 		expiration:  expiration,
@@ -30,13 +58,27 @@ func NewTTLMap(expiration, gcInterval time.Duration, renewOnLoad bool) *TTLMap {
 		mu:          sync.RWMutex{},
 		exit:        make(chan bool),
 		renewOnLoad: renewOnLoad,
+		policy:      policyFor(renewOnLoad),
+		gcBatch:     defaultGCBatch,
 	}
-	if expiration > 0 {
+	if expiration > 0 || gcInterval > 0 {
+		m.gcRunning = true
 		go m.gcLoop()
 	}
 	return m
 }
 
+//expireAt 根据ttl计算过期时间戳，-1表示永不过期
+func expireAt(ttl, def time.Duration) int64 {
+	if ttl == DefaultExpiration {
+		ttl = def
+	}
+	if ttl > 0 {
+		return time.Now().Add(ttl).UnixNano()
+	}
+	return -1
+}
+
 func (e *ttlEntry) expired() bool {
 	if e.expiration <= 0 {
 		return false
@@ -44,20 +86,29 @@ func (e *ttlEntry) expired() bool {
 	return time.Now().UnixNano() > e.expiration
 }
 
-func (e *ttlEntry) renew(expiration time.Duration) {
-	if expiration > 0 && e.expiration > 0 {
-		if e.expired() {
-			return
-		}
-		e.expiration = time.Now().Add(expiration).UnixNano()
+//deadline 过期时间，永不过期时为零值
+func (e *ttlEntry) deadline() time.Time {
+	if e.expiration <= 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, e.expiration)
+}
+
+//renew 按策略续租，def为map默认过期时间，返回是否更新了过期时间
+func (e *ttlEntry) renew(p *ExpirationPolicy, def time.Duration) bool {
+	if e.expiration <= 0 || e.expired() {
+		return false
+	}
+	expiration := p.expiration(def)
+	if expiration <= 0 {
+		return false
+	}
+	e.expiration = p.limit(expiration, e.created)
+	return true
 }
 
 //gcLoop 过期清理轮询
 func (m *TTLMap) gcLoop() {
-	if m.expiration <= 0 {
-		return
-	}
 	if m.gcInterval <= 0 {
 		m.gcInterval = 100 * time.Millisecond
 	}
@@ -65,7 +116,10 @@ func (m *TTLMap) gcLoop() {
 	for {
 		select {
 		case <-ticker.C:
-			m.DeleteExpired()
+			if !m.gc() {
+				ticker.Stop()
+				return
+			}
 		case <-m.exit:
 			ticker.Stop()
 			return
@@ -73,30 +127,214 @@ func (m *TTLMap) gcLoop() {
 	}
 }
 
+//gc 执行一次清理，设置了增量清理时按抽样清理，否则删除全部过期数据；map已销毁时返回false
+func (m *TTLMap) gc() bool {
+	m.mu.RLock()
+	cfg := m.gcConfig
+	m.mu.RUnlock()
+	if cfg == nil {
+		_, ok := m.deleteExpired()
+		return ok
+	}
+	p, ok := cfg.pass(m.gcRound)
+	if ok {
+		m.gcStats.record(p)
+	}
+	return ok
+}
+
+//gcRound 持锁随机抽取最多n个数据检查，删除其中过期的
+func (m *TTLMap) gcRound(n int) (examined, expired int, ok bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return 0, 0, false
+	}
+	now := time.Now().UnixNano()
+	for ; examined < n && len(m.gcSlots) > 0; examined++ {
+		key := m.gcSlots[rand.Intn(len(m.gcSlots))]
+		if item := m.entryMap[key]; item.expiration > 0 && now > item.expiration {
+			expired++
+			m.expire(item)
+		}
+	}
+	return examined, expired, true
+}
+
+//SetGCConfig 使后台清理改为增量抽样清理，每轮持锁时间有界
+func (m *TTLMap) SetGCConfig(cfg GCConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	cfg = cfg.withDefaults()
+	m.gcConfig = &cfg
+}
+
+//GCStats 增量清理的统计
+func (m *TTLMap) GCStats() GCStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.gcStats.get()
+}
+
+//schedule 数据设置了过期时间而清理协程尚未启动时启动清理，需持有写锁
+func (m *TTLMap) schedule(expiration int64) {
+	if expiration > 0 && !m.gcRunning {
+		m.gcRunning = true
+		go m.gcLoop()
+	}
+}
+
 //DeleteExpired 删除过期数据项
 func (m *TTLMap) DeleteExpired() map[string]interface{} {
+	deleted, ok := m.deleteExpired()
+	if !ok {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return deleted
+}
+
+//deleteExpired 从扫描序列开头分批持写锁检查，每批最多gcBatch个key，批间释放锁并从游标处继续；map已销毁时返回false，供gc协程使用以免与Destroy竞争
+func (m *TTLMap) deleteExpired() (map[string]interface{}, bool) {
+	deleted := map[string]interface{}{}
+	for restart := true; ; restart = false {
+		done, ok := m.deleteExpiredBatch(restart, deleted)
+		if !ok || done {
+			return deleted, ok
+		}
+	}
+}
+
+//deleteExpiredBatch 从游标处检查最多gcBatch个key并删除其中过期的，restart时从序列开头开始；扫描到序列末尾时done为true
+func (m *TTLMap) deleteExpiredBatch(restart bool, deleted map[string]interface{}) (done, ok bool) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
-		panic(errors.New(ErrMapDestroyed))
+		return false, false
+	}
+	if restart {
+		m.gcCursor = 0
 	}
 	now := time.Now().UnixNano()
-	deleted := map[string]interface{}{}
-	for key, v := range m.entryMap {
-		if v.expiration > 0 && now > v.expiration {
-			delete(m.entryMap, key)
-			deleted[key] = v.Value
+	for n := 0; m.gcCursor < len(m.gcSlots); n++ {
+		if m.gcBatch > 0 && n == m.gcBatch {
+			return false, true
+		}
+		item := m.entryMap[m.gcSlots[m.gcCursor]]
+		if item.expiration > 0 && now > item.expiration {
+			deleted[item.Key] = item.Value
+			m.expire(item)
+		} else {
+			m.gcCursor++
 		}
 	}
-	return deleted
+	return true, true
+}
+
+//expire 需持有写锁，删除过期的数据并通知
+func (m *TTLMap) expire(item ttlEntry) {
+	m.remove(item.Key)
+	m.watchers.notify(Event{Op: OpExpire, Key: item.Key, Old: item.Value})
+}
+
+//remove 需持有写锁，删除数据并将key移出gcSlots
+func (m *TTLMap) remove(key string) {
+	item, ok := m.entryMap[key]
+	if !ok {
+		return
+	}
+	delete(m.entryMap, key)
+	// 游标之前是本次扫描已检查过的key：先用其中最后一个填补空位并将游标前移，再用末尾的key填补腾出的位置，未检查的key不会被跳过
+	i, last := item.slot, len(m.gcSlots)-1
+	if i < m.gcCursor {
+		m.gcCursor--
+		m.moveSlot(m.gcCursor, i)
+		i = m.gcCursor
+	}
+	m.moveSlot(last, i)
+	m.gcSlots[last] = ""
+	m.gcSlots = m.gcSlots[:last]
+}
+
+//moveSlot 需持有写锁，将from位置的key移到to
+func (m *TTLMap) moveSlot(from, to int) {
+	if from == to {
+		return
+	}
+	key := m.gcSlots[from]
+	m.gcSlots[to] = key
+	item := m.entryMap[key]
+	item.slot = to
+	m.entryMap[key] = item
+}
+
+//track 需持有写锁，将新写入的key加入gcSlots并返回其位置
+func (m *TTLMap) track(key string) int {
+	m.gcSlots = append(m.gcSlots, key)
+	return len(m.gcSlots) - 1
+}
+
+//retrack 需持有写锁，按当前数据重建gcSlots，在整体替换数据后调用
+func (m *TTLMap) retrack() {
+	m.gcSlots, m.gcCursor = make([]string, 0, len(m.entryMap)), 0
+	for key, item := range m.entryMap {
+		item.slot = m.track(key)
+		m.entryMap[key] = item
+	}
+}
+
+//SetGCBatch 设置清理过期数据时每批持写锁检查的数量，每批之间释放锁并从停下的位置继续，<=0时一次检查全部，默认1000
+func (m *TTLMap) SetGCBatch(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	m.gcBatch = size
 }
 
 func (m *TTLMap) store(key string, value interface{}) {
-	var expiration int64
-	if m.expiration > 0 {
-		expiration = time.Now().Add(m.expiration).UnixNano()
-	} else {
-		expiration = -1
+	m.storeWith(key, value, nil)
+}
+
+//storeWith 按过期策略以默认过期时间写入，policy为nil时使用map的策略
+func (m *TTLMap) storeWith(key string, value interface{}, policy *ExpirationPolicy) {
+	p := policy
+	if p == nil {
+		p = &m.policy
+	}
+	var old *ttlEntry
+	if item, ok := m.entryMap[key]; ok && !item.expired() {
+		old = &item
+	}
+	expiration := p.writeExpiration(old, m.expiration)
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+	item := m.entryMap[key]
+	item.policy = policy
+	m.entryMap[key] = item
+}
+
+//storeAt 以指定过期时间写入，保留未过期数据的首次写入时间与过期策略
+func (m *TTLMap) storeAt(key string, value interface{}, expiration int64) {
+	m.refresher.written(key)
+	var old interface{}
+	created, policy := time.Now().UnixNano(), (*ExpirationPolicy)(nil)
+	item, ok := m.entryMap[key]
+	if ok && !item.expired() {
+		old = item.Value
+		created, policy = item.created, item.policy
+	}
+	slot := item.slot
+	if !ok {
+		slot = m.track(key)
 	}
 	m.entryMap[key] = ttlEntry{
 		Entry: Entry{
@@ -104,11 +342,18 @@ func (m *TTLMap) store(key string, value interface{}) {
 			Value: value,
 		},
 		expiration: expiration,
+		staleAt:    m.refresher.staleAt(),
+		created:    created,
+		policy:     policy,
+		slot:       slot,
 	}
+	item = m.entryMap[key]
+	m.watchers.notify(Event{Op: OpStore, Key: key, Old: old, New: value, ExpireAt: item.deadline()})
 }
 
 func (m *TTLMap) Store(key string, value interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -116,29 +361,111 @@ func (m *TTLMap) Store(key string, value interface{}) {
 	m.store(key, value)
 }
 
-func (m *TTLMap) Load(key string) (value interface{}, ok bool) {
+//lockLoad 读取时加锁，可能续租时加写锁；返回的函数解锁，加写锁时还会等待订阅者消费续租事件
+func (m *TTLMap) lockLoad() func() {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	if !m.renewOnLoad {
+		return m.mu.RUnlock
+	}
+	m.mu.RUnlock()
+	m.mu.Lock()
+	return func() {
+		m.mu.Unlock()
+		m.watchers.wait()
+	}
+}
+
+func (m *TTLMap) Load(key string) (value interface{}, ok bool) {
+	defer m.lockLoad()()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 	item, ok := m.entryMap[key]
 	if ok {
 		if !item.expired() {
+			m.refresher.check(key, &item, m.refreshed)
 			if m.renewOnLoad {
-				item.renew(m.expiration)
-				m.entryMap[key] = item
+				if m.renewOnAccess(&item) {
+					m.entryMap[key] = item
+					m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+				}
 			}
 			return item.Value, true
-		} else {
-			delete(m.entryMap, key)
 		}
+		// 过期数据由DeleteExpired清理
 	}
 	return nil, false
 }
 
+//policyOf 数据生效的过期策略
+func (m *TTLMap) policyOf(item *ttlEntry) *ExpirationPolicy {
+	if item.policy != nil {
+		return item.policy
+	}
+	return &m.policy
+}
+
+//renewOnAccess 读取时按SlidingOnAccess策略续租，返回是否更新了过期时间
+func (m *TTLMap) renewOnAccess(item *ttlEntry) bool {
+	p := m.policyOf(item)
+	return p.Mode == ExpireSlidingOnAccess && item.renew(p, m.expiration)
+}
+
+//SetExpirationPolicy 设置默认过期策略，对之后的写入与读取生效，单独指定策略的数据不受影响；Mode为ExpireDefault时保留当前计时方式
+func (m *TTLMap) SetExpirationPolicy(p ExpirationPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	p = p.inherit(m.policy.Mode)
+	m.policy = p
+	m.renewOnLoad = p.Mode == ExpireSlidingOnAccess || m.accessKeys
+	m.schedule(p.expiration(m.expiration))
+}
+
+//StoreWithPolicy 存储key-val并单独指定过期策略，之后不指定策略的写入恢复使用map的策略；Mode为ExpireDefault时使用map当前的计时方式
+func (m *TTLMap) StoreWithPolicy(key string, value interface{}, p ExpirationPolicy) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	p = p.inherit(m.policy.Mode)
+	if p.Mode == ExpireSlidingOnAccess {
+		m.accessKeys = true
+		m.renewOnLoad = true
+	}
+	m.storeWith(key, value, &p)
+}
+
+//SetRefresh 设置软过期与提前刷新，只对之后写入的数据计算软过期时间，刷新结果按默认过期时间写入
+func (m *TTLMap) SetRefresh(cfg RefreshConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	m.refresher = newRefresher(cfg)
+}
+
+//refreshed 写入刷新结果，key已被删除、过期、刷新期间被写入或map已销毁时丢弃
+func (m *TTLMap) refreshed(key string, value interface{}, fresh func() bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return
+	}
+	if item, ok := m.entryMap[key]; ok && !item.expired() && fresh() {
+		m.store(key, value)
+	}
+}
+
 func (m *TTLMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -146,8 +473,10 @@ func (m *TTLMap) LoadOrStore(key string, value interface{}) (actual interface{},
 	if item, ok := m.entryMap[key]; ok {
 		if !item.expired() {
 			if m.renewOnLoad {
-				item.renew(m.expiration)
-				m.entryMap[key] = item
+				if m.renewOnAccess(&item) {
+					m.entryMap[key] = item
+					m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+				}
 			}
 			return item.Value, true
 		}
@@ -158,6 +487,7 @@ func (m *TTLMap) LoadOrStore(key string, value interface{}) (actual interface{},
 
 func (m *TTLMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
@@ -176,15 +506,18 @@ func (m *TTLMap) StoreOrCompare(key string, value interface{}, compare func(stor
 
 func (m *TTLMap) Delete(key string) interface{} {
 	m.mu.Lock()
+	defer m.watchers.wait()
 	defer m.mu.Unlock()
 	if m.entryMap == nil {
 		panic(errors.New(ErrMapDestroyed))
 	}
 	if val, ok := m.entryMap[key]; ok {
-		delete(m.entryMap, key)
+		m.remove(key)
 		if !val.expired() {
+			m.watchers.notify(Event{Op: OpDelete, Key: key, Old: val.Value})
 			return val.Value
 		}
+		m.watchers.notify(Event{Op: OpExpire, Key: key, Old: val.Value})
 	}
 	return nil
 }
@@ -198,7 +531,10 @@ func (m *TTLMap) Clear() []Entry {
 	now := time.Now().UnixNano()
 	deleted := m.entryMap
 	m.entryMap = map[string]ttlEntry{}
+	m.retrack()
+	m.watchers.notify(Event{Op: OpClear})
 	m.mu.Unlock()
+	m.watchers.wait()
 	var entries []Entry
 	for _, v := range deleted {
 		if v.expiration <= 0 || now <= v.expiration {
@@ -208,22 +544,53 @@ func (m *TTLMap) Clear() []Entry {
 	return entries
 }
 
+//Range 遍历未过期数据。需要续租时先在读锁下复制数据，在锁外调用f，再为遍历到的key续租，f中可以读写该map
 func (m *TTLMap) Range(f func(key interface{}, value interface{}) bool) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
 	if m.entryMap == nil {
+		m.mu.RUnlock()
 		panic(errors.New(ErrMapDestroyed))
 	}
-	for key, item := range m.entryMap {
-		if !item.expired() {
-			if m.renewOnLoad {
-				item.renew(m.expiration)
-				m.entryMap[key] = item
-			}
-			if !f(key, item.Value) {
+	if !m.renewOnLoad {
+		defer m.mu.RUnlock()
+		for key, item := range m.entryMap {
+			if !item.expired() && !f(key, item.Value) {
 				break
 			}
 		}
+		return
+	}
+	entries := make([]Entry, 0, len(m.entryMap))
+	for _, item := range m.entryMap {
+		if !item.expired() {
+			entries = append(entries, item.Entry)
+		}
+	}
+	m.mu.RUnlock()
+	visited := entries[:0]
+	for _, entry := range entries {
+		visited = append(visited, entry)
+		if !f(entry.Key, entry.Value) {
+			break
+		}
+	}
+	m.renewVisited(visited)
+}
+
+//renewVisited 为Range遍历到的数据续租，遍历期间被删除或过期的跳过
+func (m *TTLMap) renewVisited(entries []Entry) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		return
+	}
+	for _, entry := range entries {
+		item, ok := m.entryMap[entry.Key]
+		if ok && !item.expired() && m.renewOnAccess(&item) {
+			m.entryMap[entry.Key] = item
+			m.watchers.notify(Event{Op: OpRenew, Key: entry.Key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+		}
 	}
 }
 
@@ -235,6 +602,8 @@ func (m *TTLMap) Destroy() {
 	}
 	close(m.exit)
 	m.entryMap = nil
+	m.gcSlots = nil
+	m.watchers.closeAll()
 }
 
 func (m *TTLMap) Size() int {
@@ -245,3 +614,230 @@ func (m *TTLMap) Size() int {
 	}
 	return len(m.entryMap)
 }
+
+//MemoryUsage 估算map当前占用的内存字节数
+func (m *TTLMap) MemoryUsage() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	size := int64(mapHeaderSize)
+	for key, item := range m.entryMap {
+		size += ttlEntrySize + int64(len(key)) + DefaultSizer.Sizeof(item.Value)
+	}
+	return size
+}
+
+//StoreWithTTL 存储key-val并指定过期时间
+func (m *TTLMap) StoreWithTTL(key string, value interface{}, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if ttl == DefaultExpiration {
+		m.store(key, value)
+		return
+	}
+	expiration := expireAt(ttl, m.expiration)
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+}
+
+//StoreUntil 存储key-val并在t时刻过期，返回是否存入；t不晚于当前时间时删除key，t为零值时不做修改
+func (m *TTLMap) StoreUntil(key string, value interface{}, t time.Time) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	if t.IsZero() {
+		return false
+	}
+	expiration := t.UnixNano()
+	if expiration <= time.Now().UnixNano() {
+		// 写入的数据立即过期，原有数据也不再可见
+		if item, ok := m.entryMap[key]; ok {
+			m.remove(key)
+			m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		}
+		return false
+	}
+	m.schedule(expiration)
+	m.storeAt(key, value, expiration)
+	return true
+}
+
+//Update 在锁内根据key当前值计算并存储新值，返回最终值以及是否存入；f返回ExpireNow时删除key，最终值为nil
+func (m *TTLMap) Update(key string, f UpdateFunc) (actual interface{}, stored bool) {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, loaded := m.entryMap[key]
+	if loaded && item.expired() {
+		item, loaded = ttlEntry{}, false
+	}
+	value, ttl, store := f(item.Value, loaded)
+	if !store {
+		return item.Value, false
+	}
+	if ttl == ExpireNow {
+		if loaded {
+			m.remove(key)
+			m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		}
+		return nil, true
+	}
+	if ttl == KeepTTL && loaded {
+		m.storeAt(key, value, item.expiration)
+	} else {
+		if ttl == KeepTTL || ttl == DefaultExpiration {
+			m.store(key, value)
+			return value, true
+		}
+		expiration := expireAt(ttl, m.expiration)
+		m.schedule(expiration)
+		m.storeAt(key, value, expiration)
+	}
+	return value, true
+}
+
+//TTL 返回key剩余存活时间，永不过期时返回NoExpiration，key不存在时ok为false
+func (m *TTLMap) TTL(key string) (ttl time.Duration, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return 0, false
+	}
+	if item.expiration <= 0 {
+		return NoExpiration, true
+	}
+	return time.Duration(item.expiration - time.Now().UnixNano()), true
+}
+
+//Expire 重新设置key的过期时间，ttl<=0时key立即过期，key不存在时返回false
+func (m *TTLMap) Expire(key string, ttl time.Duration) bool {
+	return m.ExpireAt(key, time.Now().Add(ttl))
+}
+
+//ExpireAt 将key的过期时间设置为t，t不晚于当前时间时key立即过期，key不存在时返回false
+func (m *TTLMap) ExpireAt(key string, t time.Time) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return false
+	}
+	expiration := t.UnixNano()
+	if expiration <= time.Now().UnixNano() {
+		m.remove(key)
+		m.watchers.notify(Event{Op: OpExpire, Key: key, Old: item.Value})
+		return true
+	}
+	item.expiration = expiration
+	m.entryMap[key] = item
+	m.schedule(expiration)
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+	return true
+}
+
+//ExpiresAt 返回key的过期时间，永不过期时为零值，key不存在时ok为false
+func (m *TTLMap) ExpiresAt(key string) (t time.Time, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return time.Time{}, false
+	}
+	return item.deadline(), true
+}
+
+//Touch 按默认过期时间续租，效果与renewOnLoad时的读取相同；key不存在、永不过期或map无默认过期时间时返回false
+func (m *TTLMap) Touch(key string) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || !item.renew(m.policyOf(&item), m.expiration) {
+		return false
+	}
+	m.entryMap[key] = item
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value, ExpireAt: item.deadline()})
+	return true
+}
+
+//Peek 读取key的值，不续租也不触发刷新
+func (m *TTLMap) Peek(key string) (value interface{}, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+//PeekRange 遍历未过期数据及其过期时刻，永不过期时为零值；不续租也不触发刷新，f中不能修改该map
+func (m *TTLMap) PeekRange(f func(key string, value interface{}, expiresAt time.Time) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	for key, item := range m.entryMap {
+		if !item.expired() && !f(key, item.Value, item.deadline()) {
+			break
+		}
+	}
+}
+
+//Persist 移除key的过期时间，key不存在或本就永不过期时返回false
+func (m *TTLMap) Persist(key string) bool {
+	m.mu.Lock()
+	defer m.watchers.wait()
+	defer m.mu.Unlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	item, ok := m.entryMap[key]
+	if !ok || item.expired() || item.expiration <= 0 {
+		return false
+	}
+	item.expiration = -1
+	m.entryMap[key] = item
+	m.watchers.notify(Event{Op: OpRenew, Key: key, Old: item.Value, New: item.Value})
+	return true
+}
+
+//Watch 订阅变更事件，ctx结束或map销毁时关闭通道
+func (m *TTLMap) Watch(ctx context.Context, filter WatchFilter, opts ...WatchOption) <-chan Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.entryMap == nil {
+		panic(errors.New(ErrMapDestroyed))
+	}
+	return m.watchers.watch(ctx, filter, opts)
+}
@@ -87,6 +87,28 @@ func TestTTLMap_Range(t *testing.T) {
 	})
 }
 
+func TestTTLMap_RangeReentrant(t *testing.T) {
+	m := NewTTLMap(time.Minute, -1, true)
+	defer m.Destroy()
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	at, _ := m.ExpiresAt("0")
+	time.Sleep(time.Millisecond)
+	// renewOnLoad时f在锁外执行，可以读写该map
+	m.Range(func(key interface{}, value interface{}) bool {
+		m.Load(key.(string))
+		m.Store(key.(string)+"_copy", value)
+		return true
+	})
+	if m.Size() != 20 {
+		t.Fatal("size", m.Size())
+	}
+	if now, _ := m.ExpiresAt("0"); !now.After(at) {
+		t.Fatal("Range did not renew")
+	}
+}
+
 func TestTTLMap_Destroy(t *testing.T) {
 	m := NewTTLMap(time.Second, time.Second, false)
 	m.Destroy()
@@ -110,3 +132,99 @@ func BenchmarkTTLMap_Store(b *testing.B) {
 		m.Store(key, i)
 	}
 }
+
+func TestTTLMap_StoreWithTTL(t *testing.T) {
+	m := NewTTLMap(-1, 100*time.Millisecond, false)
+	m.StoreWithTTL("1", 1, 200*time.Millisecond)
+	m.StoreWithTTL("2", 2, NoExpiration)
+	t.Log(m.TTL("1"))
+	t.Log(m.TTL("2"))
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := m.Load("1"); ok {
+		t.Fatal("key 1 should be expired")
+	}
+	if _, ok := m.TTL("1"); ok {
+		t.Fatal("key 1 should be expired")
+	}
+}
+
+func TestTTLMap_Update(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	add := func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return 1, time.Second, !loaded
+	}
+	t.Log(m.Update("1", add))
+	t.Log(m.Update("1", add))
+	t.Log(m.Update("1", func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return stored.(int) + 1, KeepTTL, loaded
+	}))
+	if ttl, _ := m.TTL("1"); ttl <= 0 || ttl > time.Second {
+		t.Fatal("ttl should be kept", ttl)
+	}
+	m.Update("1", func(stored interface{}, loaded bool) (interface{}, time.Duration, bool) {
+		return nil, ExpireNow, true
+	})
+	if _, ok := m.Peek("1"); ok || m.Size() != 0 {
+		t.Fatal("ExpireNow should delete the key")
+	}
+}
+
+func TestTTLMap_ExpirePersist(t *testing.T) {
+	m := NewTTLMap(time.Second, time.Second, false)
+	m.Store("1", 1)
+	t.Log(m.Persist("1"))
+	t.Log(m.TTL("1"))
+	t.Log(m.Expire("1", time.Minute))
+	t.Log(m.TTL("1"))
+	t.Log(m.Expire("1", 0))
+	t.Log(m.Load("1"))
+	t.Log(m.Expire("x", time.Minute))
+}
+
+func TestTTLMap_TTLAPI(t *testing.T) {
+	m := NewTTLMap(time.Minute, -1, true)
+	defer m.Destroy()
+	m.Store("1", 1)
+	at, _ := m.ExpiresAt("1")
+	if v, ok := m.Peek("1"); !ok || v != 1 {
+		t.Fatal("Peek", v, ok)
+	}
+	if now, _ := m.ExpiresAt("1"); !now.Equal(at) {
+		t.Fatal("Peek renewed", at, now)
+	}
+	time.Sleep(time.Millisecond)
+	if !m.Touch("1") {
+		t.Fatal("Touch")
+	}
+	if now, _ := m.ExpiresAt("1"); !now.After(at) {
+		t.Fatal("Touch not renewed", at, now)
+	}
+	deadline := time.Now().Add(time.Hour)
+	if !m.ExpireAt("1", deadline) {
+		t.Fatal("ExpireAt")
+	}
+	if now, _ := m.ExpiresAt("1"); now.UnixNano() != deadline.UnixNano() {
+		t.Fatal("ExpiresAt", now, deadline)
+	}
+	if !m.Persist("1") || m.Touch("1") {
+		t.Fatal("persistent key should not be touched")
+	}
+	if now, ok := m.ExpiresAt("1"); !ok || !now.IsZero() {
+		t.Fatal("ExpiresAt of persistent key", now, ok)
+	}
+	if !m.ExpireAt("1", time.Now()) || m.Size() != 0 {
+		t.Fatal("ExpireAt in the past should expire key")
+	}
+}
+
+func TestTTLMap_ExpireSchedulesGC(t *testing.T) {
+	// 无默认过期时间也未设置清理周期时，设置过期时间后仍会被清理
+	m := NewTTLMap(NoExpiration, -1, false)
+	defer m.Destroy()
+	m.Store("1", 1)
+	m.Expire("1", 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	if m.Size() != 0 {
+		t.Fatal("expired key not collected")
+	}
+}
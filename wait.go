@@ -0,0 +1,29 @@
+package gomap
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrDestroyed = errors.New(ErrMapDestroyed)
+
+//wakeOnDone ctx结束时持锁广播cond，唤醒等待者检查ctx；返回的函数停止监听，需在释放锁后调用
+func wakeOnDone(ctx context.Context, l sync.Locker, cond *sync.Cond) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Lock()
+			cond.Broadcast()
+			l.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
@@ -0,0 +1,309 @@
+package gomap
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Op 变更类型
+	Op int
+
+	// Event 变更事件
+	Event struct {
+		Op       Op          // 变更类型
+		Key      string      // key，Clear事件为空
+		Old      interface{} // 变更前的值
+		New      interface{} // 变更后的值
+		ExpireAt time.Time   // Store、Renew事件的过期时间，零值表示永不过期
+		Err      error       // 订阅异常结束时，最后一个事件携带错误
+	}
+
+	// WatchFilter 订阅过滤条件，各字段为空时不过滤。
+	// 过滤在持有map锁时执行，因此只支持声明式条件而非回调。
+	WatchFilter struct {
+		Keys   []string // 指定key，Clear事件不受限制
+		Prefix string   // key前缀，Clear事件不受限制
+		Ops    []Op     // 事件类型
+	}
+
+	// OverflowPolicy 订阅缓冲区满时的处理策略
+	OverflowPolicy int
+
+	WatchOption func(w *watcher)
+
+	watcher struct {
+		filter WatchFilter
+		keys   map[string]struct{} // filter.Keys
+		buffer int                 // 缓冲大小
+		policy OverflowPolicy      // 溢出策略
+		mu     sync.Mutex          // 锁
+		cond   *sync.Cond          // 队列变化通知
+		queue  []Event             // 待投递事件
+		closed bool                // 不再接收事件
+		done   chan struct{}       // closed时关闭
+		ch     chan Event          // 投递通道
+	}
+
+	// watchers map上的订阅者集合，零值可用
+	watchers struct {
+		mu       sync.Mutex
+		set      map[*watcher]struct{}
+		count    int32 // 订阅者数量
+		blocking int32 // 使用OverflowBlock的订阅者数量
+	}
+)
+
+const (
+	OpStore  Op = iota + 1 // 写入
+	OpDelete               // 删除
+	OpExpire               // 过期
+	OpClear                // 清空
+	OpRenew                // 过期时间变化，包括续租、Expire、Persist
+	OpEvict                // 超出容量被淘汰
+)
+
+const (
+	OverflowDrop  OverflowPolicy = iota // 丢弃新事件
+	OverflowBlock                       // 在释放map锁后阻塞写入方，直到订阅者消费
+	OverflowClose                       // 关闭订阅，最后一个事件携带ErrWatchOverflow
+)
+
+// overflowGrow 缓冲区满时继续入队，既不丢弃也不阻塞写入方，仅供内部订阅使用
+const overflowGrow OverflowPolicy = -1
+
+const defaultWatchBuffer = 64
+
+var ErrWatchOverflow = errors.New("ErrWatchOverflow")
+
+func (op Op) String() string {
+	switch op {
+	case OpStore:
+		return "Store"
+	case OpDelete:
+		return "Delete"
+	case OpExpire:
+		return "Expire"
+	case OpClear:
+		return "Clear"
+	case OpRenew:
+		return "Renew"
+	case OpEvict:
+		return "Evict"
+	}
+	return "Unknown"
+}
+
+//WatchBuffer 设置订阅缓冲大小
+func WatchBuffer(size int) WatchOption {
+	return func(w *watcher) {
+		if size > 0 {
+			w.buffer = size
+		}
+	}
+}
+
+//WatchOverflow 设置缓冲区满时的处理策略
+func WatchOverflow(policy OverflowPolicy) WatchOption {
+	return func(w *watcher) {
+		w.policy = policy
+	}
+}
+
+//match 判断事件是否满足过滤条件
+func (w *watcher) match(ev Event) bool {
+	if len(w.filter.Ops) > 0 {
+		matched := false
+		for _, op := range w.filter.Ops {
+			if op == ev.Op {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if ev.Op == OpClear {
+		return true
+	}
+	if w.keys != nil {
+		if _, ok := w.keys[ev.Key]; !ok {
+			return false
+		}
+	}
+	return strings.HasPrefix(ev.Key, w.filter.Prefix)
+}
+
+//push 事件入队，不会阻塞；OverflowBlock时允许暂时超出缓冲，由写入方释放map锁后等待，overflowGrow时队列不设上限
+func (w *watcher) push(ev Event) {
+	if !w.match(ev) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if len(w.queue) >= w.buffer {
+		switch w.policy {
+		case OverflowDrop:
+			return
+		case OverflowClose:
+			w.queue = append(w.queue, Event{Err: ErrWatchOverflow})
+			w.shutdown()
+			return
+		}
+	}
+	w.queue = append(w.queue, ev)
+	w.cond.Broadcast()
+}
+
+//close 停止接收事件，已入队事件投递完后关闭通道
+func (w *watcher) close() {
+	w.mu.Lock()
+	w.shutdown()
+	w.mu.Unlock()
+}
+
+func (w *watcher) shutdown() {
+	if !w.closed {
+		w.closed = true
+		close(w.done)
+	}
+	w.cond.Broadcast()
+}
+
+//wait 等待队列回落到缓冲大小以内
+func (w *watcher) wait() {
+	w.mu.Lock()
+	for len(w.queue) > w.buffer && !w.closed {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
+
+//run 投递协程，将队列中的事件依次发送到通道
+func (w *watcher) run(ctx context.Context) {
+	defer close(w.ch)
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		ev := w.queue[0]
+		w.queue[0] = Event{}
+		w.queue = w.queue[1:]
+		w.cond.Broadcast()
+		w.mu.Unlock()
+		select {
+		case w.ch <- ev:
+		case <-ctx.Done():
+			w.close()
+			return
+		}
+	}
+}
+
+//watch 注册订阅者，ctx结束时自动取消
+func (ws *watchers) watch(ctx context.Context, filter WatchFilter, opts []WatchOption) <-chan Event {
+	w := &watcher{
+		filter: filter,
+		buffer: defaultWatchBuffer,
+		policy: OverflowDrop,
+		done:   make(chan struct{}),
+		ch:     make(chan Event),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	if len(filter.Keys) > 0 {
+		w.keys = map[string]struct{}{}
+		for _, key := range filter.Keys {
+			w.keys[key] = struct{}{}
+		}
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	ws.mu.Lock()
+	if ws.set == nil {
+		ws.set = map[*watcher]struct{}{}
+	}
+	ws.set[w] = struct{}{}
+	atomic.AddInt32(&ws.count, 1)
+	if w.policy == OverflowBlock {
+		atomic.AddInt32(&ws.blocking, 1)
+	}
+	ws.mu.Unlock()
+	go w.run(ctx)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.close()
+		case <-w.done:
+		}
+		ws.remove(w)
+	}()
+	return w.ch
+}
+
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.set[w]; ok {
+		delete(ws.set, w)
+		atomic.AddInt32(&ws.count, -1)
+		if w.policy == OverflowBlock {
+			atomic.AddInt32(&ws.blocking, -1)
+		}
+	}
+}
+
+//notify 向所有订阅者发送事件，需在持有map锁时调用，保证事件顺序与变更顺序一致
+func (ws *watchers) notify(ev Event) {
+	if atomic.LoadInt32(&ws.count) == 0 {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.set {
+		w.push(ev)
+	}
+}
+
+//wait 等待OverflowBlock订阅者消费，需在释放map锁后调用
+func (ws *watchers) wait() {
+	if atomic.LoadInt32(&ws.blocking) == 0 {
+		return
+	}
+	ws.mu.Lock()
+	var blocked []*watcher
+	for w := range ws.set {
+		if w.policy == OverflowBlock {
+			blocked = append(blocked, w)
+		}
+	}
+	ws.mu.Unlock()
+	for _, w := range blocked {
+		w.wait()
+	}
+}
+
+//closeAll 关闭所有订阅，用于map销毁
+func (ws *watchers) closeAll() {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.set {
+		w.close()
+		delete(ws.set, w)
+	}
+	atomic.StoreInt32(&ws.count, 0)
+	atomic.StoreInt32(&ws.blocking, 0)
+}
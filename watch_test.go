@@ -0,0 +1,175 @@
+package gomap
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestTTLMap_Watch(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := m.Watch(ctx, WatchFilter{})
+	m.Store("1", 1)
+	m.Store("1", 2)
+	m.Delete("1")
+	m.StoreWithTTL("2", 2, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	m.DeleteExpired()
+	m.Clear()
+	want := []Event{
+		{Op: OpStore, Key: "1", New: 1},
+		{Op: OpStore, Key: "1", Old: 1, New: 2},
+		{Op: OpDelete, Key: "1", Old: 2},
+		{Op: OpStore, Key: "2", New: 2},
+		{Op: OpExpire, Key: "2", Old: 2},
+		{Op: OpClear},
+	}
+	for _, w := range want {
+		ev := receive(t, ch)
+		ev.ExpireAt = time.Time{}
+		if ev != w {
+			t.Fatalf("want %+v, got %+v", w, ev)
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestLinkedMap_Watch(t *testing.T) {
+	m := NewLinkedMap()
+	ch := m.Watch(context.Background(), WatchFilter{Prefix: "a", Ops: []Op{OpStore}})
+	m.Store("b", 1)
+	m.Store("a1", 1)
+	m.Delete("a1")
+	m.StoreOrCompare("a2", 1, nil)
+	t.Log(receive(t, ch))
+	t.Log(receive(t, ch))
+	m.Destroy()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed after Destroy")
+	}
+}
+
+func TestLinkedTTLMap_Watch(t *testing.T) {
+	m := NewLinkedTTLMap(-1, -1, false)
+	ch := m.Watch(context.Background(), WatchFilter{Keys: []string{"1"}})
+	m.Store("1", 1)
+	m.Store("2", 2)
+	m.Expire("1", 0)
+	t.Log(receive(t, ch))
+	if ev := receive(t, ch); ev.Op != OpExpire {
+		t.Fatal("want expire event", ev)
+	}
+	m.Destroy()
+}
+
+func TestWatch_OverflowDrop(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	ch := m.Watch(context.Background(), WatchFilter{}, WatchBuffer(2))
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	m.Destroy()
+	var n int
+	for range ch {
+		n++
+	}
+	// 投递协程已取出一个事件等待发送，缓冲中最多再保留2个
+	if n > 3 {
+		t.Fatal("events should be dropped", n)
+	}
+}
+
+func TestWatch_OverflowClose(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	ch := m.Watch(context.Background(), WatchFilter{}, WatchBuffer(2), WatchOverflow(OverflowClose))
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	var last Event
+	for ev := range ch {
+		last = ev
+	}
+	if last.Err != ErrWatchOverflow {
+		t.Fatal("want overflow error", last)
+	}
+}
+
+func TestWatch_OverflowBlock(t *testing.T) {
+	m := NewTTLMap(-1, -1, false)
+	ch := m.Watch(context.Background(), WatchFilter{}, WatchBuffer(1), WatchOverflow(OverflowBlock))
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		ev := receive(t, ch)
+		if ev.Key != strconv.Itoa(i) {
+			t.Fatal("event lost", i, ev)
+		}
+		// 消费者访问map不会与写入方死锁
+		m.Load(ev.Key)
+	}
+	<-done
+}
+
+func TestTTLMap_WatchRenew(t *testing.T) {
+	m := NewTTLMap(time.Minute, time.Minute, true)
+	ch := m.Watch(context.Background(), WatchFilter{Ops: []Op{OpRenew}})
+	m.Store("1", 1)
+	m.Load("1")
+	if ev := receive(t, ch); ev.ExpireAt.IsZero() {
+		t.Fatal("renew event should carry expiration", ev)
+	}
+	m.Persist("1")
+	if ev := receive(t, ch); !ev.ExpireAt.IsZero() {
+		t.Fatal("persist event should have no expiration", ev)
+	}
+	m.Destroy()
+}
+
+func TestWatch_OverflowBlockLinkedTTLMap(t *testing.T) {
+	m := NewLinkedTTLMap(-1, -1, false)
+	ch := m.Watch(context.Background(), WatchFilter{}, WatchBuffer(1), WatchOverflow(OverflowBlock))
+	// 多个写入方同时阻塞时队列超出缓冲，消费者取出一个事件后队列仍未回落
+	for w := 0; w < 10; w++ {
+		go func(w int) {
+			for i := 0; i < 10; i++ {
+				m.Store(strconv.Itoa(w*10+i), i)
+			}
+		}(w)
+	}
+	time.Sleep(20 * time.Millisecond)
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for i := 0; i < 100; i++ {
+			ev := <-ch
+			// 不续租的读取不等待订阅者，消费者读取map不会与阻塞的写入方死锁
+			m.Load(ev.Key)
+		}
+	}()
+	select {
+	case <-consumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("consumer deadlocked with blocked writer")
+	}
+}
@@ -0,0 +1,276 @@
+package gomap
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Write 一次待持久化的修改，Deleted为true时表示key已被删除或过期
+	Write struct {
+		Key     string
+		Value   interface{}
+		Deleted bool
+	}
+
+	// Sink 持久化目标，返回错误时整批修改会在退避后重试
+	Sink interface {
+		Flush(writes []Write) error
+	}
+
+	SinkFunc func(writes []Write) error
+
+	// WriteBehindConfig WriteBehindMap配置
+	WriteBehindConfig struct {
+		Sink          Sink
+		BatchSize     int           // 每批最多修改数，待写入数量达到该值时立即刷新，默认100
+		FlushInterval time.Duration // 刷新周期，默认1s
+		MinBackoff    time.Duration // 失败后的首次重试间隔，默认100ms
+		MaxBackoff    time.Duration // 最大重试间隔，默认10s
+		DestroyRetry  int           // Destroy时刷新失败的重试次数，默认3
+		OnError       func(err error)
+	}
+
+	// WriteBehindMap 包装任意Map，修改立即生效并记录为脏key，由后台批量写入Sink。
+	// 同一key的多次修改只写入最后的值：刷新时从map读取当前值，不存在时写入删除。
+	WriteBehindMap struct {
+		Map
+		sink         Sink
+		batchSize    int
+		interval     time.Duration
+		minBackoff   time.Duration
+		maxBackoff   time.Duration
+		destroyRetry int
+		onError      func(err error)
+
+		mu       sync.Mutex
+		dirty    map[string]struct{} // 待写入的key
+		flushing sync.Mutex          // 同一时间只有一次刷新
+		failures int64               // 刷新失败次数
+		lastErr  error               // 最近一次刷新错误，刷新成功后清空
+		flushCh  chan bool
+		exit     chan bool
+		done     chan bool
+	}
+)
+
+//MemoryUsage 估算被包装的map与待写入key占用的内存字节数，被包装的map不支持估算时只计算待写入key
+func (w *WriteBehindMap) MemoryUsage() int64 {
+	var size int64
+	if meter, ok := w.Map.(MemoryMeter); ok {
+		size = meter.MemoryUsage()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	size += mapHeaderSize
+	for key := range w.dirty {
+		size += keySetEntrySize + int64(len(key))
+	}
+	return size
+}
+
+func (f SinkFunc) Flush(writes []Write) error {
+	return f(writes)
+}
+
+func NewWriteBehindMap(m Map, cfg WriteBehindConfig) *WriteBehindMap {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	if cfg.DestroyRetry <= 0 {
+		cfg.DestroyRetry = 3
+	}
+	w := &WriteBehindMap{
+		Map:          m,
+		sink:         cfg.Sink,
+		batchSize:    cfg.BatchSize,
+		interval:     cfg.FlushInterval,
+		minBackoff:   cfg.MinBackoff,
+		maxBackoff:   cfg.MaxBackoff,
+		destroyRetry: cfg.DestroyRetry,
+		onError:      cfg.OnError,
+		dirty:        map[string]struct{}{},
+		flushCh:      make(chan bool, 1),
+		exit:         make(chan bool),
+		done:         make(chan bool),
+	}
+	go w.flushLoop()
+	return w
+}
+
+//mark 记录脏key，达到批量大小时触发刷新
+func (w *WriteBehindMap) mark(keys ...string) {
+	w.mu.Lock()
+	for _, key := range keys {
+		w.dirty[key] = struct{}{}
+	}
+	full := len(w.dirty) >= w.batchSize
+	w.mu.Unlock()
+	if full {
+		select {
+		case w.flushCh <- true:
+		default:
+		}
+	}
+}
+
+func (w *WriteBehindMap) Store(key string, value interface{}) {
+	w.Map.Store(key, value)
+	w.mark(key)
+}
+
+func (w *WriteBehindMap) LoadOrStore(key string, value interface{}) (actual interface{}, loaded bool) {
+	actual, loaded = w.Map.LoadOrStore(key, value)
+	if !loaded {
+		w.mark(key)
+	}
+	return actual, loaded
+}
+
+func (w *WriteBehindMap) StoreOrCompare(key string, value interface{}, compare func(stored interface{}, input interface{}) interface{}) {
+	w.Map.StoreOrCompare(key, value, compare)
+	w.mark(key)
+}
+
+func (w *WriteBehindMap) Delete(key string) interface{} {
+	value := w.Map.Delete(key)
+	w.mark(key)
+	return value
+}
+
+func (w *WriteBehindMap) Clear() []Entry {
+	entries := w.Map.Clear()
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Key
+	}
+	w.mark(keys...)
+	return entries
+}
+
+//flushLoop 定期刷新，失败后按指数退避重试
+func (w *WriteBehindMap) flushLoop() {
+	defer close(w.done)
+	backoff := time.Duration(0)
+	timer := time.NewTimer(w.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-w.flushCh:
+			if backoff > 0 {
+				// 退避期间不因批量触发提前重试
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-w.exit:
+			return
+		}
+		if err := w.Flush(); err != nil {
+			if backoff == 0 {
+				backoff = w.minBackoff
+			} else if backoff *= 2; backoff > w.maxBackoff {
+				backoff = w.maxBackoff
+			}
+			timer.Reset(backoff)
+			continue
+		}
+		backoff = 0
+		timer.Reset(w.interval)
+	}
+}
+
+//Flush 立即将全部脏key写入Sink，失败的批次重新记为脏key
+func (w *WriteBehindMap) Flush() error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+	w.mu.Lock()
+	keys := make([]string, 0, len(w.dirty))
+	for key := range w.dirty {
+		keys = append(keys, key)
+	}
+	w.dirty = map[string]struct{}{}
+	w.mu.Unlock()
+	sort.Strings(keys)
+	for start := 0; start < len(keys); start += w.batchSize {
+		end := start + w.batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]Write, 0, end-start)
+		for _, key := range keys[start:end] {
+			value, ok := w.peek(key)
+			batch = append(batch, Write{Key: key, Value: value, Deleted: !ok})
+		}
+		if err := w.sink.Flush(batch); err != nil {
+			w.mark(keys[start:]...)
+			w.mu.Lock()
+			w.failures++
+			w.lastErr = err
+			w.mu.Unlock()
+			if w.onError != nil {
+				w.onError(err)
+			}
+			return err
+		}
+	}
+	w.mu.Lock()
+	w.lastErr = nil
+	w.mu.Unlock()
+	return nil
+}
+
+//peek 读取当前值，map支持过期时使用Peek，刷新不会为renewOnLoad的map续租
+func (w *WriteBehindMap) peek(key string) (interface{}, bool) {
+	if em, ok := w.Map.(ExpirableMap); ok {
+		return em.Peek(key)
+	}
+	return w.Map.Load(key)
+}
+
+//Pending 待写入的key数量
+func (w *WriteBehindMap) Pending() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.dirty)
+}
+
+//FlushErrors 刷新失败的累计次数与最近一次错误，最近一次刷新成功时错误为nil
+func (w *WriteBehindMap) FlushErrors() (failures int64, last error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failures, w.lastErr
+}
+
+//Destroy 停止后台刷新，将剩余修改写入Sink（失败时按退避重试DestroyRetry次）后销毁map。
+// 重试仍失败时丢弃剩余修改，可通过FlushErrors查看。
+func (w *WriteBehindMap) Destroy() {
+	select {
+	case <-w.exit:
+		panic(errors.New(ErrMapDestroyed))
+	default:
+	}
+	close(w.exit)
+	<-w.done
+	backoff := w.minBackoff
+	for i := 0; w.Flush() != nil && i < w.destroyRetry; i++ {
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+	}
+	w.Map.Destroy()
+}
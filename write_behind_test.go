@@ -0,0 +1,146 @@
+package gomap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordSink struct {
+	mu      sync.Mutex
+	batches [][]Write
+	fails   int // 前fails次调用返回错误
+}
+
+func (s *recordSink) Flush(writes []Write) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("sink unavailable")
+	}
+	s.batches = append(s.batches, writes)
+	return nil
+}
+
+func (s *recordSink) writes() map[string]Write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := map[string]Write{}
+	for _, batch := range s.batches {
+		for _, w := range batch {
+			result[w.Key] = w
+		}
+	}
+	return result
+}
+
+func TestWriteBehindMap_Coalesce(t *testing.T) {
+	sink := &recordSink{}
+	m := NewWriteBehindMap(NewLinkedMap(), WriteBehindConfig{Sink: sink, FlushInterval: time.Hour})
+	for i := 0; i < 10; i++ {
+		m.Store("k", i)
+	}
+	m.Store("gone", 1)
+	m.Delete("gone")
+	if m.Pending() != 2 {
+		t.Fatal("Pending", m.Pending())
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.batches) != 1 || len(sink.batches[0]) != 2 {
+		t.Fatal("not coalesced", sink.batches)
+	}
+	writes := sink.writes()
+	if writes["k"].Value != 9 || !writes["gone"].Deleted {
+		t.Fatal("writes", writes)
+	}
+	m.Destroy()
+}
+
+func TestWriteBehindMap_BatchSize(t *testing.T) {
+	sink := &recordSink{}
+	m := NewWriteBehindMap(NewLinkedMap(), WriteBehindConfig{Sink: sink, BatchSize: 10, FlushInterval: time.Hour})
+	defer m.Destroy()
+	for i := 0; i < 25; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(sink.writes()) < 20 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for _, batch := range sink.batches {
+		if len(batch) > 10 {
+			t.Fatal("batch too large", len(batch))
+		}
+	}
+	if len(sink.batches) < 2 {
+		t.Fatal("size threshold not triggered", len(sink.batches))
+	}
+}
+
+func TestWriteBehindMap_Retry(t *testing.T) {
+	sink := &recordSink{fails: 2}
+	var errs int
+	var mu sync.Mutex
+	m := NewWriteBehindMap(NewLinkedMap(), WriteBehindConfig{
+		Sink:          sink,
+		FlushInterval: 10 * time.Millisecond,
+		MinBackoff:    10 * time.Millisecond,
+		OnError: func(err error) {
+			mu.Lock()
+			errs++
+			mu.Unlock()
+		},
+	})
+	defer m.Destroy()
+	m.Store("k", 1)
+	deadline := time.Now().Add(time.Second)
+	for len(sink.writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	failures, last := m.FlushErrors()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sink.writes()) != 1 || failures != 2 || errs != 2 || last != nil || m.Pending() != 0 {
+		t.Fatal("retry", failures, last, errs, m.Pending())
+	}
+}
+
+func TestWriteBehindMap_FlushOnDestroy(t *testing.T) {
+	sink := &recordSink{fails: 1}
+	m := NewWriteBehindMap(NewLinkedMap(), WriteBehindConfig{Sink: sink, FlushInterval: time.Hour, MinBackoff: time.Millisecond})
+	m.Store("a", 1)
+	m.LoadOrStore("b", 2)
+	m.Clear()
+	m.Store("c", 3)
+	m.Destroy()
+	writes := sink.writes()
+	if len(writes) != 3 || !writes["a"].Deleted || !writes["b"].Deleted || writes["c"].Value != 3 {
+		t.Fatal("not flushed on Destroy", writes)
+	}
+}
+
+func TestWriteBehindMap_FlushNoRenew(t *testing.T) {
+	sink := &recordSink{}
+	ttl := NewTTLMap(time.Minute, -1, true)
+	m := NewWriteBehindMap(ttl, WriteBehindConfig{Sink: sink, FlushInterval: time.Hour})
+	ttl.StoreWithTTL("a", 1, 50*time.Millisecond)
+	m.mark("a")
+	// 刷新读取当前值时不续租
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if w := sink.writes()["a"]; w.Value != 1 || w.Deleted {
+		t.Fatal("flushed", w)
+	}
+	if remaining, _ := ttl.TTL("a"); remaining > 50*time.Millisecond {
+		t.Fatal("entry renewed by flush", remaining)
+	}
+	m.Destroy()
+}